@@ -3,31 +3,113 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
-	DatabaseURL     string
-	EmailHost       string
-	EmailPort       int
-	EmailUsername   string
-	EmailPassword   string
-	SlackToken      string
-	SlackChannel    string
-	JWTSecret       string
-	Environment     string
+	DatabaseURL               string
+	EmailHost                 string
+	EmailPort                 int
+	EmailUsername             string
+	EmailPassword             string
+	EmailFromAddress          string
+	EmailFromName             string
+	SMTPPoolSize              int
+	SMTPMaxMessagesPerConn    int
+	SMTPIdleTimeout           time.Duration
+	DKIMDomain                string
+	DKIMSelector              string
+	DKIMPrivateKeyFile        string
+	BounceWebhookSecret       string
+	SoftBounceSuppression     time.Duration
+	PublicURL                 string
+	LinkSigningSecret         string
+	TrackOpens                bool
+	TrackClicks               bool
+	UntrackedCategories       []string
+	SlackToken                string
+	SlackChannel              string
+	SlackUserCacheTTL         time.Duration
+	SlackMaxRateLimitWait     time.Duration
+	SlackNativeScheduling     bool
+	SlackSigningSecret        string
+	SlackActionWebhookURL     string
+	ChannelMasterKey          string
+	ChannelMasterKeyFile      string
+	ChannelPreviousMasterKeys []string
+	JWTSecret                 string
+	JWTJWKSFile               string
+	JWTAudience               string
+	JWTIssuer                 string
+	JWTLeeway                 time.Duration
+	JWTTenantClaim            string
+	JWTRequireTenant          bool
+	JWTDefaultScopes          []string
+	TenantNotificationsPerDay int
+	TenantMaxTemplates        int
+	TenantMaxChannels         int
+	TenantQuotasFile          string
+	Environment               string
+	DefaultLocale             string
+	TemplateTimeout           time.Duration
+	TemplateMaxOutputBytes    int
+	TemplateMaxIterations     int
+	BlobStorePath             string
+	MaxAttachmentBytes        int
 }
 
 func Load() *Config {
 	return &Config{
-		DatabaseURL:   getEnv("DATABASE_URL", "notifications.db"),
-		EmailHost:     getEnv("EMAIL_HOST", "smtp.gmail.com"),
-		EmailPort:     getEnvAsInt("EMAIL_PORT", 587),
-		EmailUsername: getEnv("EMAIL_USERNAME", ""),
-		EmailPassword: getEnv("EMAIL_PASSWORD", ""),
-		SlackToken:    getEnv("SLACK_TOKEN", ""),
-		SlackChannel:  getEnv("SLACK_CHANNEL", "#general"),
-		JWTSecret:     getEnv("JWT_SECRET", "your-secret-key"),
-		Environment:   getEnv("ENVIRONMENT", "development"),
+		DatabaseURL:               getEnv("DATABASE_URL", "notifications.db"),
+		EmailHost:                 getEnv("EMAIL_HOST", "smtp.gmail.com"),
+		EmailPort:                 getEnvAsInt("EMAIL_PORT", 587),
+		EmailUsername:             getEnv("EMAIL_USERNAME", ""),
+		EmailPassword:             getEnv("EMAIL_PASSWORD", ""),
+		EmailFromAddress:          getEnv("EMAIL_FROM_ADDRESS", ""),
+		EmailFromName:             getEnv("EMAIL_FROM_NAME", ""),
+		SMTPPoolSize:              getEnvAsInt("SMTP_POOL_SIZE", 4),
+		SMTPMaxMessagesPerConn:    getEnvAsInt("SMTP_MAX_MESSAGES_PER_CONN", 100),
+		SMTPIdleTimeout:           getEnvAsDuration("SMTP_IDLE_TIMEOUT", 30*time.Second),
+		DKIMDomain:                getEnv("DKIM_DOMAIN", ""),
+		DKIMSelector:              getEnv("DKIM_SELECTOR", ""),
+		DKIMPrivateKeyFile:        getEnv("DKIM_PRIVATE_KEY_FILE", ""),
+		BounceWebhookSecret:       getEnv("BOUNCE_WEBHOOK_SECRET", ""),
+		SoftBounceSuppression:     getEnvAsDuration("SOFT_BOUNCE_SUPPRESSION", 24*time.Hour),
+		PublicURL:                 getEnv("PUBLIC_URL", "http://localhost:8080"),
+		LinkSigningSecret:         getEnv("LINK_SIGNING_SECRET", ""),
+		TrackOpens:                getEnvAsBool("TRACK_OPENS", false),
+		TrackClicks:               getEnvAsBool("TRACK_CLICKS", false),
+		UntrackedCategories:       getEnvAsList("UNTRACKED_CATEGORIES"),
+		SlackToken:                getEnv("SLACK_TOKEN", ""),
+		SlackChannel:              getEnv("SLACK_CHANNEL", "#general"),
+		SlackUserCacheTTL:         getEnvAsDuration("SLACK_USER_CACHE_TTL", time.Hour),
+		SlackMaxRateLimitWait:     getEnvAsDuration("SLACK_MAX_RATE_LIMIT_WAIT", 30*time.Second),
+		SlackNativeScheduling:     getEnvAsBool("SLACK_NATIVE_SCHEDULING", false),
+		SlackSigningSecret:        getEnv("SLACK_SIGNING_SECRET", ""),
+		SlackActionWebhookURL:     getEnv("SLACK_ACTION_WEBHOOK_URL", ""),
+		ChannelMasterKey:          getEnv("CHANNEL_MASTER_KEY", ""),
+		ChannelMasterKeyFile:      getEnv("CHANNEL_MASTER_KEY_FILE", ""),
+		ChannelPreviousMasterKeys: getEnvAsList("CHANNEL_PREVIOUS_MASTER_KEYS"),
+		JWTSecret:                 getEnv("JWT_SECRET", ""),
+		JWTJWKSFile:               getEnv("JWT_JWKS_FILE", ""),
+		JWTAudience:               getEnv("JWT_AUDIENCE", ""),
+		JWTIssuer:                 getEnv("JWT_ISSUER", ""),
+		JWTLeeway:                 getEnvAsDuration("JWT_LEEWAY", time.Minute),
+		JWTTenantClaim:            getEnv("JWT_TENANT_CLAIM", "tenant_id"),
+		JWTRequireTenant:          getEnvAsBool("JWT_REQUIRE_TENANT", false),
+		JWTDefaultScopes:          getEnvAsList("JWT_DEFAULT_SCOPES"),
+		TenantNotificationsPerDay: getEnvAsInt("TENANT_NOTIFICATIONS_PER_DAY", 0),
+		TenantMaxTemplates:        getEnvAsInt("TENANT_MAX_TEMPLATES", 0),
+		TenantMaxChannels:         getEnvAsInt("TENANT_MAX_CHANNELS", 0),
+		TenantQuotasFile:          getEnv("TENANT_QUOTAS_FILE", ""),
+		Environment:               getEnv("ENVIRONMENT", "development"),
+		DefaultLocale:             getEnv("DEFAULT_LOCALE", "en"),
+		TemplateTimeout:           getEnvAsDuration("TEMPLATE_TIMEOUT", 2*time.Second),
+		TemplateMaxOutputBytes:    getEnvAsInt("TEMPLATE_MAX_OUTPUT_BYTES", 256*1024),
+		TemplateMaxIterations:     getEnvAsInt("TEMPLATE_MAX_ITERATIONS", 10000),
+		BlobStorePath:             getEnv("BLOB_STORE_PATH", "data/blobs"),
+		MaxAttachmentBytes:        getEnvAsInt("MAX_ATTACHMENT_BYTES", 10*1024*1024),
 	}
 }
 
@@ -45,4 +127,32 @@ func getEnvAsInt(key string, defaultValue int) int {
 		}
 	}
 	return defaultValue
-} 
\ No newline at end of file
+}
+
+func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if duration, err := time.ParseDuration(value); err == nil {
+			return duration
+		}
+	}
+	return defaultValue
+}
+
+func getEnvAsBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
+func getEnvAsList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -21,11 +21,33 @@ func Init(databaseURL string) (*gorm.DB, error) {
 	if err := db.AutoMigrate(
 		&models.Notification{},
 		&models.Template{},
+		&models.TemplateVariant{},
 		&models.Channel{},
+		&models.Recipient{},
+		&models.Attachment{},
+		&models.Blob{},
+		&models.Delivery{},
+		&models.Suppression{},
+		&models.TrackedLink{},
+		&models.SlackAction{},
+		&models.APIKey{},
+		&models.Tenant{},
 	); err != nil {
 		return nil, err
 	}
 
+	// Template and channel names used to be unique across the service; they are now
+	// unique per tenant, through the composite indexes on the models
+	if err := dropGlobalNameConstraints(db); err != nil {
+		return nil, err
+	}
+
+	// Recipients, suppressions and blobs used to be shared by all tenants; they are now
+	// keyed by tenant as well
+	if err := scopeAddressesToTenants(db); err != nil {
+		return nil, err
+	}
+
 	// Seed default data
 	if err := seedDefaultData(db); err != nil {
 		return nil, err
@@ -34,6 +56,39 @@ func Init(databaseURL string) (*gorm.DB, error) {
 	return db, nil
 }
 
+// dropGlobalNameConstraints drops the service-wide unique constraints on template and
+// channel names that databases created before tenants still have
+func dropGlobalNameConstraints(db *gorm.DB) error {
+	for _, table := range []string{"templates", "channels"} {
+		if err := db.Exec("ALTER TABLE " + table + " DROP CONSTRAINT IF EXISTS " + table + "_name_key").Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scopeAddressesToTenants drops the service-wide unique constraints on recipient and
+// suppression addresses, and adds the tenant to the primary key of blobs, in databases
+// created before those records belonged to a tenant
+func scopeAddressesToTenants(db *gorm.DB) error {
+	if err := db.Exec("ALTER TABLE recipients DROP CONSTRAINT IF EXISTS recipients_address_key").Error; err != nil {
+		return err
+	}
+	if err := db.Exec("DROP INDEX IF EXISTS idx_suppressions_address").Error; err != nil {
+		return err
+	}
+
+	var keyed int64
+	if err := db.Raw(`SELECT COUNT(*) FROM information_schema.key_column_usage
+		WHERE table_name = 'blobs' AND constraint_name = 'blobs_pkey' AND column_name = 'tenant_id'`).Scan(&keyed).Error; err != nil {
+		return err
+	}
+	if keyed > 0 {
+		return nil
+	}
+	return db.Exec("ALTER TABLE blobs DROP CONSTRAINT IF EXISTS blobs_pkey, ADD PRIMARY KEY (id, tenant_id)").Error
+}
+
 // seedDefaultData seeds the database with default templates and channels
 func seedDefaultData(db *gorm.DB) error {
 	// Check if default templates already exist
@@ -1,8 +1,14 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"notification-service/internal/models"
 	"notification-service/internal/scheduler"
@@ -34,8 +40,27 @@ func (h *Handler) SendNotification(c *gin.Context) {
 		return
 	}
 
+	if err := validateNotificationRequest(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.CreatedBy = createdBy(c)
+	req.TenantID = tenantID(c)
+
 	notification, err := h.notificationService.SendNotification(&req)
 	if err != nil {
+		if errors.Is(err, services.ErrQuotaExceeded) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrTemplateLimitExceeded) || errors.Is(err, services.ErrTemplateDisallowed) || errors.Is(err, services.ErrChannelInactive) || errors.Is(err, services.ErrTenantChannelRequired) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrInvalidAttachment) || errors.Is(err, services.ErrInvalidRecipient) || errors.Is(err, services.ErrInvalidSlackMessage) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -51,8 +76,27 @@ func (h *Handler) ScheduleNotification(c *gin.Context) {
 		return
 	}
 
+	if err := validateNotificationRequest(&req.NotificationRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.CreatedBy = createdBy(c)
+	req.TenantID = tenantID(c)
+
 	notification, err := h.notificationService.ScheduleNotification(&req)
 	if err != nil {
+		if errors.Is(err, services.ErrQuotaExceeded) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrTemplateLimitExceeded) || errors.Is(err, services.ErrTemplateDisallowed) || errors.Is(err, services.ErrChannelInactive) || errors.Is(err, services.ErrTenantChannelRequired) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrInvalidAttachment) || errors.Is(err, services.ErrInvalidRecipient) || errors.Is(err, services.ErrInvalidSlackMessage) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -67,7 +111,7 @@ func (h *Handler) GetNotifications(c *gin.Context) {
 	status := models.NotificationStatus(c.Query("status"))
 	notificationType := models.NotificationType(c.Query("type"))
 
-	notifications, total, err := h.notificationService.GetNotifications(limit, offset, status, notificationType)
+	notifications, total, err := h.notificationService.GetNotifications(tenantID(c), limit, offset, status, notificationType)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -89,7 +133,7 @@ func (h *Handler) GetNotification(c *gin.Context) {
 		return
 	}
 
-	notification, err := h.notificationService.GetNotification(uint(id))
+	notification, err := h.notificationService.GetNotification(tenantID(c), uint(id))
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
@@ -110,18 +154,22 @@ func (h *Handler) UpdateNotification(c *gin.Context) {
 		return
 	}
 
-	var updates map[string]interface{}
-	if err := c.ShouldBindJSON(&updates); err != nil {
+	var req models.NotificationUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	notification, err := h.notificationService.UpdateNotification(uint(id), updates)
+	notification, err := h.notificationService.UpdateNotification(tenantID(c), uint(id), &req)
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
 			return
 		}
+		if errors.Is(err, services.ErrInvalidSlackMessage) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -137,7 +185,7 @@ func (h *Handler) DeleteNotification(c *gin.Context) {
 		return
 	}
 
-	if err := h.notificationService.DeleteNotification(uint(id)); err != nil {
+	if err := h.notificationService.DeleteNotification(tenantID(c), uint(id)); err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
 			return
@@ -157,16 +205,58 @@ func (h *Handler) CreateTemplate(c *gin.Context) {
 		return
 	}
 
+	if err := requireTemplateContent(req.Content, req.HTMLContent); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	variants, err := buildTemplateVariants(req.Variants)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	kind, err := templateKind(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	markup, err := templateMarkup(req.Markup)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	template := &models.Template{
-		Name:      req.Name,
-		Type:      req.Type,
-		Subject:   req.Subject,
-		Content:   req.Content,
-		Variables: req.Variables,
-		IsActive:  true,
+		TenantID:    tenantID(c),
+		Name:        req.Name,
+		Type:        req.Type,
+		Kind:        kind,
+		Layout:      req.Layout,
+		Category:    req.Category,
+		DisableTracking: req.DisableTracking,
+		Markup:      markup,
+		Subject:     req.Subject,
+		Content:     req.Content,
+		HTMLContent: req.HTMLContent,
+		Blocks:      req.Blocks,
+		Variables:   req.Variables,
+		Variants:    variants,
+		IsActive:    true,
+		CreatedBy:   createdBy(c),
 	}
 
-	if err := h.notificationService.GetDB().Create(template).Error; err != nil {
+	if _, err := h.notificationService.ResolveTemplateDependencies(template); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.notificationService.CreateTemplate(template); err != nil {
+		if errors.Is(err, services.ErrQuotaExceeded) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -174,10 +264,10 @@ func (h *Handler) CreateTemplate(c *gin.Context) {
 	c.JSON(http.StatusCreated, template)
 }
 
-// GetTemplates handles retrieving templates
+// GetTemplates handles retrieving the caller's templates
 func (h *Handler) GetTemplates(c *gin.Context) {
 	var templates []models.Template
-	if err := h.notificationService.GetDB().Find(&templates).Error; err != nil {
+	if err := h.notificationService.GetDB().Where("tenant_id = ?", tenantID(c)).Preload("Variants").Find(&templates).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -185,6 +275,11 @@ func (h *Handler) GetTemplates(c *gin.Context) {
 	c.JSON(http.StatusOK, templates)
 }
 
+// GetTemplateFunctions handles listing the helper functions available to templates
+func (h *Handler) GetTemplateFunctions(c *gin.Context) {
+	c.JSON(http.StatusOK, services.TemplateFuncs())
+}
+
 // GetTemplate handles retrieving a single template
 func (h *Handler) GetTemplate(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
@@ -194,7 +289,7 @@ func (h *Handler) GetTemplate(c *gin.Context) {
 	}
 
 	var template models.Template
-	if err := h.notificationService.GetDB().First(&template, id).Error; err != nil {
+	if err := h.notificationService.GetDB().Where("tenant_id = ?", tenantID(c)).Preload("Variants").First(&template, id).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
 			return
@@ -220,8 +315,31 @@ func (h *Handler) UpdateTemplate(c *gin.Context) {
 		return
 	}
 
+	if err := requireTemplateContent(req.Content, req.HTMLContent); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	variants, err := buildTemplateVariants(req.Variants)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	kind, err := templateKind(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	markup, err := templateMarkup(req.Markup)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	var template models.Template
-	if err := h.notificationService.GetDB().First(&template, id).Error; err != nil {
+	if err := h.notificationService.GetDB().Where("tenant_id = ?", tenantID(c)).First(&template, id).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
 			return
@@ -230,20 +348,83 @@ func (h *Handler) UpdateTemplate(c *gin.Context) {
 		return
 	}
 
+	// A layout or partial cannot be renamed, turned into another kind or deactivated
+	// while active templates still use it
+	if services.TemplateUpdateReleases(&template, req.Name, kind, req.IsActive) && h.templateInUse(c, &template) {
+		return
+	}
+
 	template.Name = req.Name
 	template.Type = req.Type
+	template.Kind = kind
+	template.Layout = req.Layout
+	template.Category = req.Category
+	template.DisableTracking = req.DisableTracking
+	template.Markup = markup
 	template.Subject = req.Subject
 	template.Content = req.Content
+	template.HTMLContent = req.HTMLContent
+	template.Blocks = req.Blocks
 	template.Variables = req.Variables
+	template.Variants = variants
+	if req.IsActive != nil {
+		template.IsActive = *req.IsActive
+	}
 
-	if err := h.notificationService.GetDB().Save(&template).Error; err != nil {
+	if _, err := h.notificationService.ResolveTemplateDependencies(&template); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	template.Variants = nil
+
+	// Variants are replaced wholesale with the ones in the request
+	err = h.notificationService.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&template).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("template_id = ?", template.ID).Delete(&models.TemplateVariant{}).Error; err != nil {
+			return err
+		}
+		for i := range variants {
+			variants[i].TemplateID = template.ID
+		}
+		if len(variants) > 0 {
+			if err := tx.Create(&variants).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	template.Variants = variants
 
 	c.JSON(http.StatusOK, template)
 }
 
+// templateInUse responds with 409 Conflict and reports true when a template is a
+// layout or partial that active templates still use
+func (h *Handler) templateInUse(c *gin.Context, template *models.Template) bool {
+	if template.Kind != models.LayoutTemplate && template.Kind != models.PartialTemplate {
+		return false
+	}
+	dependents, err := h.notificationService.TemplateDependents(template.TenantID, template.Name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return true
+	}
+	if len(dependents) > 0 {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":      fmt.Sprintf("%s %s is used by active templates", template.Kind, template.Name),
+			"dependents": dependents,
+		})
+		return true
+	}
+	return false
+}
+
 // DeleteTemplate handles deleting a template
 func (h *Handler) DeleteTemplate(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
@@ -252,7 +433,8 @@ func (h *Handler) DeleteTemplate(c *gin.Context) {
 		return
 	}
 
-	if err := h.notificationService.GetDB().Delete(&models.Template{}, id).Error; err != nil {
+	var template models.Template
+	if err := h.notificationService.GetDB().Where("tenant_id = ?", tenantID(c)).First(&template, id).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
 			return
@@ -261,13 +443,371 @@ func (h *Handler) DeleteTemplate(c *gin.Context) {
 		return
 	}
 
+	// Layouts and partials cannot be removed while active templates still use them
+	if h.templateInUse(c, &template) {
+		return
+	}
+
+	if err := h.notificationService.GetDB().Delete(&template).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Template deleted successfully"})
 }
 
+// GetDKIMRecord handles returning the DNS TXT record to publish for a DKIM key
+func (h *Handler) GetDKIMRecord(c *gin.Context) {
+	key, err := h.notificationService.DKIMKey(tenantID(c), c.Query("channel"))
+	if err != nil {
+		if errors.Is(err, services.ErrDKIMNotConfigured) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	name, value, err := key.DNSRecord()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	quoted := make([]string, 0, 2)
+	for _, part := range services.SplitTXTRecord(value) {
+		quoted = append(quoted, strconv.Quote(part))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"name":       name,
+		"type":       "TXT",
+		"value":      value,
+		"zone_entry": fmt.Sprintf("%s. IN TXT ( %s )", name, strings.Join(quoted, " ")),
+	})
+}
+
+// ReceiveBounceWebhook handles bounce and complaint notifications from email
+// providers and raw delivery status notifications, suppressing the reported addresses
+func (h *Handler) ReceiveBounceWebhook(c *gin.Context) {
+	if !h.notificationService.BounceWebhookAuthorized(c.GetHeader("X-Webhook-Token")) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid webhook token"})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBounceWebhookBytes))
+	if err != nil {
+		respondBodyError(c, err)
+		return
+	}
+
+	source, events, err := services.ParseBounceWebhook(c.ContentType(), body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if provider := c.Query("provider"); provider != "" {
+		source = provider
+	}
+
+	// BOUNCE_WEBHOOK_SECRET is the environment's, like the SMTP server only the default
+	// tenant sends through, so the reported addresses are suppressed for that tenant
+	suppressions, err := h.notificationService.Suppress(models.DefaultTenant, events, source)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"source":     source,
+		"events":     len(events),
+		"suppressed": len(suppressions),
+	})
+}
+
+// maxBounceWebhookBytes caps the body of a bounce webhook, enough for a batch of
+// provider events or a delivery status notification quoting the original headers
+const maxBounceWebhookBytes = 1 << 20
+
+// GetSuppressions handles listing suppressed addresses
+func (h *Handler) GetSuppressions(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+
+	suppressions, total, err := h.notificationService.GetSuppressions(tenantID(c), limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"suppressions": suppressions,
+		"total":        total,
+		"limit":        limit,
+		"offset":       offset,
+	})
+}
+
+// CreateSuppression handles suppressing an address manually
+func (h *Handler) CreateSuppression(c *gin.Context) {
+	var req models.SuppressionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	suppression, err := h.notificationService.AddSuppression(tenantID(c), &req)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidRecipient) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, suppression)
+}
+
+// DeleteSuppression handles removing an address from the suppression list
+func (h *Handler) DeleteSuppression(c *gin.Context) {
+	if err := h.notificationService.DeleteSuppression(tenantID(c), c.Param("address")); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Suppression not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Suppression deleted successfully"})
+}
+
+// UploadBlob handles uploading a file that later notifications can attach by blob ID
+func (h *Handler) UploadBlob(c *gin.Context) {
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A multipart file field named 'file' is required"})
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	// Read one byte past the limit so oversized uploads are rejected rather than truncated
+	data, err := io.ReadAll(io.LimitReader(file, int64(h.notificationService.MaxAttachmentBytes())+1))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	blob, err := h.notificationService.StoreBlob(tenantID(c), data, header.Filename, header.Header.Get("Content-Type"))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidAttachment) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, blob)
+}
+
+// GetBlob handles retrieving the metadata of an uploaded blob
+func (h *Handler) GetBlob(c *gin.Context) {
+	var blob models.Blob
+	if err := h.notificationService.GetDB().First(&blob, "id = ? AND tenant_id = ?", c.Param("id"), tenantID(c)).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Blob not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, blob)
+}
+
+// GetRecipient handles retrieving a recipient's preferences
+func (h *Handler) GetRecipient(c *gin.Context) {
+	var recipient models.Recipient
+	if err := h.notificationService.GetDB().Where("tenant_id = ? AND address = ?", tenantID(c), c.Param("address")).First(&recipient).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Recipient not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, recipient)
+}
+
+// UpdateRecipient handles creating or updating a recipient's preferences
+func (h *Handler) UpdateRecipient(c *gin.Context) {
+	var req models.RecipientRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Locale != "" {
+		locale, err := services.CanonicalLocale(req.Locale)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		req.Locale = locale
+	}
+
+	var recipient models.Recipient
+	db := h.notificationService.GetDB()
+	if err := db.Where(models.Recipient{TenantID: tenantID(c), Address: c.Param("address")}).FirstOrInit(&recipient).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	recipient.Locale = req.Locale
+	if len(req.Categories) > 0 && recipient.Categories == nil {
+		recipient.Categories = make(models.JSON, len(req.Categories))
+	}
+	for category, subscribed := range req.Categories {
+		recipient.Categories[category] = subscribed
+	}
+
+	if err := db.Save(&recipient).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, recipient)
+}
+
+// ShowUnsubscribe handles an unsubscribe link opened in a browser by asking the
+// recipient to confirm, so link scanners following it do not unsubscribe anyone
+func (h *Handler) ShowUnsubscribe(c *gin.Context) {
+	action := html.EscapeString(c.Request.URL.Path)
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Unsubscribe</title></head>
+<body><form method="post" action="`+action+`">
+<p>Stop receiving these emails?</p>
+<button type="submit">Unsubscribe</button>
+</form></body></html>`))
+}
+
+// Unsubscribe handles one-click unsubscribe requests (RFC 8058) and confirmations
+// from the unsubscribe page
+func (h *Handler) Unsubscribe(c *gin.Context) {
+	recipient, category, err := h.notificationService.Unsubscribe(c.Param("token"))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid unsubscribe link"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if strings.Contains(c.GetHeader("Accept"), "text/html") {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Unsubscribed</title></head>
+<body><p>You have been unsubscribed.</p></body></html>`))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"address":  recipient.Address,
+		"category": category,
+		"message":  "Unsubscribed successfully",
+	})
+}
+
+// trackingPixel is a transparent 1x1 GIF
+var trackingPixel = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+// TrackOpen handles the open tracking pixel of an email. The pixel is served even when
+// the token is invalid so mail clients never show a broken image.
+func (h *Handler) TrackOpen(c *gin.Context) {
+	if err := h.notificationService.RecordOpen(c.Param("token")); err != nil && !errors.Is(err, services.ErrInvalidToken) {
+		log.Printf("Failed to record email open: %v", err)
+	}
+
+	c.Header("Cache-Control", "no-store, no-cache, must-revalidate, private")
+	c.Data(http.StatusOK, "image/gif", trackingPixel)
+}
+
+// TrackClick handles a tracked link by recording the click and redirecting to the
+// link's original URL
+func (h *Handler) TrackClick(c *gin.Context) {
+	target, err := h.notificationService.RecordClick(c.Param("token"))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidToken) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Link not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Redirect(http.StatusFound, target)
+}
+
+// SlackInteractions handles clicks on buttons and other interactive elements of
+// Slack notifications, sent by Slack to the app's interactivity request URL
+func (h *Handler) SlackInteractions(c *gin.Context) {
+	// The route is public and the signature covers the whole body, so the body is
+	// capped before it is read
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxSlackInteractionBytes))
+	if err != nil {
+		respondBodyError(c, err)
+		return
+	}
+
+	if _, err := h.notificationService.HandleSlackInteraction(c.Request.Header, body); err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidSlackSignature):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrInvalidSlackPayload):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// maxSlackInteractionBytes caps the body of a Slack interaction request. Slack's
+// payloads are a few kilobytes.
+const maxSlackInteractionBytes = 256 << 10
+
+// respondBodyError writes the response for a request body that could not be read
+func respondBodyError(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Request body is larger than %d bytes", tooLarge.Limit)})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
 // GetChannels handles retrieving available channels
 func (h *Handler) GetChannels(c *gin.Context) {
-	var channels []models.Channel
-	if err := h.notificationService.GetDB().Find(&channels).Error; err != nil {
+	channels, err := h.notificationService.GetChannels(tenantID(c))
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -275,33 +815,306 @@ func (h *Handler) GetChannels(c *gin.Context) {
 	c.JSON(http.StatusOK, channels)
 }
 
-// TestChannel handles testing a notification channel
+// GetChannel handles retrieving a specific channel
+func (h *Handler) GetChannel(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return
+	}
+
+	channel, err := h.notificationService.GetChannel(tenantID(c), uint(id))
+	if err != nil {
+		respondChannelError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, channel)
+}
+
+// CreateChannel handles configuring a new channel
+func (h *Handler) CreateChannel(c *gin.Context) {
+	var req models.ChannelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	channel, err := h.notificationService.CreateChannel(tenantID(c), &req)
+	if err != nil {
+		respondChannelError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, channel)
+}
+
+// UpdateChannel handles replacing a channel's settings
+func (h *Handler) UpdateChannel(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return
+	}
+
+	var req models.ChannelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	channel, err := h.notificationService.UpdateChannel(tenantID(c), uint(id), &req)
+	if err != nil {
+		respondChannelError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, channel)
+}
+
+// DeleteChannel handles removing a channel
+func (h *Handler) DeleteChannel(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return
+	}
+
+	if err := h.notificationService.DeleteChannel(tenantID(c), uint(id)); err != nil {
+		respondChannelError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Channel deleted successfully"})
+}
+
+// RotateChannelSecrets handles re-encrypting the caller's channel secrets with the
+// current master key
+func (h *Handler) RotateChannelSecrets(c *gin.Context) {
+	rotated, err := h.notificationService.RotateChannelSecrets(tenantID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "rotated": rotated})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rotated": rotated})
+}
+
+// respondChannelError writes the response for a failed channel request
+func respondChannelError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
+	case errors.Is(err, services.ErrInvalidChannel):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrQuotaExceeded):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// GetSlackThrottle handles retrieving the rate limit state of the Slack Web API
+// methods the service calls, for SLACK_TOKEN and for each of the caller's active
+// Slack channels
+func (h *Handler) GetSlackThrottle(c *gin.Context) {
+	methods, channels, err := h.notificationService.SlackThrottles(tenantID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"methods": methods, "channels": channels})
+}
+
+// TestChannel handles testing a notification channel, with the settings of the named
+// channel or else the environment's
 func (h *Handler) TestChannel(c *gin.Context) {
 	var req struct {
 		Type string `json:"type" binding:"required"`
+		Name string `json:"name"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	var err error
 	switch models.NotificationType(req.Type) {
-	case models.EmailNotification:
-		err = h.notificationService.GetEmailSender().TestConnection()
-	case models.SlackNotification:
-		err = h.notificationService.GetSlackSender().TestConnection()
-	case models.InAppNotification:
-		err = h.notificationService.GetInAppSender().TestConnection()
+	case models.EmailNotification, models.SlackNotification, models.InAppNotification:
 	default:
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported notification type"})
 		return
 	}
 
-	if err != nil {
+	if err := h.notificationService.TestChannelConnection(tenantID(c), models.NotificationType(req.Type), req.Name); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
+			return
+		}
+		if errors.Is(err, services.ErrTenantChannelRequired) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Channel test successful"})
-} 
\ No newline at end of file
+}
+
+// GetAPIKeys handles listing the caller's API keys that have not been revoked
+func (h *Handler) GetAPIKeys(c *gin.Context) {
+	keys, err := h.notificationService.GetAPIKeys(tenantID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// CreateAPIKey handles creating an API key. The key itself is only returned here.
+func (h *Handler) CreateAPIKey(c *gin.Context) {
+	var req models.APIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Callers cannot grant scopes they do not have
+	if identity := currentIdentity(c); identity != nil {
+		for _, scope := range req.Scopes {
+			if !identity.Can(scope) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Cannot grant scope " + scope})
+				return
+			}
+		}
+	}
+
+	apiKey, key, err := h.notificationService.CreateAPIKey(tenantID(c), &req, createdBy(c))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidAPIKeyRequest) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"api_key": apiKey, "key": key})
+}
+
+// DeleteAPIKey handles revoking an API key
+func (h *Handler) DeleteAPIKey(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	if err := h.notificationService.DeleteAPIKey(tenantID(c), uint(id)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
+}
+
+// GetTenantUsage handles retrieving the caller's tenant quotas and its usage of them
+func (h *Handler) GetTenantUsage(c *gin.Context) {
+	usage, err := h.notificationService.GetTenantUsage(tenantID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, usage)
+}
+
+// validateNotificationRequest checks that a notification has a recipient and a body,
+// either directly or through a template, and that its locale is well formed
+func validateNotificationRequest(req *models.NotificationRequest) error {
+	if strings.TrimSpace(req.Recipient) == "" && len(req.To) == 0 {
+		return errors.New("recipient or to is required")
+	}
+	if req.TemplateID == nil && strings.TrimSpace(req.Message) == "" && strings.TrimSpace(req.HTMLMessage) == "" {
+		return errors.New("message or html_message is required unless template_id is set")
+	}
+	if req.Locale != "" {
+		if _, err := services.CanonicalLocale(req.Locale); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requireTemplateContent checks that a template or variant has a text or HTML body
+func requireTemplateContent(content, htmlContent string) error {
+	if strings.TrimSpace(content) == "" && strings.TrimSpace(htmlContent) == "" {
+		return errors.New("content or html_content is required")
+	}
+	return nil
+}
+
+// templateKind validates the kind and layout of a template request, defaulting to a message template
+func templateKind(req *models.TemplateRequest) (models.TemplateKind, error) {
+	switch req.Kind {
+	case "", models.MessageTemplate:
+		return models.MessageTemplate, nil
+	case models.LayoutTemplate, models.PartialTemplate:
+		if req.Layout != "" {
+			return "", fmt.Errorf("a %s cannot use a layout", req.Kind)
+		}
+		if req.Name == "content" {
+			return "", fmt.Errorf("%q is reserved for the content a layout wraps", req.Name)
+		}
+		return req.Kind, nil
+	default:
+		return "", fmt.Errorf("unsupported template kind: %s", req.Kind)
+	}
+}
+
+// templateMarkup validates the markup of a template request, defaulting to plain text
+func templateMarkup(markup models.TemplateMarkup) (models.TemplateMarkup, error) {
+	switch markup {
+	case "", models.PlainMarkup:
+		return models.PlainMarkup, nil
+	case models.MarkdownMarkup:
+		return markup, nil
+	default:
+		return "", fmt.Errorf("unsupported template markup: %s", markup)
+	}
+}
+
+// buildTemplateVariants converts variant requests into models, canonicalizing their locales
+func buildTemplateVariants(reqs []models.TemplateVariantRequest) ([]models.TemplateVariant, error) {
+	variants := make([]models.TemplateVariant, 0, len(reqs))
+	seen := make(map[string]bool)
+
+	for _, req := range reqs {
+		locale, err := services.CanonicalLocale(req.Locale)
+		if err != nil {
+			return nil, err
+		}
+		if seen[locale] {
+			return nil, fmt.Errorf("duplicate variant for locale %s", locale)
+		}
+		if err := requireTemplateContent(req.Content, req.HTMLContent); err != nil {
+			return nil, fmt.Errorf("variant %s: %w", locale, err)
+		}
+		seen[locale] = true
+
+		variants = append(variants, models.TemplateVariant{
+			Locale:      locale,
+			Subject:     req.Subject,
+			Content:     req.Content,
+			HTMLContent: req.HTMLContent,
+		})
+	}
+
+	return variants, nil
+}
@@ -26,8 +26,16 @@ const (
 	SentStatus      NotificationStatus = "sent"
 	FailedStatus    NotificationStatus = "failed"
 	ScheduledStatus NotificationStatus = "scheduled"
+	// PartiallySentStatus marks an email that some of its recipients' servers rejected
+	PartiallySentStatus NotificationStatus = "partially_sent"
+	// SuppressedStatus marks an email whose recipients are all on the suppression list
+	SuppressedStatus NotificationStatus = "suppressed"
 )
 
+// DefaultTenant owns the records created before tenants were introduced, and those of
+// callers whose credentials name no tenant
+const DefaultTenant = "default"
+
 // Notification represents a notification record
 type Notification struct {
 	ID          uint               `json:"id" gorm:"primaryKey"`
@@ -35,36 +43,232 @@ type Notification struct {
 	Status      NotificationStatus `json:"status" gorm:"not null;default:'pending'"`
 	Title       string             `json:"title" gorm:"not null"`
 	Message     string             `json:"message" gorm:"not null"`
+	HTMLMessage string             `json:"html_message"`
 	Recipient   string             `json:"recipient" gorm:"not null"`
+	From        string             `json:"from,omitempty"`
+	ReplyTo     string             `json:"reply_to,omitempty"`
 	Channel     string             `json:"channel"`
+	Category    string             `json:"category,omitempty"`
+	ThreadKey   string             `json:"thread_key,omitempty" gorm:"index"`
+	// SlackChannelID and SlackTS identify the posted Slack message, and SlackThreadTS
+	// the thread it was posted in
+	SlackChannelID string          `json:"slack_channel_id,omitempty"`
+	SlackTS        string          `json:"slack_ts,omitempty"`
+	SlackThreadTS  string          `json:"slack_thread_ts,omitempty"`
+	// SlackScheduledMessageID identifies a message handed to Slack to post at ScheduledAt
+	SlackScheduledMessageID string `json:"slack_scheduled_message_id,omitempty"`
+	// Ephemeral Slack notifications are shown only to their recipient, in the channel
+	Ephemeral   bool               `json:"ephemeral,omitempty"`
 	TemplateID  *uint              `json:"template_id"`
 	Template    *Template          `json:"template,omitempty"`
+	Locale      string             `json:"locale"`
 	ScheduledAt *time.Time         `json:"scheduled_at"`
 	SentAt      *time.Time         `json:"sent_at"`
 	Metadata    JSON               `json:"metadata" gorm:"type:json"`
+	Attachments []Attachment       `json:"attachments,omitempty"`
+	Deliveries  []Delivery         `json:"deliveries,omitempty"`
+	Links       []TrackedLink      `json:"links,omitempty"`
+	Actions     []SlackAction      `json:"actions,omitempty"`
+	OpenedAt    *time.Time         `json:"opened_at"`
+	Opens       int                `json:"opens"`
+	ClickedAt   *time.Time         `json:"clicked_at"`
+	// TrackOpens and TrackClicks are decided each time an email is sent
+	TrackOpens  bool               `json:"-" gorm:"-"`
+	TrackClicks bool               `json:"-" gorm:"-"`
+	// TenantID is the tenant of the caller that sent or scheduled the notification
+	TenantID    string             `json:"tenant_id" gorm:"not null;default:'default';index"`
+	// CreatedBy is the authenticated subject that sent or scheduled the notification
+	CreatedBy   string             `json:"created_by,omitempty" gorm:"index"`
 	CreatedAt   time.Time          `json:"created_at"`
 	UpdatedAt   time.Time          `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt     `json:"deleted_at,omitempty" gorm:"index"`
 }
 
+// DeliveryKind is the header an email address appears in
+type DeliveryKind string
+
+const (
+	ToDelivery  DeliveryKind = "to"
+	CCDelivery  DeliveryKind = "cc"
+	BCCDelivery DeliveryKind = "bcc"
+)
+
+// DeliveryStatus represents the outcome of sending to a single address
+type DeliveryStatus string
+
+const (
+	DeliveryPending DeliveryStatus = "pending"
+	DeliverySent    DeliveryStatus = "sent"
+	DeliveryFailed  DeliveryStatus = "failed"
+	// DeliverySuppressed marks an address skipped because it is on the suppression list
+	DeliverySuppressed DeliveryStatus = "suppressed"
+)
+
+// Delivery records the outcome of sending an email notification to one address
+type Delivery struct {
+	ID             uint           `json:"id" gorm:"primaryKey"`
+	NotificationID uint           `json:"notification_id" gorm:"index;not null"`
+	Address        string         `json:"address" gorm:"not null"`
+	Kind           DeliveryKind   `json:"kind" gorm:"not null;default:'to'"`
+	Status         DeliveryStatus `json:"status" gorm:"not null;default:'pending'"`
+	Error          string         `json:"error,omitempty"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+}
+
+// Attachment is a file sent with an email notification. Inline attachments are
+// images the HTML body references as cid:<content_id>.
+type Attachment struct {
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	NotificationID uint      `json:"notification_id" gorm:"index;not null"`
+	BlobID         string    `json:"blob_id" gorm:"size:64;not null"`
+	Filename       string    `json:"filename" gorm:"not null"`
+	ContentType    string    `json:"content_type" gorm:"not null"`
+	Size           int64     `json:"size"`
+	Inline         bool      `json:"inline"`
+	ContentID      string    `json:"content_id,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// TrackedLink is a link in an email's HTML body whose clicks are tracked. The click
+// redirect only ever sends readers to the URLs recorded here.
+type TrackedLink struct {
+	ID             uint       `json:"id" gorm:"primaryKey"`
+	NotificationID uint       `json:"notification_id" gorm:"not null;index"`
+	URL            string     `json:"url" gorm:"not null"`
+	Clicks         int        `json:"clicks"`
+	ClickedAt      *time.Time `json:"clicked_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// SlackAction is a click on a button or other interactive element of a posted Slack
+// notification
+type SlackAction struct {
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	NotificationID uint      `json:"notification_id" gorm:"not null;index;uniqueIndex:idx_slack_action_replay,where:trigger_id <> ''"`
+	ActionID       string    `json:"action_id" gorm:"not null;uniqueIndex:idx_slack_action_replay"`
+	// TriggerID and ActionTS identify the interaction, so a replayed request is only
+	// recorded once
+	TriggerID      string    `json:"-" gorm:"uniqueIndex:idx_slack_action_replay"`
+	ActionTS       string    `json:"action_ts" gorm:"uniqueIndex:idx_slack_action_replay"`
+	BlockID        string    `json:"block_id"`
+	Value          string    `json:"value"`
+	UserID         string    `json:"user_id"`
+	UserName       string    `json:"user_name"`
+	TeamID         string    `json:"team_id"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// SuppressionReason explains why an address is suppressed
+type SuppressionReason string
+
+const (
+	HardBounceSuppression SuppressionReason = "hard_bounce"
+	SoftBounceSuppression SuppressionReason = "soft_bounce"
+	ComplaintSuppression  SuppressionReason = "complaint"
+	ManualSuppression     SuppressionReason = "manual"
+)
+
+// Suppression is an address email is no longer sent to, until ExpiresAt if set
+type Suppression struct {
+	ID        uint              `json:"id" gorm:"primaryKey"`
+	// TenantID owns the suppression; an address is suppressed for one tenant only
+	TenantID  string            `json:"tenant_id" gorm:"not null;default:'default';uniqueIndex:idx_suppression_tenant_address"`
+	Address   string            `json:"address" gorm:"not null;uniqueIndex:idx_suppression_tenant_address"`
+	Reason    SuppressionReason `json:"reason" gorm:"not null"`
+	Source    string            `json:"source" gorm:"not null"`
+	Detail    string            `json:"detail,omitempty"`
+	ExpiresAt *time.Time        `json:"expires_at"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// Blob is uploaded content kept in the blob store, identified by its SHA-256 digest
+type Blob struct {
+	ID          string    `json:"id" gorm:"primaryKey;size:64"`
+	// TenantID owns the blob; tenants uploading the same content each get their own record
+	TenantID    string    `json:"tenant_id" gorm:"primaryKey;default:'default'"`
+	ContentType string    `json:"content_type" gorm:"not null"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// TemplateKind distinguishes sendable templates from the layouts and partials they share
+type TemplateKind string
+
+const (
+	MessageTemplate TemplateKind = "message"
+	LayoutTemplate  TemplateKind = "layout"
+	PartialTemplate TemplateKind = "partial"
+)
+
+// TemplateMarkup identifies the markup a template's content is written in
+type TemplateMarkup string
+
+const (
+	PlainMarkup    TemplateMarkup = "text"
+	MarkdownMarkup TemplateMarkup = "markdown"
+)
+
 // Template represents a notification template
 type Template struct {
 	ID          uint           `json:"id" gorm:"primaryKey"`
-	Name        string         `json:"name" gorm:"not null;unique"`
+	// TenantID owns the template; names are unique within a tenant
+	TenantID    string         `json:"tenant_id" gorm:"not null;default:'default';uniqueIndex:idx_template_tenant_name"`
+	Name        string         `json:"name" gorm:"not null;uniqueIndex:idx_template_tenant_name"`
 	Type        NotificationType `json:"type" gorm:"not null"`
+	Kind        TemplateKind   `json:"kind" gorm:"not null;default:'message'"`
+	Layout      string         `json:"layout"`
+	Category    string         `json:"category"`
+	DisableTracking bool       `json:"disable_tracking"`
+	Markup      TemplateMarkup `json:"markup" gorm:"not null;default:'text'"`
 	Subject     string         `json:"subject"`
 	Content     string         `json:"content" gorm:"not null"`
+	HTMLContent string         `json:"html_content"`
+	Blocks      string         `json:"blocks"`
 	Variables   JSON           `json:"variables" gorm:"type:json"`
+	Variants    []TemplateVariant `json:"variants,omitempty"`
 	IsActive    bool           `json:"is_active" gorm:"default:true"`
+	// CreatedBy is the authenticated subject that created the template
+	CreatedBy   string         `json:"created_by,omitempty"`
 	CreatedAt   time.Time      `json:"created_at"`
 	UpdatedAt   time.Time      `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `json:"deleted_at,omitempty" gorm:"index"`
 }
 
+// TemplateVariant represents a localized version of a template for one BCP 47 locale
+type TemplateVariant struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	TemplateID  uint      `json:"template_id" gorm:"not null;uniqueIndex:idx_template_variant_locale"`
+	Locale      string    `json:"locale" gorm:"not null;uniqueIndex:idx_template_variant_locale"`
+	Subject     string    `json:"subject"`
+	Content     string    `json:"content" gorm:"not null"`
+	HTMLContent string    `json:"html_content"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Recipient represents per-recipient delivery preferences. Categories maps a
+// notification category to whether the recipient is subscribed to it; categories
+// that are absent are subscribed.
+type Recipient struct {
+	ID         uint           `json:"id" gorm:"primaryKey"`
+	// TenantID owns the recipient's preferences; addresses are unique within a tenant
+	TenantID   string         `json:"tenant_id" gorm:"not null;default:'default';uniqueIndex:idx_recipient_tenant_address"`
+	Address    string         `json:"address" gorm:"not null;uniqueIndex:idx_recipient_tenant_address"`
+	Locale     string         `json:"locale"`
+	Categories JSON           `json:"categories" gorm:"type:json"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"deleted_at,omitempty" gorm:"index"`
+}
+
 // Channel represents a notification channel configuration
 type Channel struct {
 	ID          uint           `json:"id" gorm:"primaryKey"`
-	Name        string         `json:"name" gorm:"not null;unique"`
+	// TenantID owns the channel; names are unique within a tenant
+	TenantID    string         `json:"tenant_id" gorm:"not null;default:'default';uniqueIndex:idx_channel_tenant_name"`
+	Name        string         `json:"name" gorm:"not null;uniqueIndex:idx_channel_tenant_name"`
 	Type        NotificationType `json:"type" gorm:"not null"`
 	Config      JSON           `json:"config" gorm:"type:json"`
 	IsActive    bool           `json:"is_active" gorm:"default:true"`
@@ -99,16 +303,99 @@ func (j *JSON) Scan(value interface{}) error {
 	return json.Unmarshal(bytes, j)
 }
 
+// StringList is a list of strings stored as a JSON array
+type StringList []string
+
+// Value implements the driver.Valuer interface
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return nil, nil
+	}
+	return json.Marshal(l)
+}
+
+// Scan implements the sql.Scanner interface
+func (l *StringList) Scan(value interface{}) error {
+	if value == nil {
+		*l = nil
+		return nil
+	}
+
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New("type assertion to []byte failed")
+	}
+
+	return json.Unmarshal(bytes, l)
+}
+
+// Tenant is a row per tenant that requests lock while they check and use the tenant's
+// quotas, so concurrent requests cannot both take the last of a quota. It is created
+// on the tenant's first quota check.
+type Tenant struct {
+	ID        string    `json:"id" gorm:"primaryKey;size:64"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// APIKey is a credential for service-to-service callers. Only a hash of the key is
+// stored; its prefix identifies it in listings and logs.
+type APIKey struct {
+	ID         uint           `json:"id" gorm:"primaryKey"`
+	Name       string         `json:"name" gorm:"not null"`
+	Prefix     string         `json:"prefix" gorm:"not null;uniqueIndex"`
+	// TenantID is the tenant the key acts for, that of the caller who created it
+	TenantID   string         `json:"tenant_id" gorm:"not null;default:'default';index"`
+	Hash       string         `json:"-" gorm:"not null"`
+	Scopes     StringList     `json:"scopes" gorm:"type:json"`
+	ExpiresAt  *time.Time     `json:"expires_at"`
+	LastUsedAt *time.Time     `json:"last_used_at"`
+	CreatedBy  string         `json:"created_by,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+	DeletedAt  gorm.DeletedAt `json:"deleted_at,omitempty" gorm:"index"`
+}
+
+// APIKeyRequest represents the request structure for creating API keys
+type APIKeyRequest struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
 // NotificationRequest represents the request structure for sending notifications
 type NotificationRequest struct {
 	Type        NotificationType `json:"type" binding:"required"`
 	Title       string           `json:"title" binding:"required"`
-	Message     string           `json:"message" binding:"required"`
-	Recipient   string           `json:"recipient" binding:"required"`
+	Message     string           `json:"message"`
+	HTMLMessage string           `json:"html_message"`
+	Recipient   string           `json:"recipient"`
+	To          []string         `json:"to"`
+	CC          []string         `json:"cc"`
+	BCC         []string         `json:"bcc"`
+	ReplyTo     string           `json:"reply_to"`
 	Channel     string           `json:"channel"`
+	Category    string           `json:"category"`
+	ThreadKey   string           `json:"thread_key"`
+	Ephemeral   bool             `json:"ephemeral"`
 	TemplateID  *uint            `json:"template_id"`
 	TemplateData JSON            `json:"template_data"`
+	Locale      string           `json:"locale"`
 	Metadata    JSON             `json:"metadata"`
+	Attachments []AttachmentRequest `json:"attachments"`
+	// CreatedBy and TenantID are set from the authenticated caller, never from the request body
+	CreatedBy   string           `json:"-"`
+	TenantID    string           `json:"-"`
+}
+
+// AttachmentRequest represents an email attachment, given either as base64 content
+// or as the ID of a previously uploaded blob
+type AttachmentRequest struct {
+	Filename    string `json:"filename"`
+	Content     string `json:"content"`
+	BlobID      string `json:"blob_id"`
+	ContentType string `json:"content_type"`
+	Inline      bool   `json:"inline"`
+	ContentID   string `json:"content_id"`
 }
 
 // ScheduleRequest represents the request structure for scheduling notifications
@@ -117,13 +404,54 @@ type ScheduleRequest struct {
 	ScheduledAt time.Time `json:"scheduled_at" binding:"required"`
 }
 
+// NotificationUpdateRequest represents the request structure for updating a
+// notification. Only these fields can be changed; those left out keep their value.
+type NotificationUpdateRequest struct {
+	Title       *string    `json:"title"`
+	Message     *string    `json:"message"`
+	Metadata    JSON       `json:"metadata"`
+	ScheduledAt *time.Time `json:"scheduled_at"`
+}
+
 // TemplateRequest represents the request structure for templates
 type TemplateRequest struct {
-	Name      string           `json:"name" binding:"required"`
-	Type      NotificationType `json:"type" binding:"required"`
-	Subject   string           `json:"subject"`
-	Content   string           `json:"content" binding:"required"`
-	Variables JSON             `json:"variables"`
+	Name        string           `json:"name" binding:"required"`
+	Type        NotificationType `json:"type" binding:"required"`
+	Kind        TemplateKind     `json:"kind"`
+	Layout      string           `json:"layout"`
+	Category    string           `json:"category"`
+	DisableTracking bool         `json:"disable_tracking"`
+	Markup      TemplateMarkup   `json:"markup"`
+	Subject     string           `json:"subject"`
+	Content     string           `json:"content"`
+	HTMLContent string           `json:"html_content"`
+	Blocks      string           `json:"blocks"`
+	Variables   JSON             `json:"variables"`
+	Variants    []TemplateVariantRequest `json:"variants"`
+	// IsActive is left unchanged when omitted
+	IsActive *bool `json:"is_active"`
+}
+
+// TemplateVariantRequest represents the request structure for a localized template variant
+type TemplateVariantRequest struct {
+	Locale      string `json:"locale" binding:"required"`
+	Subject     string `json:"subject"`
+	Content     string `json:"content"`
+	HTMLContent string `json:"html_content"`
+}
+
+// SuppressionRequest represents the request structure for suppressing an address manually
+type SuppressionRequest struct {
+	Address   string            `json:"address" binding:"required"`
+	Reason    SuppressionReason `json:"reason"`
+	Detail    string            `json:"detail"`
+	ExpiresAt *time.Time        `json:"expires_at"`
+}
+
+// RecipientRequest represents the request structure for recipient preferences
+type RecipientRequest struct {
+	Locale     string          `json:"locale"`
+	Categories map[string]bool `json:"categories"`
 }
 
 // ChannelRequest represents the request structure for channels
@@ -131,4 +459,6 @@ type ChannelRequest struct {
 	Name   string           `json:"name" binding:"required"`
 	Type   NotificationType `json:"type" binding:"required"`
 	Config JSON             `json:"config" binding:"required"`
+	// IsActive defaults to true when a channel is created and is left unchanged when omitted
+	IsActive *bool `json:"is_active"`
 } 
\ No newline at end of file
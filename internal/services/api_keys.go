@@ -0,0 +1,198 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"notification-service/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Scopes granted to API keys and JWTs
+const (
+	ScopeNotificationsSend = "notifications:send"
+	ScopeNotificationsRead = "notifications:read"
+	ScopeTemplatesRead     = "templates:read"
+	ScopeTemplatesWrite    = "templates:write"
+	ScopeRecipientsAdmin   = "recipients:admin"
+	ScopeChannelsAdmin     = "channels:admin"
+	ScopeAPIKeysAdmin      = "api-keys:admin"
+)
+
+// Scopes lists every scope, in the order they are documented
+var Scopes = []string{
+	ScopeNotificationsSend,
+	ScopeNotificationsRead,
+	ScopeTemplatesRead,
+	ScopeTemplatesWrite,
+	ScopeRecipientsAdmin,
+	ScopeChannelsAdmin,
+	ScopeAPIKeysAdmin,
+}
+
+var (
+	// ErrInvalidAPIKey is returned when an API key is unknown, revoked or expired
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	// ErrInvalidAPIKeyRequest is returned when an API key is requested with unknown
+	// scopes or an expiry in the past
+	ErrInvalidAPIKeyRequest = errors.New("invalid API key request")
+)
+
+// apiKeyPrefix starts every API key, so keys are recognizable in headers and secret scanners
+const apiKeyPrefix = "nsk_"
+
+// apiKeyLastUsedInterval is how often an API key's last use is written, so a busy key
+// does not cost a database write per request
+const apiKeyLastUsedInterval = time.Minute
+
+// generateAPIKey returns a new API key, nsk_<id>_<secret>, and the prefix it is
+// looked up by
+func generateAPIKey() (key, prefix string, err error) {
+	id := make([]byte, 4)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	prefix = apiKeyPrefix + hex.EncodeToString(id)
+	return prefix + "_" + base64.RawURLEncoding.EncodeToString(secret), prefix, nil
+}
+
+// IsAPIKey reports whether a credential looks like an API key rather than a JWT
+func IsAPIKey(credential string) bool {
+	return strings.HasPrefix(credential, apiKeyPrefix)
+}
+
+// apiKeyPrefixOf returns the prefix of an API key, or false when it is malformed
+func apiKeyPrefixOf(key string) (string, bool) {
+	n := len(apiKeyPrefix) + 8
+	if !IsAPIKey(key) || len(key) < n+2 || key[n] != '_' {
+		return "", false
+	}
+	return key[:n], true
+}
+
+// hashAPIKey returns the hash an API key is stored as. Keys carry 256 bits of
+// randomness, so a fast hash is enough.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// validateScopes checks that every scope is known
+func validateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("%w: at least one scope is required", ErrInvalidAPIKeyRequest)
+	}
+	for _, scope := range scopes {
+		known := false
+		for _, s := range Scopes {
+			known = known || s == scope
+		}
+		if !known {
+			return fmt.Errorf("%w: unknown scope %q", ErrInvalidAPIKeyRequest, scope)
+		}
+	}
+	return nil
+}
+
+// CreateAPIKey creates an API key acting for a tenant and returns it with the key
+// itself, which is only available now
+func (s *NotificationService) CreateAPIKey(tenantID string, req *models.APIKeyRequest, createdBy string) (*models.APIKey, string, error) {
+	if err := validateScopes(req.Scopes); err != nil {
+		return nil, "", err
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, "", fmt.Errorf("%w: expires_at must be in the future", ErrInvalidAPIKeyRequest)
+	}
+
+	key, prefix, err := generateAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+	apiKey := &models.APIKey{
+		Name:      strings.TrimSpace(req.Name),
+		Prefix:    prefix,
+		TenantID:  tenantID,
+		Hash:      hashAPIKey(key),
+		Scopes:    models.StringList(req.Scopes),
+		ExpiresAt: req.ExpiresAt,
+		CreatedBy: createdBy,
+	}
+	if err := s.db.Create(apiKey).Error; err != nil {
+		return nil, "", err
+	}
+	return apiKey, key, nil
+}
+
+// GetAPIKeys lists a tenant's API keys that have not been revoked
+func (s *NotificationService) GetAPIKeys(tenantID string) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	if err := s.db.Where("tenant_id = ?", tenantID).Order("created_at DESC").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// DeleteAPIKey revokes one of a tenant's API keys
+func (s *NotificationService) DeleteAPIKey(tenantID string, id uint) error {
+	result := s.db.Where("tenant_id = ?", tenantID).Delete(&models.APIKey{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// AuthenticateAPIKey returns the identity of the caller holding an API key and
+// records when the key was used
+func (s *NotificationService) AuthenticateAPIKey(key string) (*Identity, error) {
+	prefix, ok := apiKeyPrefixOf(key)
+	if !ok {
+		return nil, fmt.Errorf("%w: malformed key", ErrInvalidAPIKey)
+	}
+
+	var apiKey models.APIKey
+	if err := s.db.Where("prefix = ?", prefix).First(&apiKey).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if err := checkAPIKey(&apiKey, key, now); err != nil {
+		return nil, err
+	}
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= apiKeyLastUsedInterval {
+		if err := s.db.Model(&apiKey).UpdateColumn("last_used_at", now).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	// A key is limited to its scopes even if none were stored
+	return &Identity{Subject: "api-key:" + apiKey.Prefix, TenantID: apiKey.TenantID, Scopes: apiKey.Scopes}, nil
+}
+
+// checkAPIKey checks a presented key against the stored one and its expiry
+func checkAPIKey(apiKey *models.APIKey, key string, now time.Time) error {
+	if subtle.ConstantTimeCompare([]byte(hashAPIKey(key)), []byte(apiKey.Hash)) != 1 {
+		return ErrInvalidAPIKey
+	}
+	if apiKey.ExpiresAt != nil && !now.Before(*apiKey.ExpiresAt) {
+		return fmt.Errorf("%w: key expired", ErrInvalidAPIKey)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"notification-service/internal/models"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, prefix, err := generateAPIKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	if !IsAPIKey(key) || !strings.HasPrefix(key, prefix+"_") || len(prefix) != len("nsk_")+8 {
+		t.Errorf("Unexpected key %s with prefix %s", key, prefix)
+	}
+	if parsed, ok := apiKeyPrefixOf(key); !ok || parsed != prefix {
+		t.Errorf("Expected prefix %s, got %s", prefix, parsed)
+	}
+	if other, _, _ := generateAPIKey(); other == key {
+		t.Error("Expected keys to be random")
+	}
+
+	for _, malformed := range []string{"nsk_", "nsk_abcd", "nsk_abcd1234", "nsk_abcd1234_", "nsk_abcd1234x-secret", "eyJhbGciOi.x.y"} {
+		if _, ok := apiKeyPrefixOf(malformed); ok {
+			t.Errorf("Expected %q to be malformed", malformed)
+		}
+	}
+}
+
+func TestCheckAPIKey(t *testing.T) {
+	key, prefix, _ := generateAPIKey()
+	now := time.Now()
+	expiresAt := now.Add(time.Hour)
+	apiKey := &models.APIKey{Prefix: prefix, Hash: hashAPIKey(key), ExpiresAt: &expiresAt}
+
+	if apiKey.Hash == key || strings.Contains(apiKey.Hash, key) {
+		t.Fatal("Expected the key to be stored hashed")
+	}
+	if err := checkAPIKey(apiKey, key, now); err != nil {
+		t.Errorf("Expected the key to be valid, got %v", err)
+	}
+	if err := checkAPIKey(apiKey, prefix+"_wrong", now); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Expected a wrong secret to be rejected, got %v", err)
+	}
+	if err := checkAPIKey(apiKey, key, expiresAt); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("Expected an expired key to be rejected, got %v", err)
+	}
+}
+
+func TestValidateScopes(t *testing.T) {
+	if err := validateScopes([]string{ScopeNotificationsSend, ScopeTemplatesWrite}); err != nil {
+		t.Errorf("Expected known scopes to be valid, got %v", err)
+	}
+	for _, scopes := range [][]string{nil, {"notifications:delete"}, {ScopeNotificationsRead, "*"}} {
+		if err := validateScopes(scopes); !errors.Is(err, ErrInvalidAPIKeyRequest) {
+			t.Errorf("Expected %v to be invalid, got %v", scopes, err)
+		}
+	}
+}
+
+func TestIdentityCan(t *testing.T) {
+	if (&Identity{Subject: "ada"}).Can(ScopeNotificationsSend) {
+		t.Error("Expected an identity without scopes to be granted nothing")
+	}
+	limited := &Identity{Subject: "api-key:nsk_abcd1234", Scopes: []string{ScopeNotificationsSend}}
+	if !limited.Can(ScopeNotificationsSend) || limited.Can(ScopeNotificationsRead) {
+		t.Errorf("Expected %v to only grant notifications:send", limited.Scopes)
+	}
+	if (&Identity{Scopes: []string{}}).Can(ScopeNotificationsSend) {
+		t.Error("Expected an empty scope list to grant nothing")
+	}
+}
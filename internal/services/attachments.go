@@ -0,0 +1,184 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"notification-service/internal/models"
+
+	"golang.org/x/net/html"
+)
+
+// ErrInvalidAttachment is returned when an attachment cannot be accepted
+var ErrInvalidAttachment = errors.New("invalid attachment")
+
+// MaxAttachmentBytes returns the size limit for a single attachment
+func (s *NotificationService) MaxAttachmentBytes() int {
+	return s.config.MaxAttachmentBytes
+}
+
+// StoreBlob saves uploaded content in the blob store and records its metadata for a tenant
+func (s *NotificationService) StoreBlob(tenantID string, data []byte, filename, declaredType string) (*models.Blob, error) {
+	if len(data) > s.config.MaxAttachmentBytes {
+		return nil, fmt.Errorf("%w: larger than %d bytes", ErrInvalidAttachment, s.config.MaxAttachmentBytes)
+	}
+
+	id, err := s.blobs.Put(data)
+	if err != nil {
+		return nil, err
+	}
+
+	blob := models.Blob{
+		ID:          id,
+		TenantID:    tenantID,
+		ContentType: sniffContentType(data, filename, declaredType),
+		Size:        int64(len(data)),
+	}
+	if err := s.db.Where(models.Blob{ID: id, TenantID: tenantID}).FirstOrCreate(&blob).Error; err != nil {
+		return nil, err
+	}
+	return &blob, nil
+}
+
+// buildAttachments stores the attachments of a notification request and checks
+// that every cid: image in the HTML body has a matching inline attachment
+func (s *NotificationService) buildAttachments(notification *models.Notification, reqs []models.AttachmentRequest) ([]models.Attachment, error) {
+	if len(reqs) > 0 && notification.Type != models.EmailNotification {
+		return nil, fmt.Errorf("%w: attachments are only supported for email", ErrInvalidAttachment)
+	}
+
+	attachments := make([]models.Attachment, 0, len(reqs))
+	contentIDs := make(map[string]bool)
+	for i, req := range reqs {
+		attachment, err := s.resolveAttachment(notification.TenantID, req)
+		if err != nil {
+			return nil, fmt.Errorf("attachment %d: %w", i+1, err)
+		}
+		if attachment.Inline {
+			if contentIDs[attachment.ContentID] {
+				return nil, fmt.Errorf("%w: duplicate content_id %q", ErrInvalidAttachment, attachment.ContentID)
+			}
+			contentIDs[attachment.ContentID] = true
+		}
+		attachments = append(attachments, *attachment)
+	}
+
+	for _, id := range htmlContentIDs(notification.HTMLMessage) {
+		if !contentIDs[id] {
+			return nil, fmt.Errorf("%w: HTML references cid:%s but no inline attachment has that content_id", ErrInvalidAttachment, id)
+		}
+	}
+
+	return attachments, nil
+}
+
+// resolveAttachment decodes the content of one attachment, or looks up a blob the
+// tenant uploaded
+func (s *NotificationService) resolveAttachment(tenantID string, req models.AttachmentRequest) (*models.Attachment, error) {
+	attachment := &models.Attachment{
+		Filename:  filepath.Base(strings.TrimSpace(req.Filename)),
+		Inline:    req.Inline,
+		ContentID: strings.Trim(strings.TrimSpace(req.ContentID), "<>"),
+	}
+	if req.Filename == "" || attachment.Filename == "." || attachment.Filename == "/" {
+		return nil, fmt.Errorf("%w: filename is required", ErrInvalidAttachment)
+	}
+	if attachment.Inline && attachment.ContentID == "" {
+		attachment.ContentID = attachment.Filename
+	}
+
+	switch {
+	case req.Content != "" && req.BlobID != "":
+		return nil, fmt.Errorf("%w: give either content or blob_id, not both", ErrInvalidAttachment)
+	case req.Content != "":
+		if base64.StdEncoding.DecodedLen(len(req.Content)) > s.config.MaxAttachmentBytes+2 {
+			return nil, fmt.Errorf("%w: %s is larger than %d bytes", ErrInvalidAttachment, attachment.Filename, s.config.MaxAttachmentBytes)
+		}
+		data, err := base64.StdEncoding.DecodeString(req.Content)
+		if err != nil {
+			return nil, fmt.Errorf("%w: content of %s is not valid base64", ErrInvalidAttachment, attachment.Filename)
+		}
+		if len(data) > s.config.MaxAttachmentBytes {
+			return nil, fmt.Errorf("%w: %s is larger than %d bytes", ErrInvalidAttachment, attachment.Filename, s.config.MaxAttachmentBytes)
+		}
+		id, err := s.blobs.Put(data)
+		if err != nil {
+			return nil, err
+		}
+		attachment.BlobID = id
+		attachment.Size = int64(len(data))
+		attachment.ContentType = sniffContentType(data, attachment.Filename, req.ContentType)
+	case req.BlobID != "":
+		var blob models.Blob
+		if err := s.db.First(&blob, "id = ? AND tenant_id = ?", req.BlobID, tenantID).Error; err != nil || !s.blobs.Exists(blob.ID) {
+			return nil, fmt.Errorf("%w: blob %s not found", ErrInvalidAttachment, req.BlobID)
+		}
+		attachment.BlobID = blob.ID
+		attachment.Size = blob.Size
+		attachment.ContentType = blob.ContentType
+	default:
+		return nil, fmt.Errorf("%w: content or blob_id is required", ErrInvalidAttachment)
+	}
+
+	if attachment.Inline && !strings.HasPrefix(attachment.ContentType, "image/") {
+		return nil, fmt.Errorf("%w: inline attachment %s is %s, not an image", ErrInvalidAttachment, attachment.Filename, attachment.ContentType)
+	}
+
+	return attachment, nil
+}
+
+// sniffContentType detects the type of attachment content. The declared type, or
+// one guessed from the file extension, is only trusted when sniffing finds nothing
+// more specific than plain text or binary data.
+func sniffContentType(data []byte, filename, declared string) string {
+	detected := http.DetectContentType(data)
+	mediaType, _, _ := mime.ParseMediaType(detected)
+	if mediaType != "application/octet-stream" && mediaType != "text/plain" {
+		return detected
+	}
+
+	if declared != "" {
+		if _, _, err := mime.ParseMediaType(declared); err == nil {
+			return declared
+		}
+	}
+	if byExtension := mime.TypeByExtension(filepath.Ext(filename)); byExtension != "" {
+		return byExtension
+	}
+	return detected
+}
+
+// htmlContentIDs returns the content IDs an HTML body references through cid: URLs
+func htmlContentIDs(body string) []string {
+	if !strings.Contains(body, "cid:") {
+		return nil
+	}
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return nil
+	}
+
+	var ids []string
+	seen := make(map[string]bool)
+	walkHTML(doc, func(n *html.Node) {
+		if n.Type != html.ElementNode {
+			return
+		}
+		for _, attr := range n.Attr {
+			if !urlAttributes[attr.Key] || !strings.HasPrefix(strings.ToLower(attr.Val), "cid:") {
+				continue
+			}
+			id := attr.Val[len("cid:"):]
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	})
+	return ids
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+)
+
+// pngHeader is enough of a PNG file for content sniffing
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestSniffContentType(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		filename string
+		declared string
+		expected string
+	}{
+		{"Sniffed type wins over declared", pngHeader, "logo.txt", "text/plain", "image/png"},
+		{"Declared type for plain text", []byte("a,b\n1,2\n"), "data", "text/csv", "text/csv"},
+		{"Extension for binary data", []byte{0x00, 0x01, 0x02}, "report.pdf", "", "application/pdf"},
+		{"Fallback", []byte{0x00, 0x01, 0x02}, "blob", "", "application/octet-stream"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := sniffContentType(tt.data, tt.filename, tt.declared); result != tt.expected {
+				t.Errorf("Expected '%s', got '%s'", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestBuildAttachments(t *testing.T) {
+	service := &NotificationService{
+		config: &config.Config{MaxAttachmentBytes: 64},
+		blobs:  NewBlobStore(t.TempDir()),
+	}
+	logo := base64.StdEncoding.EncodeToString(pngHeader)
+	email := &models.Notification{Type: models.EmailNotification, HTMLMessage: `<img src="cid:logo">`}
+
+	attachments, err := service.buildAttachments(email, []models.AttachmentRequest{
+		{Filename: "logo.png", Content: logo, Inline: true, ContentID: "<logo>"},
+		{Filename: "../notes.txt", Content: base64.StdEncoding.EncodeToString([]byte("notes"))},
+	})
+	if err != nil {
+		t.Fatalf("Failed to build attachments: %v", err)
+	}
+	if attachments[0].ContentID != "logo" || attachments[0].ContentType != "image/png" {
+		t.Errorf("Unexpected inline attachment: %+v", attachments[0])
+	}
+	if attachments[1].Filename != "notes.txt" || !strings.HasPrefix(attachments[1].ContentType, "text/plain") {
+		t.Errorf("Unexpected attachment: %+v", attachments[1])
+	}
+
+	invalid := []struct {
+		name         string
+		notification *models.Notification
+		reqs         []models.AttachmentRequest
+	}{
+		{"Missing inline image", email, nil},
+		{"Too large", &models.Notification{Type: models.EmailNotification}, []models.AttachmentRequest{
+			{Filename: "big.bin", Content: base64.StdEncoding.EncodeToString(make([]byte, 65))},
+		}},
+		{"Invalid base64", &models.Notification{Type: models.EmailNotification}, []models.AttachmentRequest{
+			{Filename: "bad.bin", Content: "not base64!"},
+		}},
+		{"Inline non-image", &models.Notification{Type: models.EmailNotification}, []models.AttachmentRequest{
+			{Filename: "notes.txt", Content: base64.StdEncoding.EncodeToString([]byte("notes")), Inline: true},
+		}},
+		{"Not email", &models.Notification{Type: models.SlackNotification}, []models.AttachmentRequest{
+			{Filename: "logo.png", Content: logo},
+		}},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := service.buildAttachments(tt.notification, tt.reqs); !errors.Is(err, ErrInvalidAttachment) {
+				t.Errorf("Expected ErrInvalidAttachment, got %v", err)
+			}
+		})
+	}
+}
+
+func TestEmailSenderBuildMessageWithAttachments(t *testing.T) {
+	blobs := NewBlobStore(t.TempDir())
+	logoID, _ := blobs.Put(pngHeader)
+	reportID, _ := blobs.Put([]byte("quarterly numbers"))
+	sender := NewEmailSender(&config.Config{EmailUsername: "noreply@example.com"}, blobs, nil)
+
+	notification := &models.Notification{
+		Title:       "Report",
+		Recipient:   "ada@example.com",
+		HTMLMessage: `<img src="cid:logo"><p>Attached.</p>`,
+		Attachments: []models.Attachment{
+			{BlobID: logoID, Filename: "logo.png", ContentType: "image/png", Inline: true, ContentID: "logo"},
+			{BlobID: reportID, Filename: "report.txt", ContentType: "text/plain; charset=utf-8"},
+		},
+	}
+
+	m, err := sender.buildMessage(notification)
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write message: %v", err)
+	}
+	raw := buf.String()
+
+	for _, want := range []string{
+		"multipart/mixed", "multipart/related", "multipart/alternative",
+		"Content-ID: <logo>", `"cid:logo"`,
+		`Content-Disposition: attachment; filename="report.txt"`,
+		base64.StdEncoding.EncodeToString([]byte("quarterly numbers")),
+	} {
+		if !strings.Contains(raw, want) {
+			t.Errorf("Expected %q in message:\n%s", want, raw)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// ErrBlobNotFound is returned when a blob does not exist in the store
+var ErrBlobNotFound = errors.New("blob not found")
+
+// blobID matches the hex SHA-256 digests blobs are stored under
+var blobID = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// BlobStore keeps attachment content on the local filesystem, addressed by the
+// SHA-256 digest of its content so identical files are stored once
+type BlobStore struct {
+	root string
+}
+
+// NewBlobStore creates a blob store rooted at the given directory
+func NewBlobStore(root string) *BlobStore {
+	return &BlobStore{root: root}
+}
+
+// Put stores data and returns its blob ID
+func (b *BlobStore) Put(data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	id := hex.EncodeToString(sum[:])
+
+	path, _ := b.path(id)
+	if _, err := os.Stat(path); err == nil {
+		return id, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return "", fmt.Errorf("failed to create blob directory: %w", err)
+	}
+
+	// Write to a temporary file first so a partially written blob is never visible
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to store blob: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to store blob: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to store blob: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to store blob: %w", err)
+	}
+
+	return id, nil
+}
+
+// Open opens a stored blob for reading
+func (b *BlobStore) Open(id string) (io.ReadCloser, error) {
+	path, err := b.path(id)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrBlobNotFound, id)
+	}
+	return f, err
+}
+
+// Exists reports whether a blob is in the store
+func (b *BlobStore) Exists(id string) bool {
+	path, err := b.path(id)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+// path returns the file a blob is stored in, sharded by the first two digest characters
+func (b *BlobStore) path(id string) (string, error) {
+	if !blobID.MatchString(id) {
+		return "", fmt.Errorf("%w: invalid blob ID %q", ErrBlobNotFound, id)
+	}
+	return filepath.Join(b.root, id[:2], id), nil
+}
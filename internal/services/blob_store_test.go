@@ -0,0 +1,38 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestBlobStore(t *testing.T) {
+	store := NewBlobStore(t.TempDir())
+
+	id, err := store.Put([]byte("hello"))
+	if err != nil {
+		t.Fatalf("Failed to put blob: %v", err)
+	}
+	if again, err := store.Put([]byte("hello")); err != nil || again != id {
+		t.Errorf("Expected identical content to share ID %s, got %s (%v)", id, again, err)
+	}
+	if !store.Exists(id) {
+		t.Errorf("Expected blob %s to exist", id)
+	}
+
+	r, err := store.Open(id)
+	if err != nil {
+		t.Fatalf("Failed to open blob: %v", err)
+	}
+	defer r.Close()
+	data, _ := io.ReadAll(r)
+	if string(data) != "hello" {
+		t.Errorf("Expected 'hello', got '%s'", data)
+	}
+
+	for _, missing := range []string{"../../etc/passwd", "0000000000000000000000000000000000000000000000000000000000000000"} {
+		if _, err := store.Open(missing); !errors.Is(err, ErrBlobNotFound) {
+			t.Errorf("Expected ErrBlobNotFound for %q, got %v", missing, err)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"notification-service/internal/models"
+)
+
+// ErrUnrecognizedBounce is returned when a bounce webhook payload is in no known format
+var ErrUnrecognizedBounce = errors.New("unrecognized bounce payload")
+
+// BounceEvent is a bounce or complaint reported for one address
+type BounceEvent struct {
+	Address string
+	Reason  models.SuppressionReason
+	Detail  string
+}
+
+// ParseBounceWebhook extracts bounce and complaint events from a webhook payload. It
+// accepts Amazon SES notifications delivered through SNS, SendGrid, Mailgun and
+// Postmark events, and raw RFC 3464 delivery status notifications or RFC 5965
+// complaint reports. It returns the detected source with the events.
+func ParseBounceWebhook(contentType string, body []byte) (string, []BounceEvent, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	trimmed := bytes.TrimSpace(body)
+
+	if mediaType == "application/json" || mediaType == "text/plain" && len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		return parseBounceJSON(trimmed)
+	}
+
+	events, err := ParseDSN(body)
+	return "dsn", events, err
+}
+
+// parseBounceJSON detects the provider of a JSON webhook from its shape
+func parseBounceJSON(body []byte) (string, []BounceEvent, error) {
+	if len(body) > 0 && body[0] == '[' {
+		events, err := parseSendGridEvents(body)
+		return "sendgrid", events, err
+	}
+
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrUnrecognizedBounce, err)
+	}
+
+	switch {
+	case probe["Type"] != nil && probe["Message"] != nil:
+		events, err := parseSNSNotification(body)
+		return "ses", events, err
+	case probe["notificationType"] != nil || probe["eventType"] != nil:
+		events, err := parseSESMessage(body)
+		return "ses", events, err
+	case probe["event-data"] != nil:
+		events, err := parseMailgunEvent(body)
+		return "mailgun", events, err
+	case probe["RecordType"] != nil:
+		events, err := parsePostmarkEvent(body)
+		return "postmark", events, err
+	default:
+		return "", nil, ErrUnrecognizedBounce
+	}
+}
+
+// parseSNSNotification unwraps an SES notification delivered through Amazon SNS.
+// Subscription confirmations carry no events.
+func parseSNSNotification(body []byte) ([]BounceEvent, error) {
+	var envelope struct {
+		Type    string
+		Message string
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, err
+	}
+	if envelope.Type != "Notification" {
+		return nil, nil
+	}
+	return parseSESMessage([]byte(envelope.Message))
+}
+
+// parseSESMessage parses an Amazon SES bounce or complaint notification
+func parseSESMessage(body []byte) ([]BounceEvent, error) {
+	var msg struct {
+		NotificationType string `json:"notificationType"`
+		EventType        string `json:"eventType"`
+		Bounce           struct {
+			BounceType        string `json:"bounceType"`
+			BouncedRecipients []struct {
+				EmailAddress   string `json:"emailAddress"`
+				DiagnosticCode string `json:"diagnosticCode"`
+			} `json:"bouncedRecipients"`
+		} `json:"bounce"`
+		Complaint struct {
+			ComplainedRecipients []struct {
+				EmailAddress string `json:"emailAddress"`
+			} `json:"complainedRecipients"`
+			ComplaintFeedbackType string `json:"complaintFeedbackType"`
+		} `json:"complaint"`
+	}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnrecognizedBounce, err)
+	}
+
+	kind := msg.NotificationType
+	if kind == "" {
+		kind = msg.EventType
+	}
+
+	var events []BounceEvent
+	switch kind {
+	case "Bounce":
+		reason := models.SoftBounceSuppression
+		if msg.Bounce.BounceType == "Permanent" {
+			reason = models.HardBounceSuppression
+		}
+		for _, r := range msg.Bounce.BouncedRecipients {
+			events = append(events, BounceEvent{Address: r.EmailAddress, Reason: reason, Detail: r.DiagnosticCode})
+		}
+	case "Complaint":
+		for _, r := range msg.Complaint.ComplainedRecipients {
+			events = append(events, BounceEvent{Address: r.EmailAddress, Reason: models.ComplaintSuppression, Detail: msg.Complaint.ComplaintFeedbackType})
+		}
+	}
+	return events, nil
+}
+
+// parseSendGridEvents parses a batch of SendGrid event webhook events
+func parseSendGridEvents(body []byte) ([]BounceEvent, error) {
+	var batch []struct {
+		Email  string `json:"email"`
+		Event  string `json:"event"`
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnrecognizedBounce, err)
+	}
+
+	var events []BounceEvent
+	for _, e := range batch {
+		switch {
+		case e.Event == "bounce" && e.Type != "blocked":
+			events = append(events, BounceEvent{Address: e.Email, Reason: models.HardBounceSuppression, Detail: e.Reason})
+		case e.Event == "bounce":
+			events = append(events, BounceEvent{Address: e.Email, Reason: models.SoftBounceSuppression, Detail: e.Reason})
+		case e.Event == "spamreport":
+			events = append(events, BounceEvent{Address: e.Email, Reason: models.ComplaintSuppression})
+		}
+	}
+	return events, nil
+}
+
+// parseMailgunEvent parses a Mailgun webhook event
+func parseMailgunEvent(body []byte) ([]BounceEvent, error) {
+	var payload struct {
+		EventData struct {
+			Event          string `json:"event"`
+			Severity       string `json:"severity"`
+			Recipient      string `json:"recipient"`
+			DeliveryStatus struct {
+				Message     string `json:"message"`
+				Description string `json:"description"`
+			} `json:"delivery-status"`
+		} `json:"event-data"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnrecognizedBounce, err)
+	}
+
+	e := payload.EventData
+	detail := e.DeliveryStatus.Message
+	if detail == "" {
+		detail = e.DeliveryStatus.Description
+	}
+	switch e.Event {
+	case "failed":
+		reason := models.SoftBounceSuppression
+		if e.Severity == "permanent" {
+			reason = models.HardBounceSuppression
+		}
+		return []BounceEvent{{Address: e.Recipient, Reason: reason, Detail: detail}}, nil
+	case "complained":
+		return []BounceEvent{{Address: e.Recipient, Reason: models.ComplaintSuppression}}, nil
+	}
+	return nil, nil
+}
+
+// parsePostmarkEvent parses a Postmark bounce or spam complaint webhook
+func parsePostmarkEvent(body []byte) ([]BounceEvent, error) {
+	var payload struct {
+		RecordType  string
+		Type        string
+		Email       string
+		Description string
+		Details     string
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnrecognizedBounce, err)
+	}
+
+	detail := payload.Details
+	if detail == "" {
+		detail = payload.Description
+	}
+	switch {
+	case payload.RecordType == "SpamComplaint" || payload.Type == "SpamComplaint":
+		return []BounceEvent{{Address: payload.Email, Reason: models.ComplaintSuppression, Detail: detail}}, nil
+	case payload.RecordType == "Bounce" && (payload.Type == "HardBounce" || payload.Type == "BadEmailAddress"):
+		return []BounceEvent{{Address: payload.Email, Reason: models.HardBounceSuppression, Detail: detail}}, nil
+	case payload.RecordType == "Bounce" && payload.Type == "SoftBounce":
+		return []BounceEvent{{Address: payload.Email, Reason: models.SoftBounceSuppression, Detail: detail}}, nil
+	}
+	return nil, nil
+}
+
+// ParseDSN parses a raw multipart/report message: an RFC 3464 delivery status
+// notification, reporting failed recipients as bounces, or an RFC 5965 abuse
+// report, reporting the original recipient as a complaint
+func ParseDSN(raw []byte) ([]BounceEvent, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnrecognizedBounce, err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" || params["boundary"] == "" {
+		return nil, fmt.Errorf("%w: not a multipart/report message", ErrUnrecognizedBounce)
+	}
+
+	var events []BounceEvent
+	var complaint bool
+	var complainedAddress, originalTo string
+
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnrecognizedBounce, err)
+		}
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+
+		switch partType {
+		case "message/delivery-status", "message/global-delivery-status":
+			blocks, err := readHeaderBlocks(part)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrUnrecognizedBounce, err)
+			}
+			// The first block holds per-message fields; the rest describe recipients
+			for _, block := range blocks[1:] {
+				if event, ok := dsnRecipientEvent(block); ok {
+					events = append(events, event)
+				}
+			}
+		case "message/feedback-report":
+			blocks, err := readHeaderBlocks(part)
+			if err != nil || len(blocks) == 0 {
+				return nil, fmt.Errorf("%w: invalid feedback report", ErrUnrecognizedBounce)
+			}
+			complaint = strings.EqualFold(blocks[0].Get("Feedback-Type"), "abuse")
+			complainedAddress = addressFromTypedField(blocks[0].Get("Original-Rcpt-To"))
+		case "message/rfc822", "text/rfc822-headers":
+			if original, err := mail.ReadMessage(part); err == nil {
+				originalTo = original.Header.Get("To")
+			}
+		}
+	}
+
+	if complaint {
+		if complainedAddress == "" {
+			if addr, err := mail.ParseAddress(originalTo); err == nil {
+				complainedAddress = addr.Address
+			}
+		}
+		if complainedAddress != "" {
+			events = append(events, BounceEvent{Address: complainedAddress, Reason: models.ComplaintSuppression, Detail: "abuse"})
+		}
+	}
+
+	return events, nil
+}
+
+// readHeaderBlocks reads the blank-line separated header blocks of a report part
+func readHeaderBlocks(r io.Reader) ([]textproto.MIMEHeader, error) {
+	reader := textproto.NewReader(bufio.NewReader(r))
+	var blocks []textproto.MIMEHeader
+	for {
+		block, err := reader.ReadMIMEHeader()
+		if len(block) > 0 {
+			blocks = append(blocks, block)
+		}
+		if err == io.EOF {
+			return blocks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// dsnRecipientEvent turns a failed per-recipient DSN block into a bounce. Status
+// codes of class 5 are permanent failures and class 4 transient ones.
+func dsnRecipientEvent(block textproto.MIMEHeader) (BounceEvent, bool) {
+	if !strings.EqualFold(strings.TrimSpace(block.Get("Action")), "failed") {
+		return BounceEvent{}, false
+	}
+	address := addressFromTypedField(block.Get("Final-Recipient"))
+	if address == "" {
+		address = addressFromTypedField(block.Get("Original-Recipient"))
+	}
+	if address == "" {
+		return BounceEvent{}, false
+	}
+
+	status := strings.TrimSpace(block.Get("Status"))
+	reason := models.HardBounceSuppression
+	if strings.HasPrefix(status, "4") {
+		reason = models.SoftBounceSuppression
+	}
+
+	detail := strings.TrimSpace(block.Get("Diagnostic-Code"))
+	if detail == "" {
+		detail = status
+	}
+	return BounceEvent{Address: address, Reason: reason, Detail: detail}, true
+}
+
+// addressFromTypedField extracts the address from a field such as "rfc822; user@example.com"
+func addressFromTypedField(value string) string {
+	if i := strings.IndexByte(value, ';'); i >= 0 {
+		value = value[i+1:]
+	}
+	return strings.Trim(strings.TrimSpace(value), "<>")
+}
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+)
+
+func TestParseBounceWebhookProviders(t *testing.T) {
+	sesMessage, _ := json.Marshal(map[string]interface{}{
+		"notificationType": "Bounce",
+		"bounce": map[string]interface{}{
+			"bounceType": "Permanent",
+			"bouncedRecipients": []map[string]string{
+				{"emailAddress": "ada@example.com", "diagnosticCode": "smtp; 550 5.1.1 user unknown"},
+			},
+		},
+	})
+	sns, _ := json.Marshal(map[string]string{"Type": "Notification", "Message": string(sesMessage)})
+
+	tests := []struct {
+		name   string
+		body   string
+		source string
+		want   []BounceEvent
+	}{
+		{
+			name:   "ses",
+			body:   string(sns),
+			source: "ses",
+			want:   []BounceEvent{{Address: "ada@example.com", Reason: models.HardBounceSuppression}},
+		},
+		{
+			name:   "ses subscription confirmation",
+			body:   `{"Type":"SubscriptionConfirmation","Message":"confirm","SubscribeURL":"https://sns.example.com"}`,
+			source: "ses",
+		},
+		{
+			name:   "sendgrid",
+			body:   `[{"email":"ada@example.com","event":"bounce","type":"bounce"},{"email":"grace@example.com","event":"spamreport"},{"email":"linus@example.com","event":"delivered"}]`,
+			source: "sendgrid",
+			want: []BounceEvent{
+				{Address: "ada@example.com", Reason: models.HardBounceSuppression},
+				{Address: "grace@example.com", Reason: models.ComplaintSuppression},
+			},
+		},
+		{
+			name:   "mailgun",
+			body:   `{"event-data":{"event":"failed","severity":"temporary","recipient":"ada@example.com"}}`,
+			source: "mailgun",
+			want:   []BounceEvent{{Address: "ada@example.com", Reason: models.SoftBounceSuppression}},
+		},
+		{
+			name:   "postmark",
+			body:   `{"RecordType":"SpamComplaint","Type":"SpamComplaint","Email":"ada@example.com"}`,
+			source: "postmark",
+			want:   []BounceEvent{{Address: "ada@example.com", Reason: models.ComplaintSuppression}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, events, err := ParseBounceWebhook("application/json", []byte(tt.body))
+			if err != nil {
+				t.Fatalf("Failed to parse: %v", err)
+			}
+			if source != tt.source {
+				t.Errorf("Expected source %s, got %s", tt.source, source)
+			}
+			assertBounceEvents(t, events, tt.want)
+		})
+	}
+
+	if _, _, err := ParseBounceWebhook("application/json", []byte(`{"hello":"world"}`)); err == nil {
+		t.Error("Expected an error for an unknown payload")
+	}
+}
+
+func TestParseDSN(t *testing.T) {
+	dsn := strings.ReplaceAll(`From: MAILER-DAEMON@example.com
+To: noreply@example.com
+Subject: Delivery Status Notification (Failure)
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=delivery-status; boundary="b1"
+
+--b1
+Content-Type: text/plain
+
+Your message could not be delivered.
+
+--b1
+Content-Type: message/delivery-status
+
+Reporting-MTA: dns; mx.example.com
+
+Final-Recipient: rfc822; ada@example.com
+Action: failed
+Status: 5.1.1
+Diagnostic-Code: smtp; 550 5.1.1 user unknown
+
+Final-Recipient: rfc822; grace@example.com
+Action: failed
+Status: 4.2.2
+Diagnostic-Code: smtp; 452 4.2.2 mailbox full
+
+Final-Recipient: rfc822; linus@example.com
+Action: delayed
+Status: 4.4.1
+
+--b1
+Content-Type: text/rfc822-headers
+
+To: ada@example.com
+Subject: Hello
+
+--b1--
+`, "\n", "\r\n")
+
+	source, events, err := ParseBounceWebhook("message/rfc822", []byte(dsn))
+	if err != nil {
+		t.Fatalf("Failed to parse DSN: %v", err)
+	}
+	if source != "dsn" {
+		t.Errorf("Expected source dsn, got %s", source)
+	}
+	assertBounceEvents(t, events, []BounceEvent{
+		{Address: "ada@example.com", Reason: models.HardBounceSuppression},
+		{Address: "grace@example.com", Reason: models.SoftBounceSuppression},
+	})
+	if events[0].Detail != "smtp; 550 5.1.1 user unknown" {
+		t.Errorf("Unexpected detail %q", events[0].Detail)
+	}
+
+	arf := strings.ReplaceAll(`From: abuse@isp.example
+Content-Type: multipart/report; report-type=feedback-report; boundary="b2"
+
+--b2
+Content-Type: message/feedback-report
+
+Feedback-Type: abuse
+Version: 1
+Original-Rcpt-To: <ada@example.com>
+
+--b2--
+`, "\n", "\r\n")
+
+	_, events, err = ParseBounceWebhook("", []byte(arf))
+	if err != nil {
+		t.Fatalf("Failed to parse feedback report: %v", err)
+	}
+	assertBounceEvents(t, events, []BounceEvent{{Address: "ada@example.com", Reason: models.ComplaintSuppression}})
+
+	if _, err := ParseDSN([]byte("Subject: hi\r\n\r\nnot a report")); err == nil {
+		t.Error("Expected an error for a message that is not a report")
+	}
+}
+
+func TestEmailSenderSkipsSuppressedDeliveries(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	sender := NewEmailSender(server.config(), nil, nil)
+
+	notification := &models.Notification{
+		TenantID:  models.DefaultTenant,
+		Type:      models.EmailNotification,
+		Title:     "Hello",
+		Message:   "Hi",
+		Recipient: "ada@example.com",
+		Deliveries: []models.Delivery{
+			{Address: "ada@example.com", Kind: models.ToDelivery},
+			{Address: "bounced@example.com", Kind: models.ToDelivery, Status: models.DeliverySuppressed},
+		},
+	}
+
+	if err := sender.Send(notification); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	if notification.Deliveries[1].Status != models.DeliverySuppressed {
+		t.Errorf("Expected the suppressed delivery to be left alone, got %s", notification.Deliveries[1].Status)
+	}
+	if len(server.messages) != 1 || len(server.messages[0].recipients) != 1 || server.messages[0].recipients[0] != "ada@example.com" {
+		t.Errorf("Expected only ada@example.com in the envelope, got %+v", server.messages)
+	}
+}
+
+// assertBounceEvents compares the address and reason of parsed events
+func assertBounceEvents(t *testing.T, got, want []BounceEvent) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d events, got %+v", len(want), got)
+	}
+	for i := range want {
+		if got[i].Address != want[i].Address || got[i].Reason != want[i].Reason {
+			t.Errorf("Event %d: expected %s %s, got %s %s", i, want[i].Reason, want[i].Address, got[i].Reason, got[i].Address)
+		}
+	}
+}
+
+func TestBounceWebhookAuthorized(t *testing.T) {
+	if (&NotificationService{config: &config.Config{}}).BounceWebhookAuthorized("") {
+		t.Error("Expected webhooks to be rejected without BOUNCE_WEBHOOK_SECRET")
+	}
+	service := &NotificationService{config: &config.Config{BounceWebhookSecret: "hook-secret"}}
+	if !service.BounceWebhookAuthorized("hook-secret") {
+		t.Error("Expected the configured secret to be accepted")
+	}
+	for _, token := range []string{"", "other"} {
+		if service.BounceWebhookAuthorized(token) {
+			t.Errorf("Expected token %q to be rejected", token)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+)
+
+// ErrChannelKeyNotConfigured is returned when a channel secret must be encrypted but
+// no master key is configured
+var ErrChannelKeyNotConfigured = errors.New("CHANNEL_MASTER_KEY is not configured")
+
+// channelSecretSettings are the channel settings stored encrypted and never returned by the API
+var channelSecretSettings = map[string]bool{"smtp_password": true, "token": true, "signing_secret": true, "dkim_private_key": true}
+
+// redactedSecret replaces secret settings in API responses. Sent back in an update, it
+// keeps the stored secret.
+const redactedSecret = "********"
+
+// sealedSecretPrefix marks an encrypted setting, stored as
+// enc:v2:<key id>:<wrapped data key>:<ciphertext>. Settings marked with
+// legacySealedSecretPrefix were encrypted before secrets were bound to their channel,
+// and are re-encrypted by RotateChannelSecrets.
+const (
+	sealedSecretPrefix       = "enc:v2:"
+	legacySealedSecretPrefix = "enc:v1:"
+)
+
+// secretScope is the channel a secret belongs to. Its tenant and ID are authenticated
+// with the secret, so a secret copied to another channel or tenant fails to decrypt.
+type secretScope struct {
+	tenantID  string
+	channelID uint
+}
+
+// channelSecretScope returns the scope of a saved channel's secrets
+func channelSecretScope(channel *models.Channel) secretScope {
+	return secretScope{tenantID: channel.TenantID, channelID: channel.ID}
+}
+
+// additionalData returns the data authenticated with a setting's ciphertext. Tenant
+// IDs and setting names contain no slash, so it is unambiguous.
+func (s secretScope) additionalData(setting string) []byte {
+	return []byte(s.tenantID + "/" + strconv.FormatUint(uint64(s.channelID), 10) + "/" + setting)
+}
+
+// masterKey is a key-encryption key that wraps the data keys of channel secrets
+type masterKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// channelKeyring holds the master key new secrets are encrypted with and the previous
+// keys that can still decrypt secrets not yet rotated
+type channelKeyring struct {
+	current *masterKey
+	keys    map[string]*masterKey
+}
+
+// loadChannelKeyring loads the master keys from CHANNEL_MASTER_KEY_FILE, one base64
+// key per line with the current key first, or else from CHANNEL_MASTER_KEY and
+// CHANNEL_PREVIOUS_MASTER_KEYS. It returns nil when no key is configured.
+func loadChannelKeyring(cfg *config.Config) (*channelKeyring, error) {
+	encoded := append([]string{cfg.ChannelMasterKey}, cfg.ChannelPreviousMasterKeys...)
+	if cfg.ChannelMasterKeyFile != "" {
+		data, err := os.ReadFile(cfg.ChannelMasterKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read channel master key file: %w", err)
+		}
+		encoded = strings.Split(string(data), "\n")
+	}
+
+	var keyring *channelKeyring
+	for _, line := range encoded {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		key, err := newMasterKey(line)
+		if err != nil {
+			return nil, err
+		}
+		if keyring == nil {
+			keyring = &channelKeyring{current: key, keys: make(map[string]*masterKey)}
+		}
+		keyring.keys[key.id] = key
+	}
+	return keyring, nil
+}
+
+// newMasterKey parses a base64 encoded 256-bit master key. Its ID is derived from the
+// key so secrets record which key wrapped them.
+func newMasterKey(encoded string) (*masterKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(raw) != 32 {
+		return nil, errors.New("channel master keys must be 32 bytes, base64 encoded")
+	}
+	aead, err := newAEAD(raw)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(raw)
+	return &masterKey{id: hex.EncodeToString(sum[:4]), aead: aead}, nil
+}
+
+// newAEAD returns AES-256-GCM with the given key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealAEAD encrypts plaintext with aead under a random nonce, which is prepended
+func sealAEAD(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// openAEAD decrypts a value produced by sealAEAD
+func openAEAD(aead cipher.AEAD, sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+// isSealedSecret reports whether a setting holds an encrypted secret
+func isSealedSecret(value string) bool {
+	return strings.HasPrefix(value, sealedSecretPrefix) || strings.HasPrefix(value, legacySealedSecretPrefix)
+}
+
+// encrypt encrypts a secret setting with a fresh data key, wrapped by the current
+// master key. The setting's name and channel are authenticated so secrets cannot be
+// swapped between settings, channels or tenants.
+func (k *channelKeyring) encrypt(scope secretScope, setting, plaintext string) (string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	wrapped, err := sealAEAD(k.current.aead, dataKey, []byte(k.current.id))
+	if err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := sealAEAD(aead, []byte(plaintext), scope.additionalData(setting))
+	if err != nil {
+		return "", err
+	}
+
+	return sealedSecretPrefix + k.current.id + ":" +
+		base64.StdEncoding.EncodeToString(wrapped) + ":" +
+		base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// decrypt decrypts a secret setting of a channel encrypted with any of the keyring's
+// master keys
+func (k *channelKeyring) decrypt(scope secretScope, setting, value string) (string, error) {
+	additionalData := scope.additionalData(setting)
+	if strings.HasPrefix(value, legacySealedSecretPrefix) {
+		additionalData = []byte(setting)
+	}
+	parts := strings.Split(strings.TrimPrefix(strings.TrimPrefix(value, sealedSecretPrefix), legacySealedSecretPrefix), ":")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed encrypted %s", setting)
+	}
+	key := k.keys[parts[0]]
+	if key == nil {
+		return "", fmt.Errorf("%s was encrypted with unknown master key %s", setting, parts[0])
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted %s", setting)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted %s", setting)
+	}
+
+	dataKey, err := openAEAD(key.aead, wrapped, []byte(key.id))
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap the data key of %s: %w", setting, err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := openAEAD(aead, ciphertext, additionalData)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt %s: %w", setting, err)
+	}
+	return string(plaintext), nil
+}
+
+// needsRotation reports whether a secret setting is stored in plaintext, in the legacy
+// format or wrapped by a master key other than the current one
+func (k *channelKeyring) needsRotation(value string) bool {
+	return !strings.HasPrefix(value, sealedSecretPrefix+k.current.id+":")
+}
+
+// openChannelConfig returns a copy of a channel config with its secrets decrypted.
+// Secrets stored before encryption was configured are returned as they are.
+func openChannelConfig(keyring *channelKeyring, scope secretScope, channelConfig models.JSON) (models.JSON, error) {
+	opened := make(models.JSON, len(channelConfig))
+	for key, value := range channelConfig {
+		if s, ok := value.(string); ok && channelSecretSettings[key] && isSealedSecret(s) {
+			if keyring == nil {
+				return nil, fmt.Errorf("%s is encrypted: %w", key, ErrChannelKeyNotConfigured)
+			}
+			plaintext, err := keyring.decrypt(scope, key, s)
+			if err != nil {
+				return nil, err
+			}
+			value = plaintext
+		}
+		opened[key] = value
+	}
+	return opened, nil
+}
+
+// sealChannelConfig returns a copy of a channel config with its secrets encrypted
+// with the current master key
+func sealChannelConfig(keyring *channelKeyring, scope secretScope, channelConfig models.JSON) (models.JSON, error) {
+	sealed := make(models.JSON, len(channelConfig))
+	for key, value := range channelConfig {
+		if s, ok := value.(string); ok && channelSecretSettings[key] && s != "" {
+			if keyring == nil {
+				return nil, fmt.Errorf("cannot store %s: %w", key, ErrChannelKeyNotConfigured)
+			}
+			encrypted, err := keyring.encrypt(scope, key, s)
+			if err != nil {
+				return nil, err
+			}
+			value = encrypted
+		}
+		sealed[key] = value
+	}
+	return sealed, nil
+}
+
+// mergeChannelSecrets returns the config of a channel update. Secret settings are
+// write-only: one left out or sent as the redacted placeholder keeps the stored value,
+// and one sent empty is removed.
+func mergeChannelSecrets(update, stored models.JSON) models.JSON {
+	merged := make(models.JSON, len(update))
+	for key, value := range update {
+		if channelSecretSettings[key] && (value == redactedSecret || value == "") {
+			continue
+		}
+		merged[key] = value
+	}
+	for key := range channelSecretSettings {
+		_, updated := update[key]
+		if stored[key] != nil && (!updated || update[key] == redactedSecret) {
+			merged[key] = stored[key]
+		}
+	}
+	return merged
+}
+
+// redactChannel returns a copy of a channel with its secret settings redacted
+func redactChannel(channel *models.Channel) *models.Channel {
+	redacted := *channel
+	redacted.Config = make(models.JSON, len(channel.Config))
+	for key, value := range channel.Config {
+		if channelSecretSettings[key] {
+			value = redactedSecret
+		}
+		redacted.Config[key] = value
+	}
+	return &redacted
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+)
+
+// newTestMasterKey returns a random base64 encoded master key
+func newTestMasterKey(t *testing.T) string {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func TestChannelKeyringEncryptsSecrets(t *testing.T) {
+	keyring, err := loadChannelKeyring(&config.Config{ChannelMasterKey: newTestMasterKey(t)})
+	if err != nil || keyring == nil {
+		t.Fatalf("Failed to load keyring: %v", err)
+	}
+	scope := secretScope{tenantID: "team-a", channelID: 7}
+
+	sealed, err := keyring.encrypt(scope, "smtp_password", "hunter2")
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	if !isSealedSecret(sealed) || strings.Contains(sealed, "hunter2") {
+		t.Fatalf("Expected an encrypted secret, got %s", sealed)
+	}
+	if again, _ := keyring.encrypt(scope, "smtp_password", "hunter2"); again == sealed {
+		t.Error("Expected each encryption to use a fresh data key")
+	}
+	if plaintext, err := keyring.decrypt(scope, "smtp_password", sealed); err != nil || plaintext != "hunter2" {
+		t.Errorf("Expected hunter2, got %q (%v)", plaintext, err)
+	}
+	if _, err := keyring.decrypt(scope, "token", sealed); err == nil {
+		t.Error("Expected a secret moved to another setting to fail to decrypt")
+	}
+	for _, other := range []secretScope{{tenantID: "team-a", channelID: 8}, {tenantID: "team-b", channelID: 7}} {
+		if _, err := keyring.decrypt(other, "smtp_password", sealed); err == nil {
+			t.Errorf("Expected a secret copied to %+v to fail to decrypt", other)
+		}
+	}
+	if _, err := keyring.decrypt(scope, "smtp_password", sealed[:len(sealed)-4]+"AAA="); err == nil {
+		t.Error("Expected a tampered secret to fail to decrypt")
+	}
+}
+
+func TestChannelKeyringDecryptsLegacySecrets(t *testing.T) {
+	keyring, _ := loadChannelKeyring(&config.Config{ChannelMasterKey: newTestMasterKey(t)})
+
+	// Legacy secrets only authenticate the setting name
+	dataKey := make([]byte, 32)
+	rand.Read(dataKey)
+	wrapped, _ := sealAEAD(keyring.current.aead, dataKey, []byte(keyring.current.id))
+	aead, _ := newAEAD(dataKey)
+	ciphertext, _ := sealAEAD(aead, []byte("hunter2"), []byte("smtp_password"))
+	legacy := legacySealedSecretPrefix + keyring.current.id + ":" +
+		base64.StdEncoding.EncodeToString(wrapped) + ":" + base64.StdEncoding.EncodeToString(ciphertext)
+
+	if !isSealedSecret(legacy) || !keyring.needsRotation(legacy) {
+		t.Error("Expected a legacy secret to be sealed and need rotation")
+	}
+	if plaintext, err := keyring.decrypt(secretScope{tenantID: "default", channelID: 1}, "smtp_password", legacy); err != nil || plaintext != "hunter2" {
+		t.Errorf("Expected the legacy secret to decrypt, got %q (%v)", plaintext, err)
+	}
+}
+
+func TestChannelKeyringRotation(t *testing.T) {
+	oldKey, newKey := newTestMasterKey(t), newTestMasterKey(t)
+	previous, _ := loadChannelKeyring(&config.Config{ChannelMasterKey: oldKey})
+	scope := secretScope{tenantID: "default", channelID: 1}
+	sealed, _ := previous.encrypt(scope, "token", "xoxb-1")
+
+	path := filepath.Join(t.TempDir(), "master.key")
+	if err := os.WriteFile(path, []byte(newKey+"\n"+oldKey+"\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+	keyring, err := loadChannelKeyring(&config.Config{ChannelMasterKey: "ignored", ChannelMasterKeyFile: path})
+	if err != nil {
+		t.Fatalf("Failed to load keyring: %v", err)
+	}
+
+	if !keyring.needsRotation(sealed) || !keyring.needsRotation("xoxb-1") {
+		t.Error("Expected secrets under a previous key or in plaintext to need rotation")
+	}
+	opened, err := openChannelConfig(keyring, scope, models.JSON{"token": sealed, "channel": "#ops"})
+	if err != nil || opened["token"] != "xoxb-1" || opened["channel"] != "#ops" {
+		t.Fatalf("Expected the previous key to decrypt, got %v (%v)", opened, err)
+	}
+	resealed, err := sealChannelConfig(keyring, scope, opened)
+	if err != nil {
+		t.Fatalf("Failed to seal: %v", err)
+	}
+	if token := resealed["token"].(string); keyring.needsRotation(token) || resealed["channel"] != "#ops" {
+		t.Errorf("Expected the token to be encrypted with the current key, got %v", resealed)
+	}
+	if _, err := openChannelConfig(previous, scope, resealed); err == nil {
+		t.Error("Expected the previous key alone not to decrypt rotated secrets")
+	}
+}
+
+func TestLoadChannelKeyring(t *testing.T) {
+	if keyring, err := loadChannelKeyring(&config.Config{}); keyring != nil || err != nil {
+		t.Errorf("Expected no keyring without a key, got %v %v", keyring, err)
+	}
+	if _, err := loadChannelKeyring(&config.Config{ChannelMasterKey: "c2hvcnQ="}); err == nil {
+		t.Error("Expected a short key to be rejected")
+	}
+	if _, err := loadChannelKeyring(&config.Config{ChannelMasterKeyFile: filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Error("Expected a missing key file to be rejected")
+	}
+
+	if _, err := sealChannelConfig(nil, secretScope{}, models.JSON{"token": "xoxb-1"}); !errors.Is(err, ErrChannelKeyNotConfigured) {
+		t.Errorf("Expected secrets not to be stored without a key, got %v", err)
+	}
+	if sealed, err := sealChannelConfig(nil, secretScope{}, models.JSON{"channel": "#ops"}); err != nil || sealed["channel"] != "#ops" {
+		t.Errorf("Expected settings without secrets to be stored without a key, got %v %v", sealed, err)
+	}
+}
+
+func TestMergeChannelSecrets(t *testing.T) {
+	stored := models.JSON{"smtp_host": "smtp.example.com", "smtp_password": "enc:v1:stored", "dkim_private_key": "enc:v1:dkim"}
+
+	merged := mergeChannelSecrets(models.JSON{"smtp_host": "smtp2.example.com"}, stored)
+	if merged["smtp_password"] != "enc:v1:stored" || merged["smtp_host"] != "smtp2.example.com" {
+		t.Errorf("Expected an omitted secret to be kept, got %v", merged)
+	}
+	merged = mergeChannelSecrets(models.JSON{"smtp_password": redactedSecret, "dkim_private_key": ""}, stored)
+	if merged["smtp_password"] != "enc:v1:stored" {
+		t.Errorf("Expected a redacted secret to be kept, got %v", merged)
+	}
+	if _, ok := merged["dkim_private_key"]; ok {
+		t.Errorf("Expected an empty secret to be removed, got %v", merged)
+	}
+	if merged = mergeChannelSecrets(models.JSON{"smtp_password": "new"}, stored); merged["smtp_password"] != "new" {
+		t.Errorf("Expected a new secret to replace the stored one, got %v", merged)
+	}
+	if merged = mergeChannelSecrets(models.JSON{"token": redactedSecret}, nil); len(merged) != 0 {
+		t.Errorf("Expected the redacted placeholder not to be stored, got %v", merged)
+	}
+}
+
+func TestRedactChannel(t *testing.T) {
+	channel := &models.Channel{Name: "ops", Type: models.SlackNotification, Config: models.JSON{"token": "enc:v1:x", "channel": "#ops"}}
+
+	redacted := redactChannel(channel)
+	if redacted.Config["token"] != redactedSecret || redacted.Config["channel"] != "#ops" || redacted.Name != "ops" {
+		t.Errorf("Unexpected redacted channel %+v", redacted)
+	}
+	if channel.Config["token"] != "enc:v1:x" {
+		t.Error("Expected the original channel to be left as it was")
+	}
+}
@@ -0,0 +1,438 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"net/url"
+	"strings"
+
+	"notification-service/internal/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidChannel is returned when a channel's name, type or settings are invalid
+	ErrInvalidChannel = errors.New("invalid channel")
+	// ErrChannelInactive is returned when a notification is sent through a deactivated channel
+	ErrChannelInactive = errors.New("channel is inactive")
+)
+
+// channelSettings lists the config settings each type of channel accepts
+var channelSettings = map[models.NotificationType][]string{
+	models.EmailNotification: {
+		"smtp_host", "smtp_port", "smtp_username", "smtp_password", "smtp_pool_size",
+		"from_address", "from_name",
+		"dkim_domain", "dkim_selector", "dkim_private_key",
+	},
+	models.SlackNotification: {"token", "channel", "signing_secret", "action_webhook_url"},
+	models.InAppNotification: {},
+}
+
+// channelIntSettings are the settings that hold positive integers
+var channelIntSettings = map[string]bool{"smtp_port": true, "smtp_pool_size": true}
+
+// validateChannel checks a channel's name, type and settings
+func validateChannel(channel *models.Channel) error {
+	if strings.TrimSpace(channel.Name) == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidChannel)
+	}
+	allowed, ok := channelSettings[channel.Type]
+	if !ok {
+		return fmt.Errorf("%w: unsupported type %q", ErrInvalidChannel, channel.Type)
+	}
+
+	for key, value := range channel.Config {
+		known := false
+		for _, setting := range allowed {
+			known = known || setting == key
+		}
+		if !known {
+			return fmt.Errorf("%w: unknown %s setting %q", ErrInvalidChannel, channel.Type, key)
+		}
+		if channelIntSettings[key] {
+			if n, ok := configInt(channel.Config, key); !ok || n <= 0 {
+				return fmt.Errorf("%w: %s must be a positive integer", ErrInvalidChannel, key)
+			}
+		} else if _, ok := value.(string); !ok {
+			return fmt.Errorf("%w: %s must be a string", ErrInvalidChannel, key)
+		}
+	}
+
+	if address := configString(channel.Config, "from_address"); address != "" {
+		if _, err := mail.ParseAddress(address); err != nil {
+			return fmt.Errorf("%w: from_address %q is not a valid email address", ErrInvalidChannel, address)
+		}
+	}
+	if webhookURL := configString(channel.Config, "action_webhook_url"); webhookURL != "" {
+		if u, err := url.Parse(webhookURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("%w: action_webhook_url %q is not an http or https URL", ErrInvalidChannel, webhookURL)
+		}
+	}
+	if domain := configString(channel.Config, "dkim_domain"); domain != "" {
+		keyPEM := configString(channel.Config, "dkim_private_key")
+		if keyPEM == "" {
+			return fmt.Errorf("%w: dkim_domain requires dkim_private_key", ErrInvalidChannel)
+		}
+		if _, err := ParseDKIMKey(domain, configString(channel.Config, "dkim_selector"), []byte(keyPEM)); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidChannel, err)
+		}
+	}
+	return nil
+}
+
+// GetChannels lists a tenant's configured channels, with their secrets redacted
+func (s *NotificationService) GetChannels(tenantID string) ([]models.Channel, error) {
+	var channels []models.Channel
+	if err := s.db.Where("tenant_id = ?", tenantID).Order("name").Find(&channels).Error; err != nil {
+		return nil, err
+	}
+	for i := range channels {
+		channels[i] = *redactChannel(&channels[i])
+	}
+	return channels, nil
+}
+
+// GetChannel returns one of a tenant's configured channels, with its secrets redacted
+func (s *NotificationService) GetChannel(tenantID string, id uint) (*models.Channel, error) {
+	channel, err := s.storedChannel(tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	return redactChannel(channel), nil
+}
+
+// storedChannel returns a tenant's channel as it is stored, with its secrets encrypted
+func (s *NotificationService) storedChannel(tenantID string, id uint) (*models.Channel, error) {
+	var channel models.Channel
+	if err := s.db.Where("tenant_id = ?", tenantID).First(&channel, id).Error; err != nil {
+		return nil, err
+	}
+	return &channel, nil
+}
+
+// CreateChannel configures a new channel for a tenant, within its channel quota
+func (s *NotificationService) CreateChannel(tenantID string, req *models.ChannelRequest) (*models.Channel, error) {
+	channel := &models.Channel{
+		TenantID: tenantID,
+		Name:     strings.TrimSpace(req.Name),
+		Type:     req.Type,
+		Config:   mergeChannelSecrets(req.Config, nil),
+		IsActive: true,
+	}
+	if req.IsActive != nil {
+		channel.IsActive = *req.IsActive
+	}
+	if err := s.saveChannel(channel); err != nil {
+		return nil, err
+	}
+	return redactChannel(channel), nil
+}
+
+// UpdateChannel replaces the settings of a tenant's channel, keeping the stored secrets
+// the request leaves out. Senders built from the old settings are replaced on their
+// next use.
+func (s *NotificationService) UpdateChannel(tenantID string, id uint, req *models.ChannelRequest) (*models.Channel, error) {
+	channel, err := s.storedChannel(tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	oldName := channel.Name
+
+	channel.Config = updatedChannelConfig(channel, req)
+	channel.Name = strings.TrimSpace(req.Name)
+	channel.Type = req.Type
+	if req.IsActive != nil {
+		channel.IsActive = *req.IsActive
+	}
+	if err := s.saveChannel(channel); err != nil {
+		return nil, err
+	}
+
+	s.forgetChannel(tenantID, oldName)
+	return redactChannel(channel), nil
+}
+
+// updatedChannelConfig returns the settings a request gives a channel, keeping the
+// stored secrets it leaves out only while the channel's type, and so its settings,
+// stay the same
+func updatedChannelConfig(channel *models.Channel, req *models.ChannelRequest) models.JSON {
+	if req.Type != channel.Type {
+		return mergeChannelSecrets(req.Config, nil)
+	}
+	return mergeChannelSecrets(req.Config, channel.Config)
+}
+
+// DeleteChannel removes a tenant's channel. It is deleted permanently so its name can
+// be reused.
+func (s *NotificationService) DeleteChannel(tenantID string, id uint) error {
+	channel, err := s.storedChannel(tenantID, id)
+	if err != nil {
+		return err
+	}
+	if err := s.db.Unscoped().Delete(channel).Error; err != nil {
+		return err
+	}
+
+	s.forgetChannel(tenantID, channel.Name)
+	return nil
+}
+
+// saveChannel validates a channel and saves it with its secrets encrypted, rejecting
+// names its tenant already uses and new channels beyond its channel quota
+func (s *NotificationService) saveChannel(channel *models.Channel) error {
+	if channel.Config == nil {
+		channel.Config = models.JSON{}
+	}
+	opened, err := openChannelConfig(s.keyring, channelSecretScope(channel), channel.Config)
+	if err != nil {
+		return err
+	}
+	channel.Config = opened
+	if err := validateChannel(channel); err != nil {
+		return err
+	}
+
+	var named []models.Channel
+	if err := s.db.Unscoped().Where("tenant_id = ? AND name = ?", channel.TenantID, channel.Name).Find(&named).Error; err != nil {
+		return err
+	}
+	if duplicateChannel(named, channel) {
+		return fmt.Errorf("%w: a channel named %s already exists", ErrInvalidChannel, channel.Name)
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		// A new channel is created without its secrets first, so they can be bound to its ID
+		if channel.ID == 0 {
+			if err := s.checkChannelQuota(tx, channel.TenantID); err != nil {
+				return err
+			}
+			channel.Config = make(models.JSON, len(opened))
+			for key, value := range opened {
+				if !channelSecretSettings[key] {
+					channel.Config[key] = value
+				}
+			}
+			if err := tx.Create(channel).Error; err != nil {
+				return err
+			}
+		}
+
+		sealed, err := sealChannelConfig(s.keyring, channelSecretScope(channel), opened)
+		if err != nil {
+			return err
+		}
+		channel.Config = sealed
+		return tx.Save(channel).Error
+	})
+}
+
+// duplicateChannel reports whether another of the channel's tenant's channels uses its name
+func duplicateChannel(channels []models.Channel, channel *models.Channel) bool {
+	for i := range channels {
+		if channels[i].ID != channel.ID && channels[i].TenantID == channel.TenantID && channels[i].Name == channel.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// openChannel decrypts a channel's secrets for its sender. A secret that cannot be
+// decrypted is logged and left encrypted, so the sender fails to authenticate rather
+// than falling back to the environment's credentials.
+func (s *NotificationService) openChannel(channel *models.Channel) {
+	for key, value := range channel.Config {
+		sealed, ok := value.(string)
+		if !ok || !channelSecretSettings[key] || !isSealedSecret(sealed) {
+			continue
+		}
+		opened, err := openChannelConfig(s.keyring, channelSecretScope(channel), models.JSON{key: sealed})
+		if err != nil {
+			log.Printf("Failed to decrypt %s of channel %s: %v", key, channel.Name, err)
+			continue
+		}
+		channel.Config[key] = opened[key]
+	}
+}
+
+// RotateChannelSecrets re-encrypts with the current master key the secrets of a
+// tenant's channels stored in plaintext or under a previous key, and returns how many
+// channels changed
+func (s *NotificationService) RotateChannelSecrets(tenantID string) (int, error) {
+	return s.rotateChannelSecrets(s.db.Unscoped().Where("tenant_id = ?", tenantID))
+}
+
+// RotateAllChannelSecrets re-encrypts the stale secrets of every tenant's channels, as
+// RotateChannelSecrets does for one tenant
+func (s *NotificationService) RotateAllChannelSecrets() (int, error) {
+	return s.rotateChannelSecrets(s.db.Unscoped())
+}
+
+// rotateChannelSecrets re-encrypts the stale secrets of the channels a query finds
+func (s *NotificationService) rotateChannelSecrets(query *gorm.DB) (int, error) {
+	var channels []models.Channel
+	if err := query.Find(&channels).Error; err != nil {
+		return 0, err
+	}
+
+	rotated := 0
+	for i := range channels {
+		channel := &channels[i]
+		stale := false
+		for key, value := range channel.Config {
+			if secret, ok := value.(string); ok && channelSecretSettings[key] && secret != "" {
+				stale = stale || s.keyring == nil || s.keyring.needsRotation(secret)
+			}
+		}
+		if !stale {
+			continue
+		}
+
+		opened, err := openChannelConfig(s.keyring, channelSecretScope(channel), channel.Config)
+		if err != nil {
+			return rotated, fmt.Errorf("channel %s: %w", channel.Name, err)
+		}
+		sealed, err := sealChannelConfig(s.keyring, channelSecretScope(channel), opened)
+		if err != nil {
+			return rotated, fmt.Errorf("channel %s: %w", channel.Name, err)
+		}
+		if err := s.db.Unscoped().Model(channel).Update("config", sealed).Error; err != nil {
+			return rotated, err
+		}
+		rotated++
+	}
+	return rotated, nil
+}
+
+// forgetChannel drops the senders and connections built from a tenant's channel
+func (s *NotificationService) forgetChannel(tenantID, name string) {
+	s.mu.Lock()
+	delete(s.slackSenders, channelKey(tenantID, name))
+	s.mu.Unlock()
+
+	s.emailSender.closePool(tenantID, name)
+}
+
+// activeChannel returns the tenant's configured channel a notification names, or nil
+// when it names none, failing when the channel is inactive
+func (s *NotificationService) activeChannel(tenantID string, notificationType models.NotificationType, channelName string) (*models.Channel, error) {
+	if channelName == "" {
+		return nil, nil
+	}
+	channel := s.findChannel(tenantID, channelName, notificationType)
+	if err := checkChannelActive(channel); err != nil {
+		return nil, err
+	}
+	return channel, nil
+}
+
+// checkChannelActive fails with ErrChannelInactive for a deactivated channel
+func checkChannelActive(channel *models.Channel) error {
+	if channel != nil && !channel.IsActive {
+		return fmt.Errorf("%w: %s", ErrChannelInactive, channel.Name)
+	}
+	return nil
+}
+
+// senderFor returns the sender that delivers a notification type through a tenant's
+// named channel. Notifications without a channel, or naming none the tenant has
+// configured, use the sender configured from the environment.
+func (s *NotificationService) senderFor(tenantID string, notificationType models.NotificationType, channelName string) (Sender, error) {
+	channel, err := s.activeChannel(tenantID, notificationType, channelName)
+	if err != nil {
+		return nil, err
+	}
+
+	switch notificationType {
+	case models.EmailNotification:
+		return s.emailSender, nil
+	case models.SlackNotification:
+		return s.slackSenderForChannel(tenantID, channel)
+	case models.InAppNotification:
+		return s.inAppSender, nil
+	default:
+		return nil, fmt.Errorf("unsupported notification type: %s", notificationType)
+	}
+}
+
+// slackSenderFor returns the Slack sender for a tenant's named channel
+func (s *NotificationService) slackSenderFor(tenantID, channelName string) (*SlackSender, error) {
+	var channel *models.Channel
+	if channelName != "" {
+		channel = s.findChannel(tenantID, channelName, models.SlackNotification)
+	}
+	return s.slackSenderForChannel(tenantID, channel)
+}
+
+// slackSenderForChannel returns the Slack sender built from a tenant's channel, or
+// for the default tenant the sender configured from the environment when channel is
+// nil. Senders are cached until the channel changes.
+func (s *NotificationService) slackSenderForChannel(tenantID string, channel *models.Channel) (*SlackSender, error) {
+	if channel == nil {
+		if tenantID != models.DefaultTenant {
+			return nil, fmt.Errorf("%w: no Slack channel", ErrTenantChannelRequired)
+		}
+		return s.slackSender, nil
+	}
+	if tenantID != models.DefaultTenant && configString(channel.Config, "token") == "" {
+		return nil, fmt.Errorf("%w: channel %s sets no token", ErrTenantChannelRequired, channel.Name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := channelKey(channel.TenantID, channel.Name)
+	if cached, ok := s.slackSenders[key]; ok && cached.updatedAt.Equal(channel.UpdatedAt) {
+		return cached.sender, nil
+	}
+	sender := newChannelSlackSender(s.config, channel)
+	s.slackSenders[key] = cachedSlackSender{updatedAt: channel.UpdatedAt, sender: sender}
+	return sender, nil
+}
+
+// TestChannelConnection checks that a channel type can connect with the settings of a
+// tenant's named channel, or with the environment's settings when name is empty
+func (s *NotificationService) TestChannelConnection(tenantID string, notificationType models.NotificationType, name string) error {
+	if name != "" && s.findChannel(tenantID, name, notificationType) == nil {
+		return gorm.ErrRecordNotFound
+	}
+
+	switch notificationType {
+	case models.EmailNotification:
+		return s.emailSender.testConnection(tenantID, name)
+	case models.SlackNotification:
+		sender, err := s.slackSenderFor(tenantID, name)
+		if err != nil {
+			return err
+		}
+		return sender.TestConnection()
+	case models.InAppNotification:
+		return s.inAppSender.TestConnection()
+	default:
+		return fmt.Errorf("unsupported notification type: %s", notificationType)
+	}
+}
+
+// SlackThrottles returns the Slack rate limit state of the sender configured from the
+// environment and of each of a tenant's active Slack channels
+func (s *NotificationService) SlackThrottles(tenantID string) ([]SlackThrottle, map[string][]SlackThrottle, error) {
+	var channels []models.Channel
+	if err := s.db.Where("tenant_id = ? AND type = ? AND is_active = ?", tenantID, models.SlackNotification, true).Find(&channels).Error; err != nil {
+		return nil, nil, err
+	}
+
+	throttles := make(map[string][]SlackThrottle, len(channels))
+	for i := range channels {
+		s.openChannel(&channels[i])
+		if sender, err := s.slackSenderForChannel(tenantID, &channels[i]); err == nil {
+			throttles[channels[i].Name] = sender.Throttle()
+		}
+	}
+	// Only the default tenant shares the limits of the token from the environment
+	var environment []SlackThrottle
+	if tenantID == models.DefaultTenant {
+		environment = s.slackSender.Throttle()
+	}
+	return environment, throttles, nil
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"notification-service/internal/models"
+)
+
+func TestValidateChannel(t *testing.T) {
+	tests := []struct {
+		name    string
+		channel models.Channel
+		valid   bool
+	}{
+		{"email", models.Channel{Name: "bulk", Type: models.EmailNotification, Config: models.JSON{"smtp_host": "smtp.example.com", "smtp_port": float64(587), "from_address": "news@example.com"}}, true},
+		{"slack", models.Channel{Name: "ops", Type: models.SlackNotification, Config: models.JSON{"token": "xoxb-1", "channel": "#ops"}}, true},
+		{"in-app without settings", models.Channel{Name: "inbox", Type: models.InAppNotification, Config: models.JSON{}}, true},
+		{"missing name", models.Channel{Name: " ", Type: models.SlackNotification}, false},
+		{"unsupported type", models.Channel{Name: "sms", Type: "sms"}, false},
+		{"unknown setting", models.Channel{Name: "ops", Type: models.SlackNotification, Config: models.JSON{"smtp_host": "smtp.example.com"}}, false},
+		{"invalid port", models.Channel{Name: "bulk", Type: models.EmailNotification, Config: models.JSON{"smtp_port": "none"}}, false},
+		{"non-string setting", models.Channel{Name: "ops", Type: models.SlackNotification, Config: models.JSON{"token": float64(1)}}, false},
+		{"action webhook", models.Channel{Name: "ops", Type: models.SlackNotification, Config: models.JSON{"action_webhook_url": "https://example.com/actions"}}, true},
+		{"invalid action webhook", models.Channel{Name: "ops", Type: models.SlackNotification, Config: models.JSON{"action_webhook_url": "file:///etc/passwd"}}, false},
+		{"invalid from address", models.Channel{Name: "bulk", Type: models.EmailNotification, Config: models.JSON{"from_address": "news"}}, false},
+		{"DKIM without a key", models.Channel{Name: "bulk", Type: models.EmailNotification, Config: models.JSON{"dkim_domain": "example.com"}}, false},
+		{"DKIM key file", models.Channel{Name: "bulk", Type: models.EmailNotification, Config: models.JSON{"dkim_domain": "example.com", "dkim_private_key_file": "/etc/passwd"}}, false},
+		{"invalid DKIM key", models.Channel{Name: "bulk", Type: models.EmailNotification, Config: models.JSON{"dkim_domain": "example.com", "dkim_private_key": "not a key"}}, false},
+	}
+	for _, tt := range tests {
+		err := validateChannel(&tt.channel)
+		if tt.valid && err != nil {
+			t.Errorf("%s: expected the channel to be valid, got %v", tt.name, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrInvalidChannel) {
+			t.Errorf("%s: expected ErrInvalidChannel, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestDuplicateChannel(t *testing.T) {
+	named := []models.Channel{
+		{ID: 1, TenantID: "team-a", Name: "ops"},
+		{ID: 2, TenantID: "team-b", Name: "ops"},
+	}
+
+	tests := []struct {
+		name      string
+		channel   models.Channel
+		duplicate bool
+	}{
+		{"new channel with a used name", models.Channel{TenantID: "team-a", Name: "ops"}, true},
+		{"renamed onto a used name", models.Channel{ID: 3, TenantID: "team-a", Name: "ops"}, true},
+		{"saved under its own name", models.Channel{ID: 1, TenantID: "team-a", Name: "ops"}, false},
+		{"name used by another tenant", models.Channel{TenantID: "team-c", Name: "ops"}, false},
+		{"unused name", models.Channel{TenantID: "team-a", Name: "alerts"}, false},
+	}
+	for _, tt := range tests {
+		if got := duplicateChannel(named, &tt.channel); got != tt.duplicate {
+			t.Errorf("%s: expected duplicate %v, got %v", tt.name, tt.duplicate, got)
+		}
+	}
+}
+
+func TestUpdatedChannelConfigDropsSecretsOnTypeChange(t *testing.T) {
+	channel := &models.Channel{Name: "ops", Type: models.SlackNotification, Config: models.JSON{"token": "xoxb-1", "signing_secret": "s3cret", "channel": "#ops"}}
+
+	kept := updatedChannelConfig(channel, &models.ChannelRequest{Name: "ops", Type: models.SlackNotification, Config: models.JSON{"token": redactedSecret, "channel": "#alerts"}})
+	if kept["token"] != "xoxb-1" || kept["signing_secret"] != "s3cret" || kept["channel"] != "#alerts" {
+		t.Errorf("Expected the stored secrets kept while the type stays the same, got %v", kept)
+	}
+
+	changed := updatedChannelConfig(channel, &models.ChannelRequest{Name: "ops", Type: models.EmailNotification, Config: models.JSON{"smtp_host": "smtp.example.com", "token": redactedSecret}})
+	if _, ok := changed["token"]; ok {
+		t.Errorf("Expected the old token dropped when the type changes, got %v", changed)
+	}
+	if _, ok := changed["signing_secret"]; ok {
+		t.Errorf("Expected the old signing secret dropped when the type changes, got %v", changed)
+	}
+	if changed["smtp_host"] != "smtp.example.com" {
+		t.Errorf("Expected the new settings kept, got %v", changed)
+	}
+}
+
+func TestCheckChannelActive(t *testing.T) {
+	if err := checkChannelActive(nil); err != nil {
+		t.Errorf("Expected no error without a channel, got %v", err)
+	}
+	if err := checkChannelActive(&models.Channel{Name: "ops", IsActive: true}); err != nil {
+		t.Errorf("Expected an active channel to pass, got %v", err)
+	}
+	if err := checkChannelActive(&models.Channel{Name: "ops"}); !errors.Is(err, ErrChannelInactive) {
+		t.Errorf("Expected ErrChannelInactive, got %v", err)
+	}
+}
+
+func TestTenantWithoutChannelRequiresOne(t *testing.T) {
+	s := &NotificationService{}
+
+	if _, err := s.slackSenderForChannel("team-a", nil); !errors.Is(err, ErrTenantChannelRequired) {
+		t.Errorf("Expected ErrTenantChannelRequired for Slack, got %v", err)
+	}
+	if _, err := s.slackSenderForChannel("team-a", &models.Channel{Name: "ops", Config: models.JSON{"channel": "#ops"}}); !errors.Is(err, ErrTenantChannelRequired) {
+		t.Errorf("Expected ErrTenantChannelRequired for a Slack channel without a token, got %v", err)
+	}
+
+	e := &EmailSender{}
+	if _, err := e.dialer("team-a", nil); !errors.Is(err, ErrTenantChannelRequired) {
+		t.Errorf("Expected ErrTenantChannelRequired for email, got %v", err)
+	}
+	if _, err := e.dialer("team-a", &models.Channel{Name: "bulk", Config: models.JSON{"from_address": "news@example.com"}}); !errors.Is(err, ErrTenantChannelRequired) {
+		t.Errorf("Expected ErrTenantChannelRequired for an email channel without an SMTP host, got %v", err)
+	}
+}
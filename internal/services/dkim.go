@@ -0,0 +1,355 @@
+package services
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"notification-service/internal/models"
+
+	"gopkg.in/gomail.v2"
+)
+
+// ErrDKIMNotConfigured is returned when no DKIM key is configured for a sender
+var ErrDKIMNotConfigured = errors.New("DKIM signing is not configured")
+
+// dkimSignedHeaders are the headers covered by a signature when present in the message
+var dkimSignedHeaders = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-ID",
+	"In-Reply-To", "References", "MIME-Version", "Content-Type",
+	"Content-Transfer-Encoding", "List-Unsubscribe", "List-Unsubscribe-Post",
+}
+
+// DKIMKey is a private key used to sign mail from a domain
+type DKIMKey struct {
+	Domain   string
+	Selector string
+	signer   crypto.Signer
+}
+
+// ParseDKIMKey parses a PEM encoded RSA or Ed25519 private key
+func ParseDKIMKey(domain, selector string, pemData []byte) (*DKIMKey, error) {
+	if domain == "" || selector == "" {
+		return nil, errors.New("DKIM domain and selector are required")
+	}
+
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, errors.New("DKIM private key is not PEM encoded")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse DKIM private key: %w", err)
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 1024 {
+			return nil, errors.New("DKIM RSA keys must be at least 1024 bits")
+		}
+		return &DKIMKey{Domain: strings.ToLower(domain), Selector: selector, signer: k}, nil
+	case ed25519.PrivateKey:
+		return &DKIMKey{Domain: strings.ToLower(domain), Selector: selector, signer: k}, nil
+	default:
+		return nil, fmt.Errorf("unsupported DKIM key type %T", key)
+	}
+}
+
+// LoadDKIMKey reads a PEM encoded private key from a file
+func LoadDKIMKey(domain, selector, path string) (*DKIMKey, error) {
+	pemData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DKIM private key: %w", err)
+	}
+	return ParseDKIMKey(domain, selector, pemData)
+}
+
+// algorithm returns the signature algorithm tag for the key
+func (k *DKIMKey) algorithm() string {
+	if _, ok := k.signer.(ed25519.PrivateKey); ok {
+		return "ed25519-sha256"
+	}
+	return "rsa-sha256"
+}
+
+// Covers reports whether the key may sign mail from an address in the given domain,
+// which must be the key's domain or a subdomain of it
+func (k *DKIMKey) Covers(domain string) bool {
+	domain = strings.ToLower(domain)
+	return domain == k.Domain || strings.HasSuffix(domain, "."+k.Domain)
+}
+
+// DNSRecord returns the name and value of the TXT record that publishes the key's
+// public half
+func (k *DKIMKey) DNSRecord() (name, value string, err error) {
+	var keyType, publicKey string
+	switch pub := k.signer.Public().(type) {
+	case *rsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return "", "", err
+		}
+		keyType, publicKey = "rsa", base64.StdEncoding.EncodeToString(der)
+	case ed25519.PublicKey:
+		keyType, publicKey = "ed25519", base64.StdEncoding.EncodeToString(pub)
+	default:
+		return "", "", fmt.Errorf("unsupported DKIM key type %T", pub)
+	}
+
+	name = k.Selector + "._domainkey." + k.Domain
+	value = "v=DKIM1; k=" + keyType + "; p=" + publicKey
+	return name, value, nil
+}
+
+// Sign returns the message with a DKIM-Signature header prepended. Headers and body
+// use relaxed canonicalization.
+func (k *DKIMKey) Sign(message []byte, now time.Time) ([]byte, error) {
+	header, body := splitMessage(message)
+	fields := parseHeaderFields(header)
+
+	bodyHash := sha256.Sum256(canonicalBodyRelaxed(body))
+
+	// Sign the last instance of each header present in the message
+	var names []string
+	var signed bytes.Buffer
+	for _, name := range dkimSignedHeaders {
+		for i := len(fields) - 1; i >= 0; i-- {
+			if strings.EqualFold(headerName(fields[i]), name) {
+				names = append(names, strings.ToLower(name))
+				signed.WriteString(canonicalHeaderRelaxed(fields[i]))
+				break
+			}
+		}
+	}
+	if len(names) == 0 || names[0] != "from" {
+		return nil, errors.New("cannot DKIM sign a message without a From header")
+	}
+
+	signature := "DKIM-Signature: v=1; a=" + k.algorithm() + "; c=relaxed/relaxed;\r\n" +
+		" d=" + k.Domain + "; s=" + k.Selector + "; t=" + strconv.FormatInt(now.Unix(), 10) + ";\r\n" +
+		" h=" + strings.Join(names, ":") + ";\r\n" +
+		" bh=" + base64.StdEncoding.EncodeToString(bodyHash[:]) + ";\r\n" +
+		" b="
+	// The signature header itself is signed with an empty b= and no trailing CRLF
+	signed.WriteString(strings.TrimSuffix(canonicalHeaderRelaxed(signature), "\r\n"))
+
+	digest := sha256.Sum256(signed.Bytes())
+	var sig []byte
+	var err error
+	if key, ok := k.signer.(ed25519.PrivateKey); ok {
+		// RFC 8463 signs the SHA-256 hash with PureEdDSA
+		sig = ed25519.Sign(key, digest[:])
+	} else {
+		sig, err = k.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			return nil, fmt.Errorf("failed to DKIM sign message: %w", err)
+		}
+	}
+
+	var out bytes.Buffer
+	out.WriteString(signature)
+	out.WriteString(foldBase64(base64.StdEncoding.EncodeToString(sig)))
+	out.WriteString("\r\n")
+	out.Write(message)
+	return out.Bytes(), nil
+}
+
+// foldBase64 breaks a long base64 value into continuation lines
+func foldBase64(value string) string {
+	const width = 72
+	var b strings.Builder
+	for len(value) > width {
+		b.WriteString(value[:width])
+		b.WriteString("\r\n ")
+		value = value[width:]
+	}
+	b.WriteString(value)
+	return b.String()
+}
+
+// splitMessage splits a message into its header block, including the final CRLF
+// of the last field, and its body
+func splitMessage(message []byte) (header, body []byte) {
+	if i := bytes.Index(message, []byte("\r\n\r\n")); i >= 0 {
+		return message[:i+2], message[i+4:]
+	}
+	return message, nil
+}
+
+// parseHeaderFields splits a header block into fields, keeping folded lines together
+func parseHeaderFields(header []byte) []string {
+	var fields []string
+	for _, line := range strings.SplitAfter(string(header), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1] += line
+			continue
+		}
+		fields = append(fields, line)
+	}
+	return fields
+}
+
+// headerName returns the name of a header field
+func headerName(field string) string {
+	if i := strings.IndexByte(field, ':'); i >= 0 {
+		return strings.TrimSpace(field[:i])
+	}
+	return ""
+}
+
+var wsp = regexp.MustCompile(`[ \t]+`)
+
+// canonicalHeaderRelaxed applies relaxed header canonicalization (RFC 6376 3.4.2)
+func canonicalHeaderRelaxed(field string) string {
+	i := strings.IndexByte(field, ':')
+	if i < 0 {
+		return field
+	}
+	name := strings.ToLower(strings.TrimSpace(field[:i]))
+	value := strings.ReplaceAll(field[i+1:], "\r\n", "")
+	value = strings.TrimSpace(wsp.ReplaceAllString(value, " "))
+	return name + ":" + value + "\r\n"
+}
+
+// canonicalBodyRelaxed applies relaxed body canonicalization (RFC 6376 3.4.4)
+func canonicalBodyRelaxed(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(wsp.ReplaceAllString(line, " "), " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// sign DKIM signs a message when a key is configured for the tenant's channel and
+// covers the sender's domain. Otherwise the message is returned unchanged.
+func (e *EmailSender) sign(m *gomail.Message, tenantID, channelName, from string) (io.WriterTo, error) {
+	key, err := e.dkimKey(tenantID, channelName)
+	if errors.Is(err, ErrDKIMNotConfigured) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !key.Covers(addressDomain(from)) {
+		return m, nil
+	}
+
+	var raw bytes.Buffer
+	if _, err := m.WriteTo(&raw); err != nil {
+		return nil, fmt.Errorf("failed to build email: %w", err)
+	}
+	signed, err := key.Sign(raw.Bytes(), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(signed), nil
+}
+
+// dkimKey returns the signing key for a tenant's channel, configured by the channel's
+// dkim_domain, dkim_selector and inline dkim_private_key settings, or else, for the
+// default tenant only, by DKIM_DOMAIN, DKIM_SELECTOR and DKIM_PRIVATE_KEY_FILE. Only
+// the environment names a key file, so channels cannot read files off the server.
+// Parsed keys are cached.
+func (e *EmailSender) dkimKey(tenantID, channelName string) (*DKIMKey, error) {
+	var domain, selector, keyPEM, keyFile string
+	if tenantID == models.DefaultTenant {
+		domain, selector, keyFile = e.config.DKIMDomain, e.config.DKIMSelector, e.config.DKIMPrivateKeyFile
+	}
+	if channel := e.channel(tenantID, channelName); channel != nil && configString(channel.Config, "dkim_domain") != "" {
+		domain = configString(channel.Config, "dkim_domain")
+		selector = configString(channel.Config, "dkim_selector")
+		keyPEM = configString(channel.Config, "dkim_private_key")
+		keyFile = ""
+	}
+	if domain == "" || (keyPEM == "" && keyFile == "") {
+		return nil, ErrDKIMNotConfigured
+	}
+
+	cacheKey := strings.Join([]string{domain, selector, keyFile, keyPEM}, "\x00")
+	e.mu.Lock()
+	key := e.dkimKeys[cacheKey]
+	e.mu.Unlock()
+	if key != nil {
+		return key, nil
+	}
+
+	var err error
+	if keyPEM != "" {
+		key, err = ParseDKIMKey(domain, selector, []byte(keyPEM))
+	} else {
+		key, err = LoadDKIMKey(domain, selector, keyFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	e.dkimKeys[cacheKey] = key
+	e.mu.Unlock()
+	return key, nil
+}
+
+// DKIMKey returns the signing key used for email sent through a tenant's channel, or
+// for email without a channel when channelName is empty
+func (e *EmailSender) DKIMKey(tenantID, channelName string) (*DKIMKey, error) {
+	return e.dkimKey(tenantID, channelName)
+}
+
+// addressDomain returns the domain of an email address
+func addressDomain(address string) string {
+	return address[strings.LastIndexByte(address, '@')+1:]
+}
+
+// newMessageID generates a unique Message-ID in the sender's domain
+func newMessageID(from string) string {
+	domain := "localhost"
+	if addr, err := mail.ParseAddress(from); err == nil {
+		domain = addressDomain(addr.Address)
+	}
+	var id [16]byte
+	rand.Read(id[:])
+	return "<" + hex.EncodeToString(id[:]) + "@" + domain + ">"
+}
+
+// SplitTXTRecord splits a TXT record value into the 255 byte strings DNS allows
+func SplitTXTRecord(value string) []string {
+	var parts []string
+	for len(value) > 255 {
+		parts = append(parts, value[:255])
+		value = value[255:]
+	}
+	return append(parts, value)
+}
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+)
+
+func TestDKIMCanonicalization(t *testing.T) {
+	// Examples from RFC 6376 section 3.4.5
+	header := canonicalHeaderRelaxed("A: X\r\n") + canonicalHeaderRelaxed("B : Y\t\r\n\tZ  \r\n")
+	if header != "a:X\r\nb:Y Z\r\n" {
+		t.Errorf("Unexpected relaxed header: %q", header)
+	}
+
+	body := canonicalBodyRelaxed([]byte(" C \r\nD \t E\r\n\r\n\r\n"))
+	if string(body) != " C\r\nD E\r\n" {
+		t.Errorf("Unexpected relaxed body: %q", body)
+	}
+
+	// Body hash of the example message in RFC 8463 appendix A
+	sum := sha256.Sum256(canonicalBodyRelaxed([]byte("Hi.\r\n\r\nWe lost the game.  Are you hungry yet?\r\n\r\nJoe.\r\n")))
+	if bh := base64.StdEncoding.EncodeToString(sum[:]); bh != "2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=" {
+		t.Errorf("Unexpected body hash %s", bh)
+	}
+}
+
+func TestDKIMSignVerifies(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	message := []byte("From: Joe <joe@example.com>\r\n" +
+		"To: suzie@example.net\r\n" +
+		"Subject:   Is dinner\r\n ready?\r\n" +
+		"Date: Fri, 11 Jul 2003 21:00:37 -0700\r\n" +
+		"X-Unsigned: ignored\r\n" +
+		"\r\n" +
+		"Hi.  \r\n\r\nWe lost the game.\r\n\r\n")
+
+	for _, signer := range []crypto.Signer{rsaKey, edKey} {
+		key := &DKIMKey{Domain: "example.com", Selector: "mail", signer: signer}
+		t.Run(key.algorithm(), func(t *testing.T) {
+			signed, err := key.Sign(message, time.Unix(1700000000, 0))
+			if err != nil {
+				t.Fatalf("Failed to sign: %v", err)
+			}
+			if !bytes.HasSuffix(signed, message) {
+				t.Fatal("Expected the original message after the signature header")
+			}
+			verifyDKIM(t, signed, signer.Public())
+		})
+	}
+}
+
+// verifyDKIM checks a relaxed/relaxed DKIM signature independently of Sign
+func verifyDKIM(t *testing.T, signed []byte, public crypto.PublicKey) {
+	t.Helper()
+
+	header, body := splitMessage(signed)
+	fields := parseHeaderFields(header)
+	signature := fields[0]
+	if headerName(signature) != "DKIM-Signature" {
+		t.Fatalf("Expected a DKIM-Signature header first, got %q", signature)
+	}
+
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(strings.SplitN(signature, ":", 2)[1], ";") {
+		parts := strings.SplitN(strings.TrimSpace(tag), "=", 2)
+		if len(parts) == 2 {
+			tags[parts[0]] = regexp.MustCompile(`\s+`).ReplaceAllString(parts[1], "")
+		}
+	}
+	if tags["h"] != "from:subject:date:to" || tags["d"] != "example.com" || tags["s"] != "mail" {
+		t.Errorf("Unexpected tags: %v", tags)
+	}
+
+	bodyHash := sha256.Sum256(canonicalBodyRelaxed(body))
+	if tags["bh"] != base64.StdEncoding.EncodeToString(bodyHash[:]) {
+		t.Errorf("Body hash mismatch")
+	}
+
+	var data strings.Builder
+	for _, name := range strings.Split(tags["h"], ":") {
+		for _, field := range fields[1:] {
+			if strings.EqualFold(headerName(field), name) {
+				data.WriteString(canonicalHeaderRelaxed(field))
+			}
+		}
+	}
+	unsigned := regexp.MustCompile(`b=[^;]*$`).ReplaceAllString(strings.TrimRight(signature, "\r\n"), "b=")
+	data.WriteString(strings.TrimSuffix(canonicalHeaderRelaxed(unsigned), "\r\n"))
+	digest := sha256.Sum256([]byte(data.String()))
+
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		t.Fatalf("Invalid signature encoding: %v", err)
+	}
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+			t.Errorf("RSA signature does not verify: %v", err)
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, digest[:], sig) {
+			t.Error("Ed25519 signature does not verify")
+		}
+	}
+}
+
+func TestDKIMKeyParsingAndDNSRecord(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(priv)
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	key, err := ParseDKIMKey("Example.com", "s1", pemData)
+	if err != nil {
+		t.Fatalf("Failed to parse key: %v", err)
+	}
+	name, value, err := key.DNSRecord()
+	if err != nil {
+		t.Fatalf("Failed to build record: %v", err)
+	}
+	if name != "s1._domainkey.example.com" {
+		t.Errorf("Unexpected record name %s", name)
+	}
+	if value != "v=DKIM1; k=ed25519; p="+base64.StdEncoding.EncodeToString(pub) {
+		t.Errorf("Unexpected record value %s", value)
+	}
+
+	if !key.Covers("mail.example.com") || key.Covers("badexample.com") {
+		t.Error("Expected the key to cover only its domain and subdomains")
+	}
+	if _, err := ParseDKIMKey("example.com", "s1", []byte("not a key")); err == nil {
+		t.Error("Expected an error for an invalid key")
+	}
+
+	if parts := SplitTXTRecord(strings.Repeat("a", 300)); len(parts) != 2 || len(parts[0]) != 255 {
+		t.Errorf("Expected the value split into 255 byte strings, got %d parts", len(parts))
+	}
+}
+
+func TestEmailSenderSignsForChannel(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(priv)
+	pemData := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+
+	channels := func(tenantID, name string, notificationType models.NotificationType) *models.Channel {
+		return &models.Channel{Name: name, Type: notificationType, Config: models.JSON{
+			"dkim_domain": "example.com", "dkim_selector": "s1", "dkim_private_key": pemData,
+		}}
+	}
+	sender := NewEmailSender(&config.Config{EmailFromAddress: "noreply@example.com"}, nil, channels)
+	notification := &models.Notification{Title: "Hi", Recipient: "ada@example.net", Message: "Hello", Channel: "signed"}
+
+	for _, tt := range []struct {
+		from   string
+		signed bool
+	}{
+		{"noreply@example.com", true},
+		{"noreply@other.org", false},
+	} {
+		m, _ := sender.buildMessage(notification)
+		msg, err := sender.sign(m, notification.TenantID, notification.Channel, tt.from)
+		if err != nil {
+			t.Fatalf("Failed to sign: %v", err)
+		}
+		var buf bytes.Buffer
+		msg.WriteTo(&buf)
+		if got := strings.HasPrefix(buf.String(), "DKIM-Signature:"); got != tt.signed {
+			t.Errorf("From %s: expected signed=%v, got %v", tt.from, tt.signed, got)
+		}
+	}
+}
+
+func TestEmailSenderDKIMKeyFromEnvironment(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(priv)
+	path := filepath.Join(t.TempDir(), "dkim.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	sender := NewEmailSender(&config.Config{DKIMDomain: "example.com", DKIMSelector: "s1", DKIMPrivateKeyFile: path}, nil, nil)
+
+	if _, err := sender.DKIMKey(models.DefaultTenant, ""); err != nil {
+		t.Errorf("Expected the default tenant to use DKIM_PRIVATE_KEY_FILE, got %v", err)
+	}
+	if _, err := sender.DKIMKey("team-a", ""); !errors.Is(err, ErrDKIMNotConfigured) {
+		t.Errorf("Expected other tenants not to sign with the environment's key, got %v", err)
+	}
+}
+
+func TestEmailSenderIgnoresChannelDKIMKeyFiles(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(priv)
+	path := filepath.Join(t.TempDir(), "dkim.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	// A channel stored before dkim_private_key_file was removed still has the setting
+	channels := func(tenantID, name string, notificationType models.NotificationType) *models.Channel {
+		return &models.Channel{TenantID: tenantID, Name: name, Type: notificationType, Config: models.JSON{
+			"dkim_domain": "example.com", "dkim_selector": "s1", "dkim_private_key_file": path,
+		}}
+	}
+	sender := NewEmailSender(&config.Config{}, nil, channels)
+	if _, err := sender.DKIMKey("team-a", "bulk_email"); !errors.Is(err, ErrDKIMNotConfigured) {
+		t.Errorf("Expected a channel's key file not to be read, got %v", err)
+	}
+}
@@ -0,0 +1,404 @@
+package services
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// prepareEmailHTML sanitizes an HTML email body and inlines the rules of its
+// <style> elements into style attributes, since many mail clients ignore them
+func prepareEmailHTML(body string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+
+	sanitizeHTML(doc)
+	inlineCSS(doc)
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// disallowedElements are removed from email HTML together with their content
+var disallowedElements = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Iframe:   true,
+	atom.Frame:    true,
+	atom.Frameset: true,
+	atom.Object:   true,
+	atom.Embed:    true,
+	atom.Applet:   true,
+	atom.Form:     true,
+	atom.Input:    true,
+	atom.Button:   true,
+	atom.Textarea: true,
+	atom.Select:   true,
+	atom.Link:     true,
+	atom.Base:     true,
+	atom.Meta:     true,
+	atom.Noscript: true,
+}
+
+// urlAttributes hold URLs and are checked against allowedURLSchemes
+var urlAttributes = map[string]bool{
+	"href":       true,
+	"src":        true,
+	"action":     true,
+	"background": true,
+	"poster":     true,
+	"cite":       true,
+	"longdesc":   true,
+}
+
+var allowedURLSchemes = []string{"http:", "https:", "mailto:", "tel:", "cid:", "data:image/"}
+
+// sanitizeHTML removes scripting and active content from an HTML tree: disallowed
+// elements, event handler attributes and URLs with unsafe schemes
+func sanitizeHTML(n *html.Node) {
+	for child := n.FirstChild; child != nil; {
+		next := child.NextSibling
+		if child.Type == html.ElementNode && disallowedElements[child.DataAtom] {
+			n.RemoveChild(child)
+		} else if child.Type == html.ElementNode && child.DataAtom == atom.Style && child.FirstChild != nil && unsafeCSS.MatchString(child.FirstChild.Data) {
+			n.RemoveChild(child)
+		} else if child.Type == html.CommentNode {
+			n.RemoveChild(child)
+		} else {
+			sanitizeHTML(child)
+		}
+		child = next
+	}
+
+	if n.Type != html.ElementNode {
+		return
+	}
+
+	attrs := n.Attr[:0]
+	for _, attr := range n.Attr {
+		key := strings.ToLower(attr.Key)
+		if strings.HasPrefix(key, "on") || key == "srcdoc" || key == "formaction" {
+			continue
+		}
+		if urlAttributes[key] && !isSafeURL(attr.Val) {
+			continue
+		}
+		if key == "style" && unsafeCSS.MatchString(attr.Val) {
+			continue
+		}
+		attrs = append(attrs, attr)
+	}
+	n.Attr = attrs
+}
+
+// unsafeCSS matches CSS that can run script or load content in old mail clients
+var unsafeCSS = regexp.MustCompile(`(?i)expression\s*\(|javascript:|behavior\s*:|-moz-binding`)
+
+// isSafeURL reports whether a URL is relative or uses an allowed scheme
+func isSafeURL(value string) bool {
+	value = strings.ToLower(strings.TrimSpace(value))
+	colon := strings.IndexByte(value, ':')
+	if colon < 0 || strings.ContainsAny(value[:colon], "/?#") {
+		return true
+	}
+	for _, scheme := range allowedURLSchemes {
+		if strings.HasPrefix(value, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// cssRule is a single selector and its declarations from a <style> element
+type cssRule struct {
+	selector     cssSelector
+	declarations string
+	specificity  int
+	order        int
+}
+
+// cssSelector is a compound selector such as p, .button, #header or td.cell
+type cssSelector struct {
+	tag     string
+	id      string
+	classes []string
+}
+
+var (
+	cssComments   = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	cssSimpleName = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]*|^\*`)
+	cssQualifier  = regexp.MustCompile(`^[.#][a-zA-Z_-][a-zA-Z0-9_-]*`)
+)
+
+// inlineCSS copies the declarations of every supported <style> rule into the
+// style attribute of matching elements. Existing inline styles keep precedence.
+// Rules using combinators, pseudo-classes or at-rules are left in the stylesheet.
+func inlineCSS(doc *html.Node) {
+	var rules []cssRule
+	walkHTML(doc, func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.Style && n.FirstChild != nil {
+			rules = append(rules, parseCSSRules(n.FirstChild.Data, len(rules))...)
+		}
+	})
+	if len(rules) == 0 {
+		return
+	}
+
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].specificity != rules[j].specificity {
+			return rules[i].specificity < rules[j].specificity
+		}
+		return rules[i].order < rules[j].order
+	})
+
+	walkHTML(doc, func(n *html.Node) {
+		if n.Type != html.ElementNode || n.DataAtom == atom.Style {
+			return
+		}
+		var declarations []string
+		for _, rule := range rules {
+			if rule.selector.matches(n) {
+				declarations = append(declarations, rule.declarations)
+			}
+		}
+		if len(declarations) == 0 {
+			return
+		}
+		for i, attr := range n.Attr {
+			if attr.Key == "style" {
+				n.Attr[i].Val = strings.Join(append(declarations, strings.TrimSuffix(strings.TrimSpace(attr.Val), ";")), "; ")
+				return
+			}
+		}
+		n.Attr = append(n.Attr, html.Attribute{Key: "style", Val: strings.Join(declarations, "; ")})
+	})
+}
+
+// parseCSSRules extracts the rules with supported selectors from a stylesheet
+func parseCSSRules(stylesheet string, order int) []cssRule {
+	stylesheet = cssComments.ReplaceAllString(stylesheet, "")
+
+	var rules []cssRule
+	for len(stylesheet) > 0 {
+		open := strings.IndexByte(stylesheet, '{')
+		if open < 0 {
+			break
+		}
+		prelude := strings.TrimSpace(stylesheet[:open])
+
+		// Find the matching close brace, skipping nested blocks such as @media
+		depth, end := 0, -1
+		for i := open; i < len(stylesheet); i++ {
+			if stylesheet[i] == '{' {
+				depth++
+			} else if stylesheet[i] == '}' {
+				depth--
+				if depth == 0 {
+					end = i
+					break
+				}
+			}
+		}
+		if end < 0 {
+			break
+		}
+		body := strings.TrimSpace(stylesheet[open+1 : end])
+		stylesheet = stylesheet[end+1:]
+
+		if strings.HasPrefix(prelude, "@") || body == "" {
+			continue
+		}
+		declarations := strings.TrimSuffix(body, ";")
+		for _, raw := range strings.Split(prelude, ",") {
+			selector, specificity, ok := parseCSSSelector(strings.TrimSpace(raw))
+			if !ok {
+				continue
+			}
+			rules = append(rules, cssRule{
+				selector:     selector,
+				declarations: declarations,
+				specificity:  specificity,
+				order:        order + len(rules),
+			})
+		}
+	}
+	return rules
+}
+
+// parseCSSSelector parses a compound selector, reporting false for anything else
+func parseCSSSelector(raw string) (cssSelector, int, bool) {
+	var selector cssSelector
+	specificity := 0
+
+	if name := cssSimpleName.FindString(raw); name != "" {
+		if name != "*" {
+			selector.tag = strings.ToLower(name)
+			specificity++
+		}
+		raw = raw[len(name):]
+	}
+	for raw != "" {
+		part := cssQualifier.FindString(raw)
+		if part == "" {
+			return cssSelector{}, 0, false
+		}
+		if part[0] == '#' {
+			selector.id = part[1:]
+			specificity += 100
+		} else {
+			selector.classes = append(selector.classes, part[1:])
+			specificity += 10
+		}
+		raw = raw[len(part):]
+	}
+
+	if selector.tag == "" && selector.id == "" && len(selector.classes) == 0 {
+		return cssSelector{}, 0, false
+	}
+	return selector, specificity, true
+}
+
+// matches reports whether an element matches the selector
+func (s cssSelector) matches(n *html.Node) bool {
+	if s.tag != "" && n.Data != s.tag {
+		return false
+	}
+	if s.id != "" && htmlAttr(n, "id") != s.id {
+		return false
+	}
+	if len(s.classes) > 0 {
+		classes := strings.Fields(htmlAttr(n, "class"))
+		for _, want := range s.classes {
+			found := false
+			for _, class := range classes {
+				if class == want {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// htmlToText converts an HTML email body into a readable plain-text alternative
+func htmlToText(body string) string {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return ""
+	}
+
+	w := &htmlTextWriter{}
+	w.node(doc)
+
+	lines := strings.Split(w.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	text := strings.Join(lines, "\n")
+	text = blankLines.ReplaceAllString(text, "\n\n")
+	return strings.TrimSpace(text)
+}
+
+var (
+	blankLines = regexp.MustCompile(`\n{3,}`)
+	whitespace = regexp.MustCompile(`\s+`)
+)
+
+// htmlBlockElements start on a new line in the text alternative
+var htmlBlockElements = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Table: true, atom.Tr: true, atom.Ul: true,
+	atom.Ol: true, atom.Blockquote: true, atom.Section: true, atom.Article: true,
+	atom.Header: true, atom.Footer: true, atom.H1: true, atom.H2: true, atom.H3: true,
+	atom.H4: true, atom.H5: true, atom.H6: true, atom.Pre: true,
+}
+
+// htmlTextWriter accumulates the text of an HTML tree
+type htmlTextWriter struct {
+	strings.Builder
+}
+
+func (w *htmlTextWriter) node(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		w.WriteString(whitespace.ReplaceAllString(n.Data, " "))
+		return
+	case html.ElementNode:
+		switch n.DataAtom {
+		case atom.Head, atom.Style, atom.Script, atom.Title:
+			return
+		case atom.Br:
+			w.WriteString("\n")
+			return
+		case atom.Hr:
+			w.WriteString("\n---\n")
+			return
+		case atom.Img:
+			w.WriteString(htmlAttr(n, "alt"))
+			return
+		case atom.Li:
+			w.WriteString("\n- ")
+		case atom.Td, atom.Th:
+			w.WriteString(" ")
+		case atom.A:
+			w.link(n)
+			return
+		}
+		if htmlBlockElements[n.DataAtom] {
+			w.WriteString("\n\n")
+			defer w.WriteString("\n\n")
+		}
+	}
+
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		w.node(child)
+	}
+}
+
+// link writes a link's text followed by its URL when the two differ
+func (w *htmlTextWriter) link(n *html.Node) {
+	inner := &htmlTextWriter{}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		inner.node(child)
+	}
+	label := strings.TrimSpace(inner.String())
+	href := htmlAttr(n, "href")
+
+	switch {
+	case href == "" || strings.HasPrefix(href, "#") || href == label || strings.TrimPrefix(href, "mailto:") == label:
+		w.WriteString(label)
+	case label == "":
+		w.WriteString(href)
+	default:
+		w.WriteString(label + " (" + href + ")")
+	}
+}
+
+// htmlAttr returns the value of an element's attribute
+func htmlAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// walkHTML calls fn for n and every node below it in document order
+func walkHTML(n *html.Node, fn func(*html.Node)) {
+	fn(n)
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		walkHTML(child, fn)
+	}
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+)
+
+func TestPrepareEmailHTMLSanitizes(t *testing.T) {
+	body := `<p onclick="steal()">Hi</p><script>alert(1)</script>` +
+		`<a href="javascript:alert(1)">bad</a><a href="https://example.com">good</a>` +
+		`<img src="cid:logo" style="width: expression(alert(1))"><iframe src="https://example.com"></iframe>`
+
+	result, err := prepareEmailHTML(body)
+	if err != nil {
+		t.Fatalf("Failed to prepare HTML: %v", err)
+	}
+
+	for _, unwanted := range []string{"onclick", "<script", "javascript:", "expression", "<iframe"} {
+		if strings.Contains(result, unwanted) {
+			t.Errorf("Expected %q to be removed, got %s", unwanted, result)
+		}
+	}
+	for _, wanted := range []string{`href="https://example.com"`, `src="cid:logo"`, "<p>Hi</p>"} {
+		if !strings.Contains(result, wanted) {
+			t.Errorf("Expected %q to be kept, got %s", wanted, result)
+		}
+	}
+}
+
+func TestPrepareEmailHTMLInlinesCSS(t *testing.T) {
+	body := `<html><head><style>
+		p { color: black; margin: 0 }
+		.lead { color: blue; }
+		#intro.lead { font-weight: bold }
+		a:hover { color: red }
+	</style></head><body>
+		<p id="intro" class="lead" style="margin: 4px">Hello</p>
+		<p>Plain</p>
+		<a href="https://example.com">Link</a>
+	</body></html>`
+
+	result, err := prepareEmailHTML(body)
+	if err != nil {
+		t.Fatalf("Failed to prepare HTML: %v", err)
+	}
+
+	expected := []string{
+		`<p id="intro" class="lead" style="color: black; margin: 0; color: blue; font-weight: bold; margin: 4px">Hello</p>`,
+		`<p style="color: black; margin: 0">Plain</p>`,
+		`<a href="https://example.com">Link</a>`,
+	}
+	for _, want := range expected {
+		if !strings.Contains(result, want) {
+			t.Errorf("Expected %s in:\n%s", want, result)
+		}
+	}
+}
+
+func TestHTMLToText(t *testing.T) {
+	body := `<html><head><title>Ignored</title><style>p { color: red }</style></head><body>
+		<h1>Welcome</h1>
+		<p>Hello <b>Ada</b>,<br>your   order has shipped.</p>
+		<ul><li>One</li><li>Two</li></ul>
+		<p><a href="https://example.com/track">Track it</a> or mail <a href="mailto:help@example.com">help@example.com</a></p>
+	</body></html>`
+
+	expected := "Welcome\n\n" +
+		"Hello Ada,\nyour order has shipped.\n\n" +
+		"- One\n- Two\n\n" +
+		"Track it (https://example.com/track) or mail help@example.com"
+
+	if result := htmlToText(body); result != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, result)
+	}
+}
+
+func TestEmailSenderBuildMessage(t *testing.T) {
+	sender := NewEmailSender(&config.Config{EmailUsername: "noreply@example.com"}, nil, nil)
+
+	tests := []struct {
+		name         string
+		notification models.Notification
+		contains     []string
+		excludes     []string
+	}{
+		{
+			name:         "Plain text only",
+			notification: models.Notification{Title: "Hi", Recipient: "ada@example.com", Message: "Just text"},
+			contains:     []string{"Content-Type: text/plain", "Just text"},
+			excludes:     []string{"multipart/alternative", "text/html"},
+		},
+		{
+			name: "Text and HTML",
+			notification: models.Notification{
+				Title: "Hi", Recipient: "ada@example.com",
+				Message: "Text version", HTMLMessage: "<p>HTML version</p>",
+			},
+			contains: []string{"multipart/alternative", "Content-Type: text/plain", "Text version", "Content-Type: text/html", "<p>HTML version</p>"},
+		},
+		{
+			name: "Text generated from HTML",
+			notification: models.Notification{
+				Title: "Hi", Recipient: "ada@example.com",
+				HTMLMessage: `<p>Generated <a href="https://example.com">here</a></p>`,
+			},
+			contains: []string{"multipart/alternative", "Generated here (https://example.com)"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := sender.buildMessage(&tt.notification)
+			if err != nil {
+				t.Fatalf("Failed to build message: %v", err)
+			}
+
+			var buf bytes.Buffer
+			if _, err := m.WriteTo(&buf); err != nil {
+				t.Fatalf("Failed to write message: %v", err)
+			}
+			raw := buf.String()
+
+			for _, want := range tt.contains {
+				if !strings.Contains(raw, want) {
+					t.Errorf("Expected %q in message:\n%s", want, raw)
+				}
+			}
+			for _, unwanted := range tt.excludes {
+				if strings.Contains(raw, unwanted) {
+					t.Errorf("Did not expect %q in message:\n%s", unwanted, raw)
+				}
+			}
+		})
+	}
+}
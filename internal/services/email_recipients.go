@@ -0,0 +1,145 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"notification-service/internal/models"
+)
+
+// ErrInvalidRecipient is returned when a notification's addresses cannot be used
+var ErrInvalidRecipient = errors.New("invalid recipient")
+
+// buildDeliveries creates one pending delivery for every unique address of an email
+// request. The recipient field is treated as the first To address; an address
+// listed more than once is only sent to under the first header it appears in.
+func buildDeliveries(req *models.NotificationRequest) ([]models.Delivery, error) {
+	if req.Type != models.EmailNotification {
+		if len(req.To) > 0 || len(req.CC) > 0 || len(req.BCC) > 0 || req.ReplyTo != "" {
+			return nil, fmt.Errorf("%w: to, cc, bcc and reply_to are only supported for email", ErrInvalidRecipient)
+		}
+		return nil, nil
+	}
+
+	var deliveries []models.Delivery
+	seen := make(map[string]bool)
+	add := func(kind models.DeliveryKind, raw string) error {
+		address, err := normalizeAddress(raw)
+		if err != nil {
+			return err
+		}
+		if seen[strings.ToLower(address)] {
+			return nil
+		}
+		seen[strings.ToLower(address)] = true
+		deliveries = append(deliveries, models.Delivery{
+			Address: address,
+			Kind:    kind,
+			Status:  models.DeliveryPending,
+		})
+		return nil
+	}
+
+	to := req.To
+	if req.Recipient != "" {
+		to = append([]string{req.Recipient}, to...)
+	}
+	lists := []struct {
+		kind      models.DeliveryKind
+		addresses []string
+	}{
+		{models.ToDelivery, to},
+		{models.CCDelivery, req.CC},
+		{models.BCCDelivery, req.BCC},
+	}
+	for _, list := range lists {
+		for _, raw := range list.addresses {
+			if err := add(list.kind, raw); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(deliveries) == 0 || deliveries[0].Kind != models.ToDelivery {
+		return nil, fmt.Errorf("%w: at least one recipient or to address is required", ErrInvalidRecipient)
+	}
+	return deliveries, nil
+}
+
+// primaryRecipient returns the address a notification is stored under: the request's
+// recipient, or its first To address for email sent to a list
+func primaryRecipient(req *models.NotificationRequest, deliveries []models.Delivery) string {
+	if len(deliveries) > 0 {
+		return deliveries[0].Address
+	}
+	return req.Recipient
+}
+
+// applyEmailHeaders sets the From and Reply-To headers of an email notification
+func (s *NotificationService) applyEmailHeaders(notification *models.Notification, req *models.NotificationRequest) error {
+	if notification.Type != models.EmailNotification {
+		return nil
+	}
+
+	notification.From = s.emailFrom(req.TenantID, req.Channel)
+	if req.ReplyTo != "" {
+		replyTo, err := normalizeAddress(req.ReplyTo)
+		if err != nil {
+			return err
+		}
+		notification.ReplyTo = replyTo
+	}
+	return nil
+}
+
+// normalizeAddress parses an address such as "Ada <ada@example.com>" and returns
+// the bare address
+func normalizeAddress(raw string) (string, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(raw))
+	if err != nil {
+		return "", fmt.Errorf("%w: %q is not a valid email address", ErrInvalidRecipient, raw)
+	}
+	return addr.Address, nil
+}
+
+// emailFrom returns the From header for email sent through a tenant's channel, taken
+// from the channel's from_address and from_name settings and, for the default tenant
+// only, falling back to EMAIL_FROM_ADDRESS and EMAIL_FROM_NAME
+func (s *NotificationService) emailFrom(tenantID, channelName string) string {
+	var address, name string
+	if tenantID == models.DefaultTenant {
+		address, name = s.config.EmailFromAddress, s.config.EmailFromName
+		if address == "" {
+			address = s.config.EmailUsername
+		}
+	}
+
+	if channelName != "" {
+		if channel := s.findChannel(tenantID, channelName, models.EmailNotification); channel != nil {
+			if configured, ok := channel.Config["from_address"].(string); ok && configured != "" {
+				address = configured
+			}
+			if configured, ok := channel.Config["from_name"].(string); ok && configured != "" {
+				name = configured
+			}
+		}
+	}
+
+	if address == "" {
+		return ""
+	}
+	return (&mail.Address{Name: name, Address: address}).String()
+}
+
+// deliveryAddresses returns the addresses of the deliveries of the given kind
+func deliveryAddresses(deliveries []models.Delivery, kind models.DeliveryKind) []string {
+	var addresses []string
+	for _, delivery := range deliveries {
+		if delivery.Kind == kind {
+			addresses = append(addresses, delivery.Address)
+		}
+	}
+	return addresses
+}
@@ -2,70 +2,346 @@ package services
 
 import (
 	"fmt"
+	"io"
+	"net/mail"
 	"notification-service/internal/config"
 	"notification-service/internal/models"
+	"strconv"
+	"strings"
+	"sync"
 
 	"gopkg.in/gomail.v2"
 )
 
+// channelLookup finds a tenant's configured channel by name and type, returning nil when
+// there is none
+type channelLookup func(tenantID, name string, notificationType models.NotificationType) *models.Channel
+
 // EmailSender handles email notifications
 type EmailSender struct {
-	config *config.Config
+	config   *config.Config
+	blobs    *BlobStore
+	channels channelLookup
+
+	mu       sync.Mutex
+	pools    map[string]*smtpPool
+	dkimKeys map[string]*DKIMKey
 }
 
-// NewEmailSender creates a new email sender
-func NewEmailSender(config *config.Config) *EmailSender {
+// NewEmailSender creates a new email sender that reads attachments from blobs and
+// per-channel settings through channels, which may be nil
+func NewEmailSender(config *config.Config, blobs *BlobStore, channels channelLookup) *EmailSender {
 	return &EmailSender{
-		config: config,
+		config:   config,
+		blobs:    blobs,
+		channels: channels,
+		pools:    make(map[string]*smtpPool),
+		dkimKeys: make(map[string]*DKIMKey),
 	}
 }
 
-// Send sends an email notification
+// Send sends an email notification, recording on each of its deliveries whether
+// the server accepted that address. It only fails when no address was accepted.
 func (e *EmailSender) Send(notification *models.Notification) error {
-	// Create email message
-	m := gomail.NewMessage()
-	m.SetHeader("From", e.config.EmailUsername)
-	m.SetHeader("To", notification.Recipient)
-	m.SetHeader("Subject", notification.Title)
-	m.SetBody("text/plain", notification.Message)
+	if len(notification.Deliveries) == 0 {
+		notification.Deliveries = []models.Delivery{{Address: notification.Recipient, Kind: models.ToDelivery}}
+	}
 
-	// Add HTML body if metadata contains HTML content
-	if htmlContent, exists := notification.Metadata["html_content"]; exists {
-		if htmlStr, ok := htmlContent.(string); ok {
-			m.SetBody("text/html", htmlStr)
+	header := e.fromHeader(notification)
+	if header == "" {
+		return fmt.Errorf("%w: channel %q sets no from_address", ErrTenantChannelRequired, notification.Channel)
+	}
+	m, err := e.buildMessage(notification)
+	if err != nil {
+		return fmt.Errorf("failed to build email: %w", err)
+	}
+	from, err := mail.ParseAddress(header)
+	if err != nil {
+		return fmt.Errorf("invalid From address: %w", err)
+	}
+
+	// Suppressed addresses stay in the headers but are left out of the envelope
+	var recipients []string
+	for _, delivery := range notification.Deliveries {
+		if delivery.Status != models.DeliverySuppressed {
+			recipients = append(recipients, delivery.Address)
 		}
 	}
 
-	// Create dialer
-	d := gomail.NewDialer(
-		e.config.EmailHost,
-		e.config.EmailPort,
-		e.config.EmailUsername,
-		e.config.EmailPassword,
-	)
+	msg, err := e.sign(m, notification.TenantID, notification.Channel, from.Address)
+	if err != nil {
+		return err
+	}
+	pool, err := e.poolFor(notification.TenantID, notification.Channel)
+	if err != nil {
+		return err
+	}
 
-	// Send email
-	if err := d.DialAndSend(m); err != nil {
+	rejected, err := pool.send(from.Address, recipients, msg)
+	recordDeliveries(notification.Deliveries, rejected, err)
+	if err != nil {
 		return fmt.Errorf("failed to send email: %w", err)
 	}
 
 	return nil
 }
 
-// TestConnection tests the email connection
+// poolFor returns the connection pool for a tenant's channel, sized by the channel's
+// smtp_pool_size setting or SMTP_POOL_SIZE. A pool is replaced when its channel's
+// size or server settings change.
+func (e *EmailSender) poolFor(tenantID, channelName string) (*smtpPool, error) {
+	key, size := "", e.config.SMTPPoolSize
+	channel := e.channel(tenantID, channelName)
+	if channel != nil {
+		key = channelKey(tenantID, channel.Name)
+		if configured, ok := configInt(channel.Config, "smtp_pool_size"); ok && configured > 0 {
+			size = configured
+		}
+	}
+	dialer, err := e.dialer(tenantID, channel)
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if pool := e.pools[key]; pool != nil {
+		if pool.size == size && *pool.dialer == *dialer {
+			return pool, nil
+		}
+		pool.close()
+	}
+	pool := newSMTPPool(dialer, size, e.config.SMTPMaxMessagesPerConn, e.config.SMTPIdleTimeout)
+	e.pools[key] = pool
+	return pool, nil
+}
+
+// closePool closes the connections kept open for a tenant's channel
+func (e *EmailSender) closePool(tenantID, channelName string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	key := channelKey(tenantID, channelName)
+	if pool := e.pools[key]; pool != nil {
+		pool.close()
+		delete(e.pools, key)
+	}
+}
+
+// channel returns a tenant's email channel with the given name, or nil when there is none
+func (e *EmailSender) channel(tenantID, name string) *models.Channel {
+	if e.channels == nil || name == "" {
+		return nil
+	}
+	return e.channels(tenantID, name, models.EmailNotification)
+}
+
+// configString reads a string setting from a channel config
+func configString(config models.JSON, key string) string {
+	value, _ := config[key].(string)
+	return value
+}
+
+// configInt reads an integer setting from a channel config, where JSON numbers
+// decode as float64
+func configInt(config models.JSON, key string) (int, bool) {
+	switch value := config[key].(type) {
+	case float64:
+		return int(value), true
+	case int:
+		return value, true
+	case string:
+		n, err := strconv.Atoi(value)
+		return n, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// recordDeliveries sets the status of each delivery from the addresses the server
+// rejected, or from err when the message was not sent at all. Suppressed deliveries
+// are left as they are.
+func recordDeliveries(deliveries []models.Delivery, rejected map[string]error, err error) {
+	for i := range deliveries {
+		if deliveries[i].Status == models.DeliverySuppressed {
+			continue
+		}
+		reason := rejected[deliveries[i].Address]
+		if reason == nil {
+			reason = err
+		}
+		if reason != nil {
+			deliveries[i].Status = models.DeliveryFailed
+			deliveries[i].Error = reason.Error()
+		} else {
+			deliveries[i].Status = models.DeliverySent
+			deliveries[i].Error = ""
+		}
+	}
+}
+
+// defaultSMTPPort is the port of a channel that sets smtp_host but no smtp_port
+const defaultSMTPPort = 587
+
+// dialer returns the SMTP dialer for a tenant's channel. The default tenant's
+// channels use the environment's server, with any smtp_host, smtp_port, smtp_username
+// and smtp_password the channel sets in its place; other tenants' channels only use
+// their own settings.
+func (e *EmailSender) dialer(tenantID string, channel *models.Channel) (*smtpDialer, error) {
+	d := &smtpDialer{port: defaultSMTPPort}
+	if tenantID == models.DefaultTenant {
+		d = &smtpDialer{
+			host:     e.config.EmailHost,
+			port:     e.config.EmailPort,
+			username: e.config.EmailUsername,
+			password: e.config.EmailPassword,
+		}
+	} else if channel == nil {
+		return nil, fmt.Errorf("%w: no email channel", ErrTenantChannelRequired)
+	} else if configString(channel.Config, "smtp_host") == "" {
+		return nil, fmt.Errorf("%w: channel %s sets no smtp_host", ErrTenantChannelRequired, channel.Name)
+	}
+	if channel == nil {
+		return d, nil
+	}
+	if host := configString(channel.Config, "smtp_host"); host != "" {
+		d.host = host
+	}
+	if port, ok := configInt(channel.Config, "smtp_port"); ok && port > 0 {
+		d.port = port
+	}
+	if username := configString(channel.Config, "smtp_username"); username != "" {
+		d.username = username
+	}
+	if password := configString(channel.Config, "smtp_password"); password != "" {
+		d.password = password
+	}
+	return d, nil
+}
+
+// fromHeader returns the From header of a notification, defaulting to the configured
+// sender for the default tenant. It is empty when another tenant's channel sets no
+// from_address.
+func (e *EmailSender) fromHeader(notification *models.Notification) string {
+	if notification.From != "" || notification.TenantID != models.DefaultTenant {
+		return notification.From
+	}
+	address := e.config.EmailFromAddress
+	if address == "" {
+		address = e.config.EmailUsername
+	}
+	return (&mail.Address{Name: e.config.EmailFromName, Address: address}).String()
+}
+
+// buildMessage composes the email for a notification. When the notification has an
+// HTML body it is sanitized, has its CSS inlined and is sent as a multipart/alternative
+// part next to the plain text, which is generated from the HTML if not supplied.
+func (e *EmailSender) buildMessage(notification *models.Notification) (*gomail.Message, error) {
+	m := gomail.NewMessage()
+	from := e.fromHeader(notification)
+	m.SetHeader("From", from)
+	m.SetHeader("Subject", notification.Title)
+	m.SetHeader("Message-ID", newMessageID(from))
+
+	// BCC addresses only appear in the SMTP envelope
+	to := deliveryAddresses(notification.Deliveries, models.ToDelivery)
+	if len(to) == 0 {
+		to = []string{notification.Recipient}
+	}
+	m.SetHeader("To", to...)
+	if cc := deliveryAddresses(notification.Deliveries, models.CCDelivery); len(cc) > 0 {
+		m.SetHeader("Cc", cc...)
+	}
+	if notification.ReplyTo != "" {
+		m.SetHeader("Reply-To", notification.ReplyTo)
+	}
+
+	// Email in a category can be unsubscribed from in one click (RFC 8058), unless it
+	// goes to several addresses and no single one can be unsubscribed
+	if notification.Category != "" && len(notification.Deliveries) <= 1 {
+		headers, err := unsubscribeHeaders(e.config, notification)
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range headers {
+			m.SetHeader(name, value)
+		}
+	}
+
+	for _, attachment := range notification.Attachments {
+		settings := []gomail.FileSetting{
+			gomail.SetHeader(map[string][]string{"Content-Type": {attachment.ContentType}}),
+			gomail.SetCopyFunc(e.copyBlob(attachment.BlobID)),
+		}
+		if attachment.Inline {
+			settings = append(settings, gomail.SetHeader(map[string][]string{"Content-ID": {"<" + attachment.ContentID + ">"}}))
+			m.Embed(attachment.Filename, settings...)
+		} else {
+			m.Attach(attachment.Filename, settings...)
+		}
+	}
+
+	if notification.HTMLMessage == "" {
+		m.SetBody("text/plain", notification.Message)
+		return m, nil
+	}
+
+	htmlBody, err := prepareEmailHTML(notification.HTMLMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	textBody := notification.Message
+	if strings.TrimSpace(textBody) == "" {
+		textBody = htmlToText(htmlBody)
+	}
+
+	htmlBody, err = e.trackedHTML(notification, htmlBody)
+	if err != nil {
+		return nil, err
+	}
+
+	m.SetBody("text/plain", textBody)
+	m.AddAlternative("text/html", htmlBody)
+
+	return m, nil
+}
+
+// copyBlob returns a function that writes a stored attachment into the message
+func (e *EmailSender) copyBlob(id string) func(io.Writer) error {
+	return func(w io.Writer) error {
+		r, err := e.blobs.Open(id)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		_, err = io.Copy(w, r)
+		return err
+	}
+}
+
+// ContentFormat reports that email messages are plain text; HTML travels as a separate part
+func (e *EmailSender) ContentFormat() ContentFormat {
+	return TextFormat
+}
+
+// TestConnection tests the connection to the SMTP server configured in the environment
 func (e *EmailSender) TestConnection() error {
-	d := gomail.NewDialer(
-		e.config.EmailHost,
-		e.config.EmailPort,
-		e.config.EmailUsername,
-		e.config.EmailPassword,
-	)
-
-	s, err := d.Dial()
+	return e.testConnection(models.DefaultTenant, "")
+}
+
+// testConnection tests the connection to the SMTP server of a tenant's named channel
+func (e *EmailSender) testConnection(tenantID, channelName string) error {
+	dialer, err := e.dialer(tenantID, e.channel(tenantID, channelName))
+	if err != nil {
+		return err
+	}
+	c, err := dialer.dial()
 	if err != nil {
 		return fmt.Errorf("failed to connect to SMTP server: %w", err)
 	}
-	defer s.Close()
+	defer c.Close()
 
-	return nil
-} 
\ No newline at end of file
+	return c.Quit()
+}
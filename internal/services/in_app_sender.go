@@ -30,6 +30,11 @@ func (i *InAppSender) Send(notification *models.Notification) error {
 	return nil
 }
 
+// ContentFormat reports that in-app messages are sanitized HTML
+func (i *InAppSender) ContentFormat() ContentFormat {
+	return SanitizedHTMLFormat
+}
+
 // TestConnection tests the in-app notification system
 func (i *InAppSender) TestConnection() error {
 	// In a real implementation, this would test:
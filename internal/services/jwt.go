@@ -0,0 +1,340 @@
+package services
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+)
+
+// ErrInvalidJWT is returned when a bearer token is malformed, badly signed, expired
+// or not meant for this service
+var ErrInvalidJWT = errors.New("invalid bearer token")
+
+// Identity is the authenticated caller of an API request
+type Identity struct {
+	Subject string `json:"subject"`
+	Issuer  string `json:"issuer,omitempty"`
+	// TenantID is the tenant the caller acts for; every record it reads or writes belongs to it
+	TenantID string `json:"tenant_id"`
+	// Scopes lists what the caller may do; a caller without scopes can do nothing
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// Can reports whether the caller has been granted a scope
+func (i *Identity) Can(scope string) bool {
+	for _, granted := range i.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// minJWTSecretBytes is the shortest JWT_SECRET accepted for HS256, the size of its hash
+const minJWTSecretBytes = 32
+
+// placeholderJWTSecrets are the example secrets shipped with the service and its docs.
+// They are public, so tokens signed with them could grant anything.
+var placeholderJWTSecrets = map[string]bool{
+	"your-secret-key":     true,
+	"your-jwt-secret-key": true,
+	"your-super-secret-jwt-key-change-this-in-production": true,
+}
+
+// validateJWTSecret rejects placeholder secrets and secrets too short for HS256
+func validateJWTSecret(secret string) error {
+	if placeholderJWTSecrets[secret] {
+		return errors.New("JWT_SECRET is a published placeholder; generate one with `openssl rand -base64 32`")
+	}
+	if len(secret) < minJWTSecretBytes {
+		return fmt.Errorf("JWT_SECRET must be at least %d bytes", minJWTSecretBytes)
+	}
+	return nil
+}
+
+// JWTVerifier checks bearer tokens signed with HS256 using JWT_SECRET, or with RS256
+// using a key from the JWKS file at JWT_JWKS_FILE
+type JWTVerifier struct {
+	secret        []byte
+	rsaKeys       map[string]*rsa.PublicKey
+	audience      string
+	issuer        string
+	leeway        time.Duration
+	tenantClaim   string
+	requireTenant bool
+	defaultScopes []string
+	now           func() time.Time
+}
+
+// jwtHeader is the JOSE header of a token
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// jwtClaims are the registered claims a token is validated against. Audience may be
+// a string or an array of strings.
+type jwtClaims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *int64          `json:"exp"`
+	NotBefore *int64          `json:"nbf"`
+	// Scope is a space-separated list of scopes, as in RFC 8693
+	Scope *string `json:"scope"`
+}
+
+// NewJWTVerifier creates a verifier from the JWT settings, loading the JWKS file when
+// one is configured. JWT_SECRET, when set, must be a real secret of at least 32 bytes,
+// and one of JWT_SECRET and JWT_JWKS_FILE must be set.
+func NewJWTVerifier(cfg *config.Config) (*JWTVerifier, error) {
+	if cfg.JWTSecret != "" {
+		if err := validateJWTSecret(cfg.JWTSecret); err != nil {
+			return nil, err
+		}
+	} else if cfg.JWTJWKSFile == "" {
+		return nil, errors.New("JWT_SECRET or JWT_JWKS_FILE must be set")
+	}
+
+	v := &JWTVerifier{
+		secret:        []byte(cfg.JWTSecret),
+		audience:      cfg.JWTAudience,
+		issuer:        cfg.JWTIssuer,
+		leeway:        cfg.JWTLeeway,
+		tenantClaim:   cfg.JWTTenantClaim,
+		requireTenant: cfg.JWTRequireTenant,
+		defaultScopes: cfg.JWTDefaultScopes,
+		now:           time.Now,
+	}
+	if len(v.defaultScopes) > 0 {
+		if err := validateScopes(v.defaultScopes); err != nil {
+			return nil, fmt.Errorf("JWT_DEFAULT_SCOPES: %w", err)
+		}
+	}
+	if v.tenantClaim == "" {
+		v.tenantClaim = "tenant_id"
+	}
+	if cfg.JWTJWKSFile != "" {
+		keys, err := loadJWKS(cfg.JWTJWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		v.rsaKeys = keys
+	}
+	return v, nil
+}
+
+// jwk is an entry of a JSON Web Key Set. Only RSA keys are used.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// loadJWKS reads the RSA signing keys of a JWKS file, by key ID
+func loadJWKS(path string) (map[string]*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS file: %w", err)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS file: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, key := range set.Keys {
+		if key.Kty != "RSA" || (key.Use != "" && key.Use != "sig") || (key.Alg != "" && key.Alg != "RS256") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus for JWKS key %q", key.Kid)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid exponent for JWKS key %q", key.Kid)
+		}
+		keys[key.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS file has no RS256 signing keys")
+	}
+	return keys, nil
+}
+
+// Verify checks a token's signature and its exp, nbf, aud and iss claims, and returns
+// the identity of its subject. Tokens with a scope claim are limited to those scopes,
+// and the tenant claim names the tenant the caller acts for.
+func (v *JWTVerifier) Verify(token string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidJWT)
+	}
+	var header jwtHeader
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidJWT)
+	}
+	if err := v.verifySignature(header, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err := v.validateClaims(&claims); err != nil {
+		return nil, err
+	}
+	tenantID, err := v.tenant(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	// Tokens without a scope claim get JWT_DEFAULT_SCOPES, which is empty unless configured
+	identity := &Identity{Subject: claims.Subject, Issuer: claims.Issuer, TenantID: tenantID, Scopes: v.defaultScopes}
+	if claims.Scope != nil {
+		identity.Scopes = strings.Fields(*claims.Scope)
+	}
+	return identity, nil
+}
+
+// tenant returns the tenant named by a token's JWT_TENANT_CLAIM claim. Tokens without
+// one act for the default tenant unless JWT_REQUIRE_TENANT is set.
+func (v *JWTVerifier) tenant(payload string) (string, error) {
+	var claims map[string]interface{}
+	if err := decodeJWTSegment(payload, &claims); err != nil {
+		return "", err
+	}
+	value, ok := claims[v.tenantClaim]
+	if !ok || value == nil {
+		if v.requireTenant {
+			return "", fmt.Errorf("%w: missing %s claim", ErrInvalidJWT, v.tenantClaim)
+		}
+		return models.DefaultTenant, nil
+	}
+	tenantID, ok := value.(string)
+	if !ok || validateTenantID(tenantID) != nil {
+		return "", fmt.Errorf("%w: invalid %s claim", ErrInvalidJWT, v.tenantClaim)
+	}
+	return tenantID, nil
+}
+
+// decodeJWTSegment decodes a base64url encoded JSON segment of a token
+func decodeJWTSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: malformed token", ErrInvalidJWT)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: malformed token", ErrInvalidJWT)
+	}
+	return nil
+}
+
+// verifySignature checks the signature of a token's signing input with the key its
+// algorithm calls for. Only HS256 and RS256 are accepted.
+func (v *JWTVerifier) verifySignature(header jwtHeader, signingInput string, signature []byte) error {
+	switch header.Alg {
+	case "HS256":
+		if len(v.secret) == 0 {
+			return fmt.Errorf("%w: HS256 tokens are not accepted", ErrInvalidJWT)
+		}
+		mac := hmac.New(sha256.New, v.secret)
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return fmt.Errorf("%w: bad signature", ErrInvalidJWT)
+		}
+		return nil
+	case "RS256":
+		key := v.rsaKey(header.Kid)
+		if key == nil {
+			return fmt.Errorf("%w: unknown signing key %q", ErrInvalidJWT, header.Kid)
+		}
+		digest := sha256.Sum256([]byte(signingInput))
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return fmt.Errorf("%w: bad signature", ErrInvalidJWT)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidJWT, header.Alg)
+	}
+}
+
+// rsaKey returns the JWKS key with the given ID, or the only key when the token names
+// none
+func (v *JWTVerifier) rsaKey(kid string) *rsa.PublicKey {
+	if kid == "" && len(v.rsaKeys) == 1 {
+		for _, key := range v.rsaKeys {
+			return key
+		}
+	}
+	return v.rsaKeys[kid]
+}
+
+// validateClaims checks that a token names its subject, has not expired, is already
+// valid and, when JWT_AUDIENCE and JWT_ISSUER are set, was issued for this service by
+// the expected issuer
+func (v *JWTVerifier) validateClaims(claims *jwtClaims) error {
+	now := v.now()
+	if claims.Subject == "" {
+		return fmt.Errorf("%w: missing sub claim", ErrInvalidJWT)
+	}
+	if claims.ExpiresAt == nil {
+		return fmt.Errorf("%w: missing exp claim", ErrInvalidJWT)
+	}
+	if now.After(time.Unix(*claims.ExpiresAt, 0).Add(v.leeway)) {
+		return fmt.Errorf("%w: token has expired", ErrInvalidJWT)
+	}
+	if claims.NotBefore != nil && now.Add(v.leeway).Before(time.Unix(*claims.NotBefore, 0)) {
+		return fmt.Errorf("%w: token is not valid yet", ErrInvalidJWT)
+	}
+	if v.issuer != "" && claims.Issuer != v.issuer {
+		return fmt.Errorf("%w: unexpected issuer %q", ErrInvalidJWT, claims.Issuer)
+	}
+	if v.audience != "" && !audienceContains(claims.Audience, v.audience) {
+		return fmt.Errorf("%w: token is not meant for %s", ErrInvalidJWT, v.audience)
+	}
+	return nil
+}
+
+// audienceContains reports whether an aud claim, a string or an array of strings,
+// names the audience
+func audienceContains(aud json.RawMessage, audience string) bool {
+	var single string
+	if json.Unmarshal(aud, &single) == nil {
+		return single == audience
+	}
+	var list []string
+	if json.Unmarshal(aud, &list) == nil {
+		for _, a := range list {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,252 @@
+package services
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"notification-service/internal/config"
+)
+
+// testJWTSecret is an HS256 secret long enough to be accepted
+const testJWTSecret = "0123456789abcdef0123456789abcdef"
+
+// signTestJWT returns a token with the given header and claims, signed by sign
+func signTestJWT(t *testing.T, header, claims map[string]interface{}, sign func(input []byte) []byte) string {
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("Failed to encode: %v", err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	input := encode(header) + "." + encode(claims)
+	return input + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(input)))
+}
+
+// hs256 signs with HMAC-SHA256 and secret
+func hs256(secret string) func([]byte) []byte {
+	return func(input []byte) []byte {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(input)
+		return mac.Sum(nil)
+	}
+}
+
+// rs256 signs with RSASSA-PKCS1-v1_5 SHA-256 and key
+func rs256(t *testing.T, key *rsa.PrivateKey) func([]byte) []byte {
+	return func(input []byte) []byte {
+		digest := sha256.Sum256(input)
+		signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatalf("Failed to sign: %v", err)
+		}
+		return signature
+	}
+}
+
+func TestJWTVerifierHS256(t *testing.T) {
+	verifier, err := NewJWTVerifier(&config.Config{JWTSecret: testJWTSecret, JWTAudience: "notifications", JWTIssuer: "https://auth.example.com", JWTLeeway: time.Minute})
+	if err != nil {
+		t.Fatalf("Failed to create verifier: %v", err)
+	}
+	header := map[string]interface{}{"alg": "HS256", "typ": "JWT"}
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"sub": "svc-billing",
+			"iss": "https://auth.example.com",
+			"aud": []string{"notifications", "other"},
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+	}
+
+	identity, err := verifier.Verify(signTestJWT(t, header, valid(), hs256(testJWTSecret)))
+	if err != nil {
+		t.Fatalf("Expected a valid token, got %v", err)
+	}
+	if identity.Subject != "svc-billing" || identity.Issuer != "https://auth.example.com" {
+		t.Errorf("Unexpected identity %+v", identity)
+	}
+	if identity.TenantID != "default" {
+		t.Errorf("Expected a token without a tenant claim to act for the default tenant, got %q", identity.TenantID)
+	}
+
+	tests := map[string]func(claims map[string]interface{}) (map[string]interface{}, func([]byte) []byte){
+		"wrong secret": func(c map[string]interface{}) (map[string]interface{}, func([]byte) []byte) { return c, hs256("other") },
+		"expired": func(c map[string]interface{}) (map[string]interface{}, func([]byte) []byte) {
+			c["exp"] = time.Now().Add(-2 * time.Minute).Unix()
+			return c, hs256(testJWTSecret)
+		},
+		"no expiry": func(c map[string]interface{}) (map[string]interface{}, func([]byte) []byte) {
+			delete(c, "exp")
+			return c, hs256(testJWTSecret)
+		},
+		"not yet valid": func(c map[string]interface{}) (map[string]interface{}, func([]byte) []byte) {
+			c["nbf"] = time.Now().Add(time.Hour).Unix()
+			return c, hs256(testJWTSecret)
+		},
+		"wrong audience": func(c map[string]interface{}) (map[string]interface{}, func([]byte) []byte) {
+			c["aud"] = "other"
+			return c, hs256(testJWTSecret)
+		},
+		"wrong issuer": func(c map[string]interface{}) (map[string]interface{}, func([]byte) []byte) {
+			c["iss"] = "https://evil.example.com"
+			return c, hs256(testJWTSecret)
+		},
+		"no subject": func(c map[string]interface{}) (map[string]interface{}, func([]byte) []byte) {
+			delete(c, "sub")
+			return c, hs256(testJWTSecret)
+		},
+	}
+	for name, modify := range tests {
+		claims, sign := modify(valid())
+		if _, err := verifier.Verify(signTestJWT(t, header, claims, sign)); !errors.Is(err, ErrInvalidJWT) {
+			t.Errorf("%s: expected ErrInvalidJWT, got %v", name, err)
+		}
+	}
+
+	claims := valid()
+	claims["exp"] = time.Now().Add(-30 * time.Second).Unix()
+	if _, err := verifier.Verify(signTestJWT(t, header, claims, hs256(testJWTSecret))); err != nil {
+		t.Errorf("Expected a token expired within the leeway to be accepted, got %v", err)
+	}
+
+	claims = valid()
+	claims["scope"] = "notifications:send notifications:read"
+	if identity, err := verifier.Verify(signTestJWT(t, header, claims, hs256(testJWTSecret))); err != nil || !identity.Can(ScopeNotificationsRead) || identity.Can(ScopeChannelsAdmin) {
+		t.Errorf("Expected the token to be limited to its scope claim, got %+v (%v)", identity, err)
+	}
+
+	claims = valid()
+	claims["tenant_id"] = "team-a"
+	if identity, err := verifier.Verify(signTestJWT(t, header, claims, hs256(testJWTSecret))); err != nil || identity.TenantID != "team-a" {
+		t.Errorf("Expected the tenant claim to name the tenant, got %+v (%v)", identity, err)
+	}
+	for _, tenant := range []interface{}{"team/a", 42} {
+		claims["tenant_id"] = tenant
+		if _, err := verifier.Verify(signTestJWT(t, header, claims, hs256(testJWTSecret))); !errors.Is(err, ErrInvalidJWT) {
+			t.Errorf("Expected tenant claim %v to be rejected, got %v", tenant, err)
+		}
+	}
+
+	none := signTestJWT(t, map[string]interface{}{"alg": "none"}, valid(), func([]byte) []byte { return nil })
+	if _, err := verifier.Verify(none); !errors.Is(err, ErrInvalidJWT) {
+		t.Errorf("Expected unsigned tokens to be rejected, got %v", err)
+	}
+	if _, err := verifier.Verify("not-a-token"); !errors.Is(err, ErrInvalidJWT) {
+		t.Errorf("Expected a malformed token to be rejected, got %v", err)
+	}
+}
+
+func TestJWTVerifierRS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "EC", "kid": "ec-1", "crv": "P-256"},
+		{
+			"kty": "RSA",
+			"kid": "rsa-1",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		},
+	}})
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwks, 0o600); err != nil {
+		t.Fatalf("Failed to write JWKS: %v", err)
+	}
+
+	verifier, err := NewJWTVerifier(&config.Config{JWTJWKSFile: path})
+	if err != nil {
+		t.Fatalf("Failed to create verifier: %v", err)
+	}
+	claims := map[string]interface{}{"sub": "ada", "exp": time.Now().Add(time.Hour).Unix()}
+
+	for _, kid := range []string{"rsa-1", ""} {
+		header := map[string]interface{}{"alg": "RS256", "kid": kid}
+		if identity, err := verifier.Verify(signTestJWT(t, header, claims, rs256(t, key))); err != nil || identity.Subject != "ada" {
+			t.Errorf("Expected a token signed by key %q to be valid, got %v", kid, err)
+		}
+	}
+	if _, err := verifier.Verify(signTestJWT(t, map[string]interface{}{"alg": "RS256", "kid": "rsa-1"}, claims, rs256(t, other))); !errors.Is(err, ErrInvalidJWT) {
+		t.Errorf("Expected a token signed by another key to be rejected, got %v", err)
+	}
+	if _, err := verifier.Verify(signTestJWT(t, map[string]interface{}{"alg": "RS256", "kid": "rsa-2"}, claims, rs256(t, key))); !errors.Is(err, ErrInvalidJWT) {
+		t.Errorf("Expected an unknown key ID to be rejected, got %v", err)
+	}
+	if _, err := verifier.Verify(signTestJWT(t, map[string]interface{}{"alg": "HS256"}, claims, hs256(""))); !errors.Is(err, ErrInvalidJWT) {
+		t.Errorf("Expected HS256 tokens to be rejected without a secret, got %v", err)
+	}
+
+	if _, err := NewJWTVerifier(&config.Config{JWTJWKSFile: filepath.Join(t.TempDir(), "missing.json")}); err == nil {
+		t.Error("Expected a missing JWKS file to be rejected")
+	}
+}
+
+func TestJWTVerifierTenantClaim(t *testing.T) {
+	verifier, err := NewJWTVerifier(&config.Config{JWTSecret: testJWTSecret, JWTTenantClaim: "org", JWTRequireTenant: true})
+	if err != nil {
+		t.Fatalf("Failed to create verifier: %v", err)
+	}
+	header := map[string]interface{}{"alg": "HS256"}
+	claims := map[string]interface{}{"sub": "ada", "exp": time.Now().Add(time.Hour).Unix()}
+
+	if _, err := verifier.Verify(signTestJWT(t, header, claims, hs256(testJWTSecret))); !errors.Is(err, ErrInvalidJWT) {
+		t.Errorf("Expected a token without a tenant to be rejected, got %v", err)
+	}
+	claims["org"] = "team-b"
+	if identity, err := verifier.Verify(signTestJWT(t, header, claims, hs256(testJWTSecret))); err != nil || identity.TenantID != "team-b" {
+		t.Errorf("Expected the configured claim to name the tenant, got %+v (%v)", identity, err)
+	}
+}
+
+func TestNewJWTVerifierRejectsWeakSecrets(t *testing.T) {
+	for _, secret := range []string{"your-super-secret-jwt-key-change-this-in-production", "your-secret-key", "too-short"} {
+		if _, err := NewJWTVerifier(&config.Config{JWTSecret: secret}); err == nil {
+			t.Errorf("Expected JWT_SECRET %q to be rejected", secret)
+		}
+	}
+	if _, err := NewJWTVerifier(&config.Config{}); err == nil {
+		t.Error("Expected a verifier without a secret or JWKS file to be rejected")
+	}
+}
+
+func TestJWTVerifierDefaultScopes(t *testing.T) {
+	header := map[string]interface{}{"alg": "HS256"}
+	claims := map[string]interface{}{"sub": "ada", "exp": time.Now().Add(time.Hour).Unix()}
+
+	verifier, err := NewJWTVerifier(&config.Config{JWTSecret: testJWTSecret})
+	if err != nil {
+		t.Fatalf("Failed to create verifier: %v", err)
+	}
+	if identity, err := verifier.Verify(signTestJWT(t, header, claims, hs256(testJWTSecret))); err != nil || identity.Can(ScopeNotificationsRead) {
+		t.Errorf("Expected a token without a scope claim to be granted nothing, got %+v (%v)", identity, err)
+	}
+
+	verifier, err = NewJWTVerifier(&config.Config{JWTSecret: testJWTSecret, JWTDefaultScopes: []string{ScopeNotificationsRead}})
+	if err != nil {
+		t.Fatalf("Failed to create verifier: %v", err)
+	}
+	if identity, err := verifier.Verify(signTestJWT(t, header, claims, hs256(testJWTSecret))); err != nil || !identity.Can(ScopeNotificationsRead) || identity.Can(ScopeAPIKeysAdmin) {
+		t.Errorf("Expected a token without a scope claim to get JWT_DEFAULT_SCOPES, got %+v (%v)", identity, err)
+	}
+
+	if _, err := NewJWTVerifier(&config.Config{JWTSecret: testJWTSecret, JWTDefaultScopes: []string{"everything"}}); err == nil {
+		t.Error("Expected an unknown default scope to be rejected")
+	}
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"notification-service/internal/models"
+
+	"golang.org/x/text/feature/plural"
+	"golang.org/x/text/language"
+)
+
+// CanonicalLocale validates a BCP 47 tag and returns its canonical form (e.g. "pt-br" → "pt-BR")
+func CanonicalLocale(locale string) (string, error) {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return "", fmt.Errorf("invalid locale %q: %w", locale, err)
+	}
+	return tag.String(), nil
+}
+
+// localeChain returns the fallback chain for a locale, most specific first.
+// Subtags are dropped one at a time, so "zh-Hant-TW" yields zh-Hant-TW → zh-Hant → zh
+// and the default locale's own chain is appended: pt-BR → pt → en.
+func localeChain(locale, defaultLocale string) []string {
+	var chain []string
+	seen := make(map[string]bool)
+
+	for _, start := range []string{locale, defaultLocale} {
+		if start == "" {
+			continue
+		}
+		canonical, err := CanonicalLocale(start)
+		if err != nil {
+			continue
+		}
+		parts := strings.Split(canonical, "-")
+		for i := len(parts); i > 0; i-- {
+			candidate := strings.Join(parts[:i], "-")
+			if !seen[candidate] {
+				seen[candidate] = true
+				chain = append(chain, candidate)
+			}
+		}
+	}
+
+	return chain
+}
+
+// selectVariant picks the template variant matching the first entry of the locale's
+// fallback chain. It returns nil when the template's base content should be used.
+func selectVariant(tmpl *models.Template, chain []string) *models.TemplateVariant {
+	for _, candidate := range chain {
+		for i := range tmpl.Variants {
+			if strings.EqualFold(tmpl.Variants[i].Locale, candidate) {
+				return &tmpl.Variants[i]
+			}
+		}
+	}
+	return nil
+}
+
+// dateLayouts holds numeric date layouts per language, with region-specific overrides
+var dateLayouts = map[string]string{
+	"en":    "01/02/2006",
+	"en-GB": "02/01/2006",
+	"en-AU": "02/01/2006",
+	"en-IN": "02/01/2006",
+	"de":    "02.01.2006",
+	"fr":    "02/01/2006",
+	"es":    "02/01/2006",
+	"it":    "02/01/2006",
+	"pt":    "02/01/2006",
+	"nl":    "02-01-2006",
+	"ja":    "2006/01/02",
+	"zh":    "2006/01/02",
+	"ko":    "2006. 01. 02.",
+}
+
+// dateLayoutFor returns the most specific date layout known for a tag
+func dateLayoutFor(tag language.Tag) string {
+	for _, candidate := range localeChain(tag.String(), "") {
+		if layout, ok := dateLayouts[candidate]; ok {
+			return layout
+		}
+	}
+	return "2006-01-02"
+}
+
+// pluralForm returns the CLDR cardinal plural category of n in the given language
+func pluralForm(tag language.Tag, n float64) plural.Form {
+	if n != math.Trunc(n) {
+		return plural.Other
+	}
+	i := int(math.Abs(n))
+	return plural.Cardinal.MatchPlural(tag, i, 0, 0, 0, 0)
+}
+
+// toFloat converts a template value into a float64
+func toFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint:
+		return float64(v), nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	default:
+		return 0, fmt.Errorf("cannot use %T as a number", value)
+	}
+}
+
+// toTime converts a template value into a time.Time. Strings must be RFC 3339.
+func toTime(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case *time.Time:
+		if v == nil {
+			return time.Time{}, fmt.Errorf("cannot format a nil time")
+		}
+		return *v, nil
+	case string:
+		return time.Parse(time.RFC3339, v)
+	default:
+		return time.Time{}, fmt.Errorf("cannot use %T as a time", value)
+	}
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"notification-service/internal/models"
+)
+
+func TestLocaleChain(t *testing.T) {
+	tests := []struct {
+		locale   string
+		expected []string
+	}{
+		{"pt-BR", []string{"pt-BR", "pt", "en"}},
+		{"pt-br", []string{"pt-BR", "pt", "en"}},
+		{"zh-Hant-TW", []string{"zh-Hant-TW", "zh-Hant", "zh", "en"}},
+		{"en-GB", []string{"en-GB", "en"}},
+		{"", []string{"en"}},
+	}
+
+	for _, tt := range tests {
+		chain := localeChain(tt.locale, "en")
+		if !reflect.DeepEqual(chain, tt.expected) {
+			t.Errorf("localeChain(%q): expected %v, got %v", tt.locale, tt.expected, chain)
+		}
+	}
+}
+
+func TestSelectVariant(t *testing.T) {
+	tmpl := &models.Template{
+		Content: "Hello",
+		Variants: []models.TemplateVariant{
+			{Locale: "pt", Content: "Olá"},
+			{Locale: "de", Content: "Hallo"},
+		},
+	}
+
+	if variant := selectVariant(tmpl, localeChain("pt-BR", "en")); variant == nil || variant.Content != "Olá" {
+		t.Errorf("Expected pt variant for pt-BR, got %+v", variant)
+	}
+
+	if variant := selectVariant(tmpl, localeChain("fr-CA", "en")); variant != nil {
+		t.Errorf("Expected base content for fr-CA, got %+v", variant)
+	}
+}
+
+func TestLocaleAwareFuncs(t *testing.T) {
+	data := models.JSON{"Count": float64(1234.5), "Items": float64(1), "Date": "2024-03-05T10:00:00Z"}
+	source := `{{format_number .Count}} {{plural .Items "item" "items"}} {{format_date .Date}}`
+
+	tests := []struct {
+		locale   string
+		expected string
+	}{
+		{"en-US", "1,234.5 item 03/05/2024"},
+		{"en-GB", "1,234.5 item 05/03/2024"},
+		{"de-DE", "1.234,5 item 05.03.2024"},
+	}
+
+	for _, tt := range tests {
+		result, err := renderTemplate("test", source, TextFormat, data, &renderContext{Funcs: templateFuncs(tt.locale, time.Now())})
+		if err != nil {
+			t.Fatalf("Failed to render template for %s: %v", tt.locale, err)
+		}
+		if result != tt.expected {
+			t.Errorf("%s: expected '%s', got '%s'", tt.locale, tt.expected, result)
+		}
+	}
+}
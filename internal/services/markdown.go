@@ -0,0 +1,283 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// markdownExtensions are the GitHub Flavored Markdown features templates may use
+var markdownExtensions = goldmark.WithExtensions(extension.Strikethrough, extension.Linkify)
+
+// emailMarkdown renders Markdown for HTML email, keeping inline HTML written by template authors
+var emailMarkdown = goldmark.New(markdownExtensions, goldmark.WithRendererOptions(html.WithUnsafe()))
+
+// sanitizedMarkdown renders Markdown for in-app display, omitting raw HTML and dangerous URLs
+var sanitizedMarkdown = goldmark.New(markdownExtensions)
+
+// markdownStyle describes how Markdown constructs are written in a text-based format
+type markdownStyle struct {
+	escape    func(string) string
+	emphasis  func(string) string
+	strong    func(string) string
+	strike    func(string) string
+	code      func(string) string
+	codeBlock func(string) string
+	heading   func(string) string
+	link      func(label, url string) string
+	bullet    string
+	rule      string
+}
+
+func identity(s string) string { return s }
+
+// slackStyle writes Slack mrkdwn
+var slackStyle = markdownStyle{
+	escape:    escapeSlack,
+	emphasis:  func(s string) string { return "_" + s + "_" },
+	strong:    func(s string) string { return "*" + s + "*" },
+	strike:    func(s string) string { return "~" + s + "~" },
+	code:      func(s string) string { return "`" + s + "`" },
+	codeBlock: func(s string) string { return "```\n" + s + "\n```" },
+	heading:   func(s string) string { return "*" + s + "*" },
+	link: func(label, url string) string {
+		if label == "" || label == url {
+			return "<" + url + ">"
+		}
+		return "<" + url + "|" + label + ">"
+	},
+	bullet: "•",
+	rule:   "───",
+}
+
+// plainStyle writes unformatted text for SMS and the text part of email
+var plainStyle = markdownStyle{
+	escape:    identity,
+	emphasis:  identity,
+	strong:    identity,
+	strike:    identity,
+	code:      identity,
+	codeBlock: identity,
+	heading:   identity,
+	link: func(label, url string) string {
+		if label == "" || label == url {
+			return url
+		}
+		return label + " (" + url + ")"
+	},
+	bullet: "-",
+	rule:   "---",
+}
+
+// convertMarkdown converts Markdown source into the given format
+func convertMarkdown(source string, format ContentFormat) (string, error) {
+	src := []byte(source)
+
+	switch format {
+	case HTMLFormat:
+		return renderMarkdownHTML(emailMarkdown, src)
+	case SanitizedHTMLFormat:
+		return renderMarkdownHTML(sanitizedMarkdown, src)
+	case MarkdownFormat:
+		return strings.TrimSpace(source), nil
+	case SlackFormat:
+		return renderMarkdownText(src, &slackStyle), nil
+	case TextFormat:
+		return renderMarkdownText(src, &plainStyle), nil
+	case SMSFormat:
+		return escapeSMS(renderMarkdownText(src, &plainStyle)), nil
+	default:
+		return "", fmt.Errorf("cannot convert Markdown to %s", format)
+	}
+}
+
+func renderMarkdownHTML(md goldmark.Markdown, src []byte) (string, error) {
+	var buf bytes.Buffer
+	if err := md.Convert(src, &buf); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// renderMarkdownText walks the Markdown syntax tree writing it out in a text-based style
+func renderMarkdownText(src []byte, style *markdownStyle) string {
+	doc := sanitizedMarkdown.Parser().Parse(text.NewReader(src))
+	w := &markdownTextWriter{src: src, style: style}
+	return strings.TrimSpace(w.blocks(doc, "\n\n"))
+}
+
+// markdownTextWriter converts a Markdown syntax tree into a text-based format
+type markdownTextWriter struct {
+	src   []byte
+	style *markdownStyle
+}
+
+// blocks writes the block children of node joined by sep
+func (w *markdownTextWriter) blocks(node ast.Node, sep string) string {
+	var parts []string
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		if block := w.block(child); block != "" {
+			parts = append(parts, block)
+		}
+	}
+	return strings.Join(parts, sep)
+}
+
+func (w *markdownTextWriter) block(node ast.Node) string {
+	switch n := node.(type) {
+	case *ast.Paragraph, *ast.TextBlock:
+		return w.inlines(n)
+	case *ast.Heading:
+		return w.style.heading(w.inlines(n))
+	case *ast.ThematicBreak:
+		return w.style.rule
+	case *ast.CodeBlock, *ast.FencedCodeBlock:
+		return w.style.codeBlock(w.style.escape(strings.TrimRight(w.lines(n), "\n")))
+	case *ast.Blockquote:
+		return prefixLines(w.blocks(n, "\n\n"), "> ", "> ")
+	case *ast.List:
+		sep := "\n"
+		if !n.IsTight {
+			sep = "\n\n"
+		}
+		var items []string
+		number := n.Start
+		for item := n.FirstChild(); item != nil; item = item.NextSibling() {
+			marker := w.style.bullet + " "
+			if n.IsOrdered() {
+				marker = fmt.Sprintf("%d. ", number)
+				number++
+			}
+			items = append(items, prefixLines(w.blocks(item, "\n"), marker, strings.Repeat(" ", len(marker))))
+		}
+		return strings.Join(items, sep)
+	default:
+		// Raw HTML blocks have no equivalent outside HTML
+		return ""
+	}
+}
+
+// inlines writes the inline children of node
+func (w *markdownTextWriter) inlines(node ast.Node) string {
+	var b strings.Builder
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		b.WriteString(w.inline(child))
+	}
+	return b.String()
+}
+
+func (w *markdownTextWriter) inline(node ast.Node) string {
+	switch n := node.(type) {
+	case *ast.Text:
+		s := w.style.escape(unescapeMarkdown(n.Segment.Value(w.src)))
+		if n.SoftLineBreak() || n.HardLineBreak() {
+			s += "\n"
+		}
+		return s
+	case *ast.String:
+		return w.style.escape(string(n.Value))
+	case *ast.CodeSpan:
+		return w.style.code(w.style.escape(w.codeText(n)))
+	case *ast.Emphasis:
+		if n.Level >= 2 {
+			return w.style.strong(w.inlines(n))
+		}
+		return w.style.emphasis(w.inlines(n))
+	case *east.Strikethrough:
+		return w.style.strike(w.inlines(n))
+	case *ast.Link:
+		return w.style.link(w.inlines(n), string(n.Destination))
+	case *ast.Image:
+		return w.style.link(w.style.escape(w.plainText(n)), string(n.Destination))
+	case *ast.AutoLink:
+		url := string(n.URL(w.src))
+		if n.AutoLinkType == ast.AutoLinkEmail && !strings.HasPrefix(url, "mailto:") {
+			return w.style.link(w.style.escape(url), "mailto:"+url)
+		}
+		return w.style.link(w.style.escape(string(n.Label(w.src))), url)
+	default:
+		// Raw inline HTML has no equivalent outside HTML
+		return ""
+	}
+}
+
+// plainText returns the unformatted text below node
+func (w *markdownTextWriter) plainText(node ast.Node) string {
+	var b strings.Builder
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		switch n := child.(type) {
+		case *ast.Text:
+			b.WriteString(unescapeMarkdown(n.Segment.Value(w.src)))
+		case *ast.String:
+			b.Write(n.Value)
+		default:
+			b.WriteString(w.plainText(n))
+		}
+	}
+	return b.String()
+}
+
+// codeText returns the literal text of a code span, where escapes do not apply
+func (w *markdownTextWriter) codeText(node ast.Node) string {
+	var b strings.Builder
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		if n, ok := child.(*ast.Text); ok {
+			b.Write(n.Segment.Value(w.src))
+		}
+	}
+	return b.String()
+}
+
+// unescapeMarkdown resolves backslash escapes and entity references in Markdown text
+func unescapeMarkdown(value []byte) string {
+	value = util.UnescapePunctuations(value)
+	value = util.ResolveNumericReferences(value)
+	return string(util.ResolveEntityNames(value))
+}
+
+// lines returns the raw lines of a code block
+func (w *markdownTextWriter) lines(node ast.Node) string {
+	var b strings.Builder
+	lines := node.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		segment := lines.At(i)
+		b.Write(segment.Value(w.src))
+	}
+	return b.String()
+}
+
+// prefixLines prefixes the first line of s with first and every other line with rest
+func prefixLines(s, first, rest string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		prefix := rest
+		if i == 0 {
+			prefix = first
+		}
+		if line == "" && i > 0 {
+			lines[i] = strings.TrimRight(prefix, " ")
+			continue
+		}
+		lines[i] = prefix + line
+	}
+	return strings.Join(lines, "\n")
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"<", `\<`, ">", `\>`, "#", `\#`, "~", `\~`, "|", `\|`, "!", `\!`,
+)
+
+// escapeMarkdown backslash-escapes Markdown punctuation so template data is
+// shown literally rather than interpreted as formatting
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
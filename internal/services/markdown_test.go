@@ -0,0 +1,96 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"notification-service/internal/models"
+)
+
+const markdownSample = `# Deploy finished
+
+Release **v1.2** is _live_ with ~~no~~ fixes. See [the notes](https://example.com/notes).
+
+- Run ` + "`migrate`" + `
+- Check https://status.example.com
+
+> Ping <ops> & on-call`
+
+func TestConvertMarkdown(t *testing.T) {
+	tests := []struct {
+		format   ContentFormat
+		expected string
+	}{
+		{
+			format: SlackFormat,
+			expected: "*Deploy finished*\n\n" +
+				"Release *v1.2* is _live_ with ~no~ fixes. See <https://example.com/notes|the notes>.\n\n" +
+				"• Run `migrate`\n" +
+				"• Check <https://status.example.com>\n\n" +
+				"> Ping  &amp; on-call",
+		},
+		{
+			format: TextFormat,
+			expected: "Deploy finished\n\n" +
+				"Release v1.2 is live with no fixes. See the notes (https://example.com/notes).\n\n" +
+				"- Run migrate\n" +
+				"- Check https://status.example.com\n\n" +
+				"> Ping  & on-call",
+		},
+		{
+			format:   MarkdownFormat,
+			expected: markdownSample,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			result, err := convertMarkdown(markdownSample, tt.format)
+			if err != nil {
+				t.Fatalf("Failed to convert Markdown: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected:\n%s\ngot:\n%s", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestConvertMarkdownHTML(t *testing.T) {
+	source := "Hello **there** <b>raw</b> [x](javascript:alert(1))"
+
+	email, err := convertMarkdown(source, HTMLFormat)
+	if err != nil {
+		t.Fatalf("Failed to convert Markdown: %v", err)
+	}
+	if !strings.Contains(email, "<strong>there</strong>") || !strings.Contains(email, "<b>raw</b>") {
+		t.Errorf("Expected formatted HTML keeping inline HTML, got %s", email)
+	}
+
+	sanitized, err := convertMarkdown(source, SanitizedHTMLFormat)
+	if err != nil {
+		t.Fatalf("Failed to convert Markdown: %v", err)
+	}
+	if strings.Contains(sanitized, "<b>raw</b>") || strings.Contains(sanitized, "javascript:") {
+		t.Errorf("Expected raw HTML and dangerous links to be removed, got %s", sanitized)
+	}
+}
+
+func TestMarkdownTemplateEscapesData(t *testing.T) {
+	data := models.JSON{"Name": "*not bold* <b>"}
+
+	markdown, err := renderTemplate("content", "Hi **{{.Name}}**", MarkdownFormat, data, nil)
+	if err != nil {
+		t.Fatalf("Failed to render template: %v", err)
+	}
+
+	result, err := convertMarkdown(markdown, SlackFormat)
+	if err != nil {
+		t.Fatalf("Failed to convert Markdown: %v", err)
+	}
+
+	expected := "Hi **not bold* &lt;b&gt;*"
+	if result != expected {
+		t.Errorf("Expected '%s', got '%s'", expected, result)
+	}
+}
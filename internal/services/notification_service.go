@@ -1,10 +1,12 @@
 package services
 
 import (
-	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"html/template"
 	"log"
+	"strings"
+	"sync"
 	"time"
 
 	"notification-service/internal/config"
@@ -17,36 +19,87 @@ import (
 type NotificationService struct {
 	db     *gorm.DB
 	config *config.Config
+	blobs       *BlobStore
 	emailSender *EmailSender
 	slackSender *SlackSender
 	inAppSender *InAppSender
+	// keyring encrypts channel secrets; it is nil when no master key is configured
+	keyring *channelKeyring
+	quotas  *tenantQuotas
+
+	// slackSenders caches the Slack senders built from channel settings, by channelKey
+	mu           sync.Mutex
+	slackSenders map[string]cachedSlackSender
+}
+
+// cachedSlackSender is a Slack sender built from a channel as it was last updated
+type cachedSlackSender struct {
+	updatedAt time.Time
+	sender    *SlackSender
 }
 
 // NewNotificationService creates a new notification service
 func NewNotificationService(db *gorm.DB) *NotificationService {
 	cfg := config.Load()
+	blobs := NewBlobStore(cfg.BlobStorePath)
+	keyring, err := loadChannelKeyring(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load channel master key: %v", err)
+	}
+	quotas, err := loadTenantQuotas(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load tenant quotas: %v", err)
+	}
 	
-	return &NotificationService{
+	s := &NotificationService{
 		db:     db,
 		config: cfg,
-		emailSender: NewEmailSender(cfg),
+		blobs:  blobs,
 		slackSender: NewSlackSender(cfg),
 		inAppSender: NewInAppSender(),
+		keyring:     keyring,
+		quotas:      quotas,
+		slackSenders: make(map[string]cachedSlackSender),
 	}
+	s.emailSender = NewEmailSender(cfg, blobs, s.findChannel)
+	return s
 }
 
-// SendNotification sends a notification immediately
+// SendNotification sends a notification immediately on behalf of req.TenantID
 func (s *NotificationService) SendNotification(req *models.NotificationRequest) (*models.Notification, error) {
+	if req.TenantID == "" {
+		req.TenantID = models.DefaultTenant
+	}
+	if _, err := s.activeChannel(req.TenantID, req.Type, req.Channel); err != nil {
+		return nil, err
+	}
+	deliveries, err := buildDeliveries(req)
+	if err != nil {
+		return nil, err
+	}
+	recipient := primaryRecipient(req, deliveries)
+
 	// Create notification record
 	notification := &models.Notification{
 		Type:       req.Type,
 		Status:     models.PendingStatus,
 		Title:      req.Title,
 		Message:    req.Message,
-		Recipient:  req.Recipient,
+		HTMLMessage: req.HTMLMessage,
+		Recipient:  recipient,
 		Channel:    req.Channel,
+		Category:   req.Category,
+		ThreadKey:  req.ThreadKey,
+		Ephemeral:  req.Ephemeral,
 		TemplateID: req.TemplateID,
+		Locale:     s.resolveLocale(req.TenantID, req.Locale, recipient),
 		Metadata:   req.Metadata,
+		Deliveries: deliveries,
+		TenantID:   req.TenantID,
+		CreatedBy:  req.CreatedBy,
+	}
+	if err := s.applyEmailHeaders(notification, req); err != nil {
+		return nil, err
 	}
 
 	// Process template if provided
@@ -56,39 +109,64 @@ func (s *NotificationService) SendNotification(req *models.NotificationRequest)
 		}
 	}
 
-	// Save to database
-	if err := s.db.Create(notification).Error; err != nil {
+	if err := validateSlackNotification(notification); err != nil {
+		return nil, err
+	}
+
+	attachments, err := s.buildAttachments(notification, req.Attachments)
+	if err != nil {
+		return nil, err
+	}
+	notification.Attachments = attachments
+
+	// Save to database, within the tenant's quota
+	if err := s.createNotification(notification); err != nil {
 		return nil, err
 	}
 
 	// Send notification
-	if err := s.sendNotification(notification); err != nil {
-		notification.Status = models.FailedStatus
-		s.db.Save(notification)
+	if err := s.deliver(notification); err != nil {
 		return notification, err
 	}
 
-	// Update status to sent
-	notification.Status = models.SentStatus
-	now := time.Now()
-	notification.SentAt = &now
-	s.db.Save(notification)
-
 	return notification, nil
 }
 
-// ScheduleNotification schedules a notification for later
+// ScheduleNotification schedules a notification for later on behalf of req.TenantID
 func (s *NotificationService) ScheduleNotification(req *models.ScheduleRequest) (*models.Notification, error) {
+	if req.TenantID == "" {
+		req.TenantID = models.DefaultTenant
+	}
+	if _, err := s.activeChannel(req.TenantID, req.Type, req.Channel); err != nil {
+		return nil, err
+	}
+	deliveries, err := buildDeliveries(&req.NotificationRequest)
+	if err != nil {
+		return nil, err
+	}
+	recipient := primaryRecipient(&req.NotificationRequest, deliveries)
+
 	notification := &models.Notification{
 		Type:        req.Type,
 		Status:      models.ScheduledStatus,
 		Title:       req.Title,
 		Message:     req.Message,
-		Recipient:   req.Recipient,
+		HTMLMessage: req.HTMLMessage,
+		Recipient:   recipient,
 		Channel:     req.Channel,
+		Category:    req.Category,
+		ThreadKey:   req.ThreadKey,
+		Ephemeral:   req.Ephemeral,
 		TemplateID:  req.TemplateID,
+		Locale:      s.resolveLocale(req.TenantID, req.Locale, recipient),
 		Metadata:    req.Metadata,
 		ScheduledAt: &req.ScheduledAt,
+		Deliveries:  deliveries,
+		TenantID:    req.TenantID,
+		CreatedBy:   req.CreatedBy,
+	}
+	if err := s.applyEmailHeaders(notification, &req.NotificationRequest); err != nil {
+		return nil, err
 	}
 
 	// Process template if provided
@@ -98,46 +176,70 @@ func (s *NotificationService) ScheduleNotification(req *models.ScheduleRequest)
 		}
 	}
 
-	// Save to database
-	if err := s.db.Create(notification).Error; err != nil {
+	if err := validateSlackNotification(notification); err != nil {
+		return nil, err
+	}
+
+	attachments, err := s.buildAttachments(notification, req.Attachments)
+	if err != nil {
+		return nil, err
+	}
+	notification.Attachments = attachments
+
+	// Save to database, within the tenant's quota
+	if err := s.createNotification(notification); err != nil {
 		return nil, err
 	}
 
+	s.scheduleOnSlack(notification)
+
 	return notification, nil
 }
 
+// createNotification saves a new notification if its tenant has quota left for it
+func (s *NotificationService) createNotification(notification *models.Notification) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.checkNotificationQuota(tx, notification.TenantID); err != nil {
+			return err
+		}
+		return tx.Create(notification).Error
+	})
+}
+
 // ProcessScheduledNotifications processes all scheduled notifications that are due
 func (s *NotificationService) ProcessScheduledNotifications() error {
 	var notifications []models.Notification
 	
-	if err := s.db.Where("status = ? AND scheduled_at <= ?", 
+	if err := s.db.Preload("Attachments").Preload("Deliveries").Preload("Links").Where("status = ? AND scheduled_at <= ?", 
 		models.ScheduledStatus, time.Now()).Find(&notifications).Error; err != nil {
 		return err
 	}
 
 	for _, notification := range notifications {
-		if err := s.sendNotification(&notification); err != nil {
-			log.Printf("Failed to send scheduled notification %d: %v", notification.ID, err)
-			notification.Status = models.FailedStatus
-			s.db.Save(&notification)
+		// Slack posts natively scheduled messages itself
+		if notification.SlackScheduledMessageID != "" {
+			if err := s.db.Model(&notification).Updates(map[string]interface{}{
+				"status":  models.SentStatus,
+				"sent_at": notification.ScheduledAt,
+			}).Error; err != nil {
+				log.Printf("Failed to mark scheduled notification %d sent: %v", notification.ID, err)
+			}
 			continue
 		}
-
-		notification.Status = models.SentStatus
-		now := time.Now()
-		notification.SentAt = &now
-		s.db.Save(&notification)
+		if err := s.deliver(&notification); err != nil {
+			log.Printf("Failed to send scheduled notification %d: %v", notification.ID, err)
+		}
 	}
 
 	return nil
 }
 
-// GetNotifications retrieves notifications with optional filtering
-func (s *NotificationService) GetNotifications(limit, offset int, status models.NotificationStatus, notificationType models.NotificationType) ([]models.Notification, int64, error) {
+// GetNotifications retrieves a tenant's notifications with optional filtering
+func (s *NotificationService) GetNotifications(tenantID string, limit, offset int, status models.NotificationStatus, notificationType models.NotificationType) ([]models.Notification, int64, error) {
 	var notifications []models.Notification
 	var total int64
 
-	query := s.db.Model(&models.Notification{})
+	query := s.db.Model(&models.Notification{}).Where("tenant_id = ?", tenantID)
 
 	if status != "" {
 		query = query.Where("status = ?", status)
@@ -160,32 +262,124 @@ func (s *NotificationService) GetNotifications(limit, offset int, status models.
 	return notifications, total, nil
 }
 
-// GetNotification retrieves a single notification by ID
-func (s *NotificationService) GetNotification(id uint) (*models.Notification, error) {
+// GetNotification retrieves one of a tenant's notifications by ID
+func (s *NotificationService) GetNotification(tenantID string, id uint) (*models.Notification, error) {
 	var notification models.Notification
-	if err := s.db.Preload("Template").First(&notification, id).Error; err != nil {
+	if err := s.db.Where("tenant_id = ?", tenantID).Preload("Template").Preload("Attachments").Preload("Deliveries").Preload("Links").Preload("Actions").First(&notification, id).Error; err != nil {
 		return nil, err
 	}
 	return &notification, nil
 }
 
-// UpdateNotification updates a notification
-func (s *NotificationService) UpdateNotification(id uint, updates map[string]interface{}) (*models.Notification, error) {
+// UpdateNotification updates one of a tenant's notifications. A notification already
+// posted to Slack has its message edited to match, and one Slack is yet to post is
+// rescheduled.
+func (s *NotificationService) UpdateNotification(tenantID string, id uint, req *models.NotificationUpdateRequest) (*models.Notification, error) {
 	var notification models.Notification
-	if err := s.db.First(&notification, id).Error; err != nil {
+	if err := s.db.Where("tenant_id = ?", tenantID).First(&notification, id).Error; err != nil {
 		return nil, err
 	}
+	scheduledOnSlack := notification.SlackScheduledMessageID != "" && notification.Status == models.ScheduledStatus &&
+		notification.ScheduledAt != nil && notification.ScheduledAt.After(time.Now())
+
+	if notification.Type == models.SlackNotification {
+		edited := notification
+		if req.Message != nil {
+			edited.Message = *req.Message
+		}
+		if req.Metadata != nil {
+			edited.Metadata = req.Metadata
+		}
+		if err := validateSlackNotification(&edited); err != nil {
+			return nil, err
+		}
+	}
 
-	if err := s.db.Model(&notification).Updates(updates).Error; err != nil {
+	if updates := notificationUpdates(req); len(updates) > 0 {
+		if err := s.db.Model(&notification).Updates(updates).Error; err != nil {
+			return nil, err
+		}
+	}
+	if err := s.db.First(&notification, id).Error; err != nil {
 		return nil, err
 	}
 
+	if notification.Type == models.SlackNotification && notification.SlackTS != "" {
+		sender, err := s.slackSenderFor(notification.TenantID, notification.Channel)
+		if err != nil {
+			return &notification, err
+		}
+		if err := sender.Update(&notification); err != nil {
+			return &notification, err
+		}
+	}
+
+	// A message handed to Slack to post later is rescheduled with the changes
+	if scheduledOnSlack {
+		sender, err := s.slackSenderFor(notification.TenantID, notification.Channel)
+		if err != nil {
+			return &notification, err
+		}
+		if err := sender.Unschedule(&notification); err != nil {
+			return &notification, err
+		}
+		if err := s.db.Model(&notification).Update("slack_scheduled_message_id", "").Error; err != nil {
+			return &notification, err
+		}
+		notification.SlackScheduledMessageID = ""
+		s.scheduleOnSlack(&notification)
+	}
+
 	return &notification, nil
 }
 
-// DeleteNotification deletes a notification
-func (s *NotificationService) DeleteNotification(id uint) error {
-	return s.db.Delete(&models.Notification{}, id).Error
+// notificationUpdates returns the columns an update request changes. Nothing else, such
+// as the tenant, status or Slack message IDs, can be written through an update.
+func notificationUpdates(req *models.NotificationUpdateRequest) map[string]interface{} {
+	updates := make(map[string]interface{})
+	if req.Title != nil {
+		updates["title"] = *req.Title
+	}
+	if req.Message != nil {
+		updates["message"] = *req.Message
+	}
+	if req.Metadata != nil {
+		updates["metadata"] = req.Metadata
+	}
+	if req.ScheduledAt != nil {
+		updates["scheduled_at"] = *req.ScheduledAt
+	}
+	return updates
+}
+
+// DeleteNotification deletes one of a tenant's notifications, first retracting it from
+// Slack if it was posted there or cancelling it if Slack was to post it later
+func (s *NotificationService) DeleteNotification(tenantID string, id uint) error {
+	var notification models.Notification
+	if err := s.db.Where("tenant_id = ?", tenantID).First(&notification, id).Error; err != nil {
+		return err
+	}
+
+	if notification.Type == models.SlackNotification && notification.SlackTS != "" {
+		sender, err := s.slackSenderFor(notification.TenantID, notification.Channel)
+		if err != nil {
+			return err
+		}
+		if err := sender.Delete(&notification); err != nil {
+			return err
+		}
+	}
+	if notification.SlackScheduledMessageID != "" && notification.Status == models.ScheduledStatus {
+		sender, err := s.slackSenderFor(notification.TenantID, notification.Channel)
+		if err != nil {
+			return err
+		}
+		if err := sender.Unschedule(&notification); err != nil {
+			return err
+		}
+	}
+
+	return s.db.Delete(&notification).Error
 }
 
 // GetDB returns the database instance
@@ -193,12 +387,12 @@ func (s *NotificationService) GetDB() *gorm.DB {
 	return s.db
 }
 
-// GetEmailSender returns the email sender
+// GetEmailSender returns the email sender, which sends through every email channel
 func (s *NotificationService) GetEmailSender() *EmailSender {
 	return s.emailSender
 }
 
-// GetSlackSender returns the Slack sender
+// GetSlackSender returns the Slack sender configured from the environment
 func (s *NotificationService) GetSlackSender() *SlackSender {
 	return s.slackSender
 }
@@ -208,18 +402,178 @@ func (s *NotificationService) GetInAppSender() *InAppSender {
 	return s.inAppSender
 }
 
+// DKIMKey returns the DKIM key that signs email sent through a tenant's channel, or
+// email sent without one when channelName is empty
+func (s *NotificationService) DKIMKey(tenantID, channelName string) (*DKIMKey, error) {
+	return s.emailSender.DKIMKey(tenantID, channelName)
+}
+
+// findChannel returns a tenant's channel with the given name and type, with its
+// secrets decrypted, or nil when there is none
+func (s *NotificationService) findChannel(tenantID, name string, notificationType models.NotificationType) *models.Channel {
+	var channel models.Channel
+	if err := s.db.Where("tenant_id = ? AND name = ? AND type = ?", tenantID, name, notificationType).First(&channel).Error; err != nil {
+		return nil
+	}
+	s.openChannel(&channel)
+	return &channel
+}
+
+// deliver sends a notification and records the outcome. Emails that some but not
+// all recipients' servers accepted are marked as partially sent, and emails whose
+// recipients are all suppressed are not sent.
+func (s *NotificationService) deliver(notification *models.Notification) error {
+	if notification.Type == models.EmailNotification {
+		allSuppressed, err := s.applySuppressions(notification)
+		if err != nil {
+			return err
+		}
+		if allSuppressed {
+			notification.Status = models.SuppressedStatus
+			s.saveDeliveries(notification)
+			return nil
+		}
+		if err := s.prepareTracking(notification); err != nil {
+			return err
+		}
+	}
+
+	if notification.Type == models.SlackNotification {
+		if err := s.resolveSlackThread(notification); err != nil {
+			return err
+		}
+	}
+
+	err := s.sendNotification(notification)
+
+	// A rate limited Slack message is requeued for when Slack allows it
+	if retryAfter, ok := slackRetryAfter(err); ok {
+		retryAt := time.Now().Add(retryAfter)
+		log.Printf("Slack rate limited notification %d, retrying at %s", notification.ID, retryAt.Format(time.RFC3339))
+		notification.Status = models.ScheduledStatus
+		notification.ScheduledAt = &retryAt
+		s.saveDeliveries(notification)
+		return nil
+	}
+
+	switch {
+	case err != nil:
+		notification.Status = models.FailedStatus
+	case hasFailedDelivery(notification.Deliveries):
+		notification.Status = models.PartiallySentStatus
+	default:
+		notification.Status = models.SentStatus
+	}
+	if err == nil {
+		now := time.Now()
+		notification.SentAt = &now
+	}
+
+	s.saveDeliveries(notification)
+
+	return err
+}
+
+// saveDeliveries saves a notification along with the status of each of its deliveries
+func (s *NotificationService) saveDeliveries(notification *models.Notification) {
+	for i := range notification.Deliveries {
+		notification.Deliveries[i].NotificationID = notification.ID
+		s.db.Save(&notification.Deliveries[i])
+	}
+	s.db.Save(notification)
+}
+
+// maxSlackScheduleAhead is how far ahead chat.scheduleMessage accepts messages
+const maxSlackScheduleAhead = 120 * 24 * time.Hour
+
+// scheduleOnSlack hands a scheduled Slack notification to chat.scheduleMessage when
+// SLACK_NATIVE_SCHEDULING is set and it is due within 120 days, so it is posted on
+// time even if our scheduler is down. Ephemeral messages cannot be scheduled on
+// Slack. If Slack refuses the message, or its ID cannot be recorded, our scheduler
+// sends it as usual.
+func (s *NotificationService) scheduleOnSlack(notification *models.Notification) {
+	if !s.config.SlackNativeScheduling || notification.Type != models.SlackNotification || notification.Ephemeral {
+		return
+	}
+	if until := time.Until(*notification.ScheduledAt); until <= 0 || until >= maxSlackScheduleAhead {
+		return
+	}
+
+	if err := s.resolveSlackThread(notification); err != nil {
+		log.Printf("Failed to schedule notification %d on Slack: %v", notification.ID, err)
+		return
+	}
+	sender, err := s.slackSenderFor(notification.TenantID, notification.Channel)
+	if err != nil {
+		log.Printf("Failed to schedule notification %d on Slack: %v", notification.ID, err)
+		return
+	}
+	channelID := notification.SlackChannelID
+	if err := sender.Schedule(notification); err != nil {
+		log.Printf("Failed to schedule notification %d on Slack: %v", notification.ID, err)
+		return
+	}
+	if err := s.db.Model(notification).Updates(map[string]interface{}{
+		"slack_channel_id":           notification.SlackChannelID,
+		"slack_thread_ts":            notification.SlackThreadTS,
+		"slack_scheduled_message_id": notification.SlackScheduledMessageID,
+	}).Error; err != nil {
+		// Without the scheduled message ID our scheduler would post it too, so the
+		// message is cancelled on Slack and left to our scheduler
+		log.Printf("Failed to record Slack scheduled message for notification %d: %v", notification.ID, err)
+		if err := sender.Unschedule(notification); err != nil {
+			log.Printf("Failed to cancel Slack scheduled message %s for notification %d, it may be posted twice: %v",
+				notification.SlackScheduledMessageID, notification.ID, err)
+		}
+		notification.SlackChannelID = channelID
+		notification.SlackScheduledMessageID = ""
+	}
+}
+
+// resolveSlackThread points a Slack notification with a thread key at the thread
+// started by the first of its tenant's notifications posted with the same key. The
+// first one starts the thread itself.
+func (s *NotificationService) resolveSlackThread(notification *models.Notification) error {
+	if notification.ThreadKey == "" || notification.SlackThreadTS != "" {
+		return nil
+	}
+
+	var parent models.Notification
+	err := s.db.Where("tenant_id = ? AND type = ? AND thread_key = ? AND slack_ts <> '' AND id <> ?",
+		notification.TenantID, models.SlackNotification, notification.ThreadKey, notification.ID).
+		Order("id").First(&parent).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	notification.SlackChannelID = parent.SlackChannelID
+	notification.SlackThreadTS = parent.SlackTS
+	if parent.SlackThreadTS != "" {
+		notification.SlackThreadTS = parent.SlackThreadTS
+	}
+	return nil
+}
+
+// hasFailedDelivery reports whether any address of an email was rejected
+func hasFailedDelivery(deliveries []models.Delivery) bool {
+	for _, delivery := range deliveries {
+		if delivery.Status == models.DeliveryFailed {
+			return true
+		}
+	}
+	return false
+}
+
 // sendNotification sends a notification through the appropriate channel
 func (s *NotificationService) sendNotification(notification *models.Notification) error {
-	switch notification.Type {
-	case models.EmailNotification:
-		return s.emailSender.Send(notification)
-	case models.SlackNotification:
-		return s.slackSender.Send(notification)
-	case models.InAppNotification:
-		return s.inAppSender.Send(notification)
-	default:
-		return fmt.Errorf("unsupported notification type: %s", notification.Type)
+	sender, err := s.senderFor(notification.TenantID, notification.Type, notification.Channel)
+	if err != nil {
+		return err
 	}
+	return sender.Send(notification)
 }
 
 // processTemplate processes a template with the provided data
@@ -229,27 +583,148 @@ func (s *NotificationService) processTemplate(notification *models.Notification,
 	}
 
 	var tmpl models.Template
-	if err := s.db.First(&tmpl, *notification.TemplateID).Error; err != nil {
+	if err := s.db.Where("tenant_id = ?", notification.TenantID).Preload("Variants").First(&tmpl, *notification.TemplateID).Error; err != nil {
 		return err
 	}
+	if tmpl.Kind != "" && tmpl.Kind != models.MessageTemplate {
+		return fmt.Errorf("template %s is a %s and cannot be sent directly", tmpl.Name, tmpl.Kind)
+	}
 
-	// Parse template
-	t, err := template.New("notification").Parse(tmpl.Content)
+	deps, err := s.ResolveTemplateDependencies(&tmpl)
 	if err != nil {
 		return err
 	}
 
-	// Execute template
-	var buf bytes.Buffer
-	if err := t.Execute(&buf, templateData); err != nil {
+	// Pick the localized variant, falling back to the template's base content
+	subject, content, htmlContent := tmpl.Subject, tmpl.Content, tmpl.HTMLContent
+	renderedLocale := s.config.DefaultLocale
+	if variant := selectVariant(&tmpl, localeChain(notification.Locale, s.config.DefaultLocale)); variant != nil {
+		subject, content, htmlContent = variant.Subject, variant.Content, variant.HTMLContent
+		renderedLocale = variant.Locale
+	}
+
+	formatLocale := notification.Locale
+	if formatLocale == "" {
+		formatLocale = s.config.DefaultLocale
+	}
+	if notification.Category == "" {
+		notification.Category = tmpl.Category
+	}
+	env := newFuncEnv(formatLocale, time.Now())
+	if notification.Type == models.EmailNotification {
+		env.unsubscribeURL = func() (string, error) {
+			return notificationUnsubscribeURL(s.config, notification)
+		}
+	}
+	funcs := env.funcs()
+	data := withDeclaredVariables(tmpl.Variables, templateData)
+
+	sender, err := s.senderFor(notification.TenantID, notification.Type, notification.Channel)
+	if err != nil {
+		return err
+	}
+	format := sender.ContentFormat()
+
+	// Render the body in the format the notification's channel consumes. Markdown
+	// bodies are rendered as Markdown first and then converted for the channel.
+	var message, markdown string
+	if tmpl.Markup == models.MarkdownMarkup {
+		markdown, err = renderTemplate("content", content, MarkdownFormat, data, deps.textContext(funcs, s.templateLimits()))
+		if err != nil {
+			return err
+		}
+		message, err = convertMarkdown(markdown, format)
+	} else {
+		message, err = renderTemplate("content", content, format, data, deps.textContext(funcs, s.templateLimits()))
+	}
+	if err != nil {
 		return err
 	}
 
 	// Update notification with processed content
-	notification.Message = buf.String()
-	if tmpl.Subject != "" {
-		notification.Title = tmpl.Subject
+	notification.Message = message
+	notification.Locale = renderedLocale
+	if subject != "" {
+		title, err := renderTemplate("subject", subject, TextFormat, data, deps.subjectContext(funcs, s.templateLimits()))
+		if err != nil {
+			return err
+		}
+		notification.Title = title
+	}
+
+	// Slack templates may render Block Kit JSON, which is sent in place of any
+	// blocks given in the request's metadata
+	if notification.Type == models.SlackNotification && tmpl.Blocks != "" {
+		rendered, err := renderTemplate("blocks", tmpl.Blocks, SlackBlocksFormat, data, deps.subjectContext(funcs, s.templateLimits()))
+		if err != nil {
+			return err
+		}
+		var blocks interface{}
+		if err := json.Unmarshal([]byte(rendered), &blocks); err != nil {
+			return fmt.Errorf("%w: template %s rendered invalid blocks JSON: %v", ErrInvalidSlackMessage, tmpl.Name, err)
+		}
+		if notification.Metadata == nil {
+			notification.Metadata = make(models.JSON)
+		}
+		notification.Metadata["blocks"] = blocks
+	}
+
+	// HTML email bodies are the only part rendered with html/template. Markdown
+	// bodies provide the HTML part themselves unless the template has its own.
+	if notification.Type == models.EmailNotification {
+		var html string
+		if htmlContent != "" {
+			html, err = renderTemplate("html_content", htmlContent, HTMLFormat, data, deps.htmlContext(funcs, s.templateLimits()))
+		} else if tmpl.Markup == models.MarkdownMarkup {
+			html, err = convertMarkdown(markdown, HTMLFormat)
+		}
+		if err != nil {
+			return err
+		}
+		notification.HTMLMessage = html
+		if strings.TrimSpace(notification.Message) == "" && html != "" {
+			notification.Message = htmlToText(html)
+		}
 	}
 
 	return nil
-} 
\ No newline at end of file
+}
+
+// templateLimits returns the configured resource limits for rendering a template part
+func (s *NotificationService) templateLimits() TemplateLimits {
+	return TemplateLimits{
+		Timeout:        s.config.TemplateTimeout,
+		MaxOutputBytes: s.config.TemplateMaxOutputBytes,
+		MaxIterations:  s.config.TemplateMaxIterations,
+	}
+}
+
+// resolveLocale returns the locale requested for a notification, falling back to the
+// recipient's stored preference in the tenant. It returns "" when neither is known.
+func (s *NotificationService) resolveLocale(tenantID, requested, address string) string {
+	if requested != "" {
+		if locale, err := CanonicalLocale(requested); err == nil {
+			return locale
+		}
+	}
+
+	var recipient models.Recipient
+	if err := s.db.Where("tenant_id = ? AND address = ?", tenantID, address).First(&recipient).Error; err == nil {
+		return recipient.Locale
+	}
+
+	return ""
+}
+
+// withDeclaredVariables defaults every variable declared by a template to an empty
+// string so text/template renders missing values as blanks rather than "<no value>"
+func withDeclaredVariables(variables, templateData models.JSON) models.JSON {
+	data := make(models.JSON, len(variables)+len(templateData))
+	for name := range variables {
+		data[name] = ""
+	}
+	for name, value := range templateData {
+		data[name] = value
+	}
+	return data
+}
@@ -1,6 +1,8 @@
 package services
 
 import (
+	"encoding/json"
+	"reflect"
 	"testing"
 	"time"
 
@@ -74,7 +76,7 @@ func TestNotificationService(t *testing.T) {
 
 	// Test 3: Get notifications
 	t.Run("Get Notifications", func(t *testing.T) {
-		notifications, total, err := service.GetNotifications(10, 0, "", "")
+		notifications, total, err := service.GetNotifications(models.DefaultTenant, 10, 0, "", "")
 		if err != nil {
 			t.Errorf("Failed to get notifications: %v", err)
 		}
@@ -91,7 +93,7 @@ func TestNotificationService(t *testing.T) {
 	// Test 4: Get notification by ID
 	t.Run("Get Notification by ID", func(t *testing.T) {
 		// First, get a notification
-		notifications, _, err := service.GetNotifications(1, 0, "", "")
+		notifications, _, err := service.GetNotifications(models.DefaultTenant, 1, 0, "", "")
 		if err != nil {
 			t.Errorf("Failed to get notifications: %v", err)
 		}
@@ -100,7 +102,7 @@ func TestNotificationService(t *testing.T) {
 			t.Skip("No notifications to test with")
 		}
 
-		notification, err := service.GetNotification(notifications[0].ID)
+		notification, err := service.GetNotification(models.DefaultTenant, notifications[0].ID)
 		if err != nil {
 			t.Errorf("Failed to get notification by ID: %v", err)
 		}
@@ -113,7 +115,7 @@ func TestNotificationService(t *testing.T) {
 	// Test 5: Update notification
 	t.Run("Update Notification", func(t *testing.T) {
 		// First, get a notification
-		notifications, _, err := service.GetNotifications(1, 0, "", "")
+		notifications, _, err := service.GetNotifications(models.DefaultTenant, 1, 0, "", "")
 		if err != nil {
 			t.Errorf("Failed to get notifications: %v", err)
 		}
@@ -122,11 +124,10 @@ func TestNotificationService(t *testing.T) {
 			t.Skip("No notifications to test with")
 		}
 
-		updates := map[string]interface{}{
-			"title": "Updated Title",
-		}
+		title := "Updated Title"
+		updates := &models.NotificationUpdateRequest{Title: &title}
 
-		notification, err := service.UpdateNotification(notifications[0].ID, updates)
+		notification, err := service.UpdateNotification(models.DefaultTenant, notifications[0].ID, updates)
 		if err != nil {
 			t.Errorf("Failed to update notification: %v", err)
 		}
@@ -139,7 +140,7 @@ func TestNotificationService(t *testing.T) {
 	// Test 6: Delete notification
 	t.Run("Delete Notification", func(t *testing.T) {
 		// First, get a notification
-		notifications, _, err := service.GetNotifications(1, 0, "", "")
+		notifications, _, err := service.GetNotifications(models.DefaultTenant, 1, 0, "", "")
 		if err != nil {
 			t.Errorf("Failed to get notifications: %v", err)
 		}
@@ -148,13 +149,13 @@ func TestNotificationService(t *testing.T) {
 			t.Skip("No notifications to test with")
 		}
 
-		err = service.DeleteNotification(notifications[0].ID)
+		err = service.DeleteNotification(models.DefaultTenant, notifications[0].ID)
 		if err != nil {
 			t.Errorf("Failed to delete notification: %v", err)
 		}
 
 		// Verify it's deleted
-		_, err = service.GetNotification(notifications[0].ID)
+		_, err = service.GetNotification(models.DefaultTenant, notifications[0].ID)
 		if err == nil {
 			t.Error("Notification should be deleted")
 		}
@@ -212,4 +213,18 @@ func TestTemplateProcessing(t *testing.T) {
 			t.Errorf("Expected message '%s', got '%s'", expectedMessage, notification.Message)
 		}
 	})
-} 
\ No newline at end of file
+} 
+func TestNotificationUpdatesAllowList(t *testing.T) {
+	body := `{"TenantID": "other", "tenant_id": "other", "CreatedBy": "x", "id": 7, "status": "sent", "type": "email",
+		"slack_ts": "1700000000.000100", "SlackTS": "1700000000.000100", "slack_channel_id": "C1", "slack_scheduled_message_id": "Q1",
+		"title": "Updated Title", "metadata": {"ticket": "42"}}`
+	var req models.NotificationUpdateRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatalf("Failed to decode request: %v", err)
+	}
+
+	expected := map[string]interface{}{"title": "Updated Title", "metadata": models.JSON{"ticket": "42"}}
+	if updates := notificationUpdates(&req); !reflect.DeepEqual(updates, expected) {
+		t.Errorf("Expected only the title and metadata to be updated, got %v", updates)
+	}
+}
@@ -0,0 +1,13 @@
+package services
+
+import "notification-service/internal/models"
+
+// Sender delivers notifications over one channel
+type Sender interface {
+	// Send delivers a notification
+	Send(notification *models.Notification) error
+	// TestConnection checks that the channel is reachable and authenticated
+	TestConnection() error
+	// ContentFormat is the format the sender expects the notification message in
+	ContentFormat() ContentFormat
+}
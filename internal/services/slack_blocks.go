@@ -0,0 +1,365 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"notification-service/internal/models"
+
+	"github.com/slack-go/slack"
+)
+
+// ErrInvalidSlackMessage is returned when a Slack notification's blocks or
+// attachments are malformed or exceed Block Kit limits
+var ErrInvalidSlackMessage = errors.New("invalid Slack message")
+
+// Block Kit limits, from https://api.slack.com/reference/block-kit
+const (
+	maxSlackBlocks          = 50
+	maxSlackAttachments     = 100
+	maxSlackMessageText     = 40000
+	maxSlackBlockID         = 255
+	maxSlackSectionText     = 3000
+	maxSlackSectionFields   = 10
+	maxSlackFieldText       = 2000
+	maxSlackHeaderText      = 150
+	maxSlackActionElements  = 25
+	maxSlackContextElements = 10
+	maxSlackButtonText      = 75
+	maxSlackActionID        = 255
+	maxSlackButtonValue     = 2000
+	maxSlackURL             = 3000
+	maxSlackAltText         = 2000
+)
+
+// slackMessageOptions builds the chat.postMessage options for a notification: its
+// text, plus the Block Kit blocks and legacy attachments given as JSON in its
+// metadata under "blocks" and "attachments", with their action IDs tagged with the
+// notification's ID
+func slackMessageOptions(notification *models.Notification) ([]slack.MsgOption, error) {
+	if utf8.RuneCountInString(notification.Message) > maxSlackMessageText {
+		return nil, fmt.Errorf("%w: text exceeds %d characters", ErrInvalidSlackMessage, maxSlackMessageText)
+	}
+	options := []slack.MsgOption{slack.MsgOptionText(notification.Message, false)}
+
+	if raw, ok := notification.Metadata["blocks"]; ok {
+		raw, err := tagSlackActionIDs(raw, notification.ID)
+		if err != nil {
+			return nil, err
+		}
+		blocks, err := decodeSlackBlocks(raw)
+		if err != nil {
+			return nil, err
+		}
+		if err := validateSlackBlocks(blocks); err != nil {
+			return nil, err
+		}
+		options = append(options, slack.MsgOptionBlocks(blocks...))
+	}
+
+	if raw, ok := notification.Metadata["attachments"]; ok {
+		raw, err := tagSlackActionIDs(raw, notification.ID)
+		if err != nil {
+			return nil, err
+		}
+		attachments, err := decodeSlackAttachments(raw)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, slack.MsgOptionAttachments(attachments...))
+	}
+
+	return options, nil
+}
+
+// validateSlackNotification checks a Slack notification's blocks and attachments,
+// and that an ephemeral one names a user, so problems are reported when the notification is requested rather than sent
+func validateSlackNotification(notification *models.Notification) error {
+	if notification.Type != models.SlackNotification {
+		return nil
+	}
+	if notification.Ephemeral && !isSlackUserRecipient(notification.Recipient) {
+		return fmt.Errorf("%w: ephemeral messages need a recipient given as an email address or Slack user ID", ErrInvalidSlackMessage)
+	}
+	_, err := slackMessageOptions(notification)
+	return err
+}
+
+// rawJSON returns the JSON encoding of a metadata value. Strings are taken to
+// already hold JSON.
+func rawJSON(value interface{}) ([]byte, error) {
+	if s, ok := value.(string); ok {
+		return []byte(s), nil
+	}
+	return json.Marshal(value)
+}
+
+// slackActionID tags an element's action_id with the ID of the notification it is sent
+// in, so an interaction with it is routed back to the notification
+func slackActionID(notificationID uint, actionID string) string {
+	return "n" + strconv.FormatUint(uint64(notificationID), 10) + ":" + actionID
+}
+
+// parseSlackActionID splits an action_id tagged by slackActionID into the
+// notification ID and the action_id the notification gave
+func parseSlackActionID(tagged string) (uint, string, bool) {
+	id, actionID, ok := strings.Cut(strings.TrimPrefix(tagged, "n"), ":")
+	if !ok || !strings.HasPrefix(tagged, "n") {
+		return 0, "", false
+	}
+	notificationID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil || notificationID == 0 {
+		return 0, "", false
+	}
+	return uint(notificationID), actionID, true
+}
+
+// tagSlackActionIDs returns blocks or attachments given as JSON with every action_id
+// tagged with a notification's ID
+func tagSlackActionIDs(value interface{}, notificationID uint) (interface{}, error) {
+	data, err := rawJSON(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSlackMessage, err)
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSlackMessage, err)
+	}
+
+	var tag func(value interface{}) interface{}
+	tag = func(value interface{}) interface{} {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			tagged := make(map[string]interface{}, len(v))
+			for key, item := range v {
+				if actionID, ok := item.(string); ok && key == "action_id" {
+					tagged[key] = slackActionID(notificationID, actionID)
+				} else {
+					tagged[key] = tag(item)
+				}
+			}
+			return tagged
+		case []interface{}:
+			tagged := make([]interface{}, len(v))
+			for i, item := range v {
+				tagged[i] = tag(item)
+			}
+			return tagged
+		}
+		return value
+	}
+	return tag(decoded), nil
+}
+
+// decodeSlackBlocks decodes Block Kit blocks from a JSON array or a {"blocks": [...]}
+// payload, as produced by the Block Kit Builder
+func decodeSlackBlocks(value interface{}) ([]slack.Block, error) {
+	data, err := rawJSON(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSlackMessage, err)
+	}
+
+	var payload struct {
+		Blocks slack.Blocks `json:"blocks"`
+	}
+	if len(data) > 0 && data[0] == '{' {
+		err = json.Unmarshal(data, &payload)
+	} else {
+		err = json.Unmarshal(data, &payload.Blocks)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: blocks: %v", ErrInvalidSlackMessage, err)
+	}
+	return payload.Blocks.BlockSet, nil
+}
+
+// decodeSlackAttachments decodes legacy message attachments from a JSON array
+func decodeSlackAttachments(value interface{}) ([]slack.Attachment, error) {
+	data, err := rawJSON(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSlackMessage, err)
+	}
+
+	var attachments []slack.Attachment
+	if err := json.Unmarshal(data, &attachments); err != nil {
+		return nil, fmt.Errorf("%w: attachments: %v", ErrInvalidSlackMessage, err)
+	}
+	if len(attachments) > maxSlackAttachments {
+		return nil, fmt.Errorf("%w: more than %d attachments", ErrInvalidSlackMessage, maxSlackAttachments)
+	}
+	for _, attachment := range attachments {
+		if err := validateSlackBlocks(attachment.Blocks.BlockSet); err != nil {
+			return nil, err
+		}
+	}
+	return attachments, nil
+}
+
+// validateSlackBlocks checks blocks against the Block Kit limits
+func validateSlackBlocks(blocks []slack.Block) error {
+	if len(blocks) > maxSlackBlocks {
+		return fmt.Errorf("%w: more than %d blocks", ErrInvalidSlackMessage, maxSlackBlocks)
+	}
+
+	blockIDs := make(map[string]bool)
+	for i, block := range blocks {
+		fail := func(format string, args ...interface{}) error {
+			return fmt.Errorf("%w: block %d (%s): %s", ErrInvalidSlackMessage, i, block.BlockType(), fmt.Sprintf(format, args...))
+		}
+
+		if id := blockID(block); id != "" {
+			if len(id) > maxSlackBlockID {
+				return fail("block_id exceeds %d characters", maxSlackBlockID)
+			}
+			if blockIDs[id] {
+				return fail("duplicate block_id %q", id)
+			}
+			blockIDs[id] = true
+		}
+
+		var err error
+		switch b := block.(type) {
+		case *slack.SectionBlock:
+			err = validateSectionBlock(b)
+		case *slack.HeaderBlock:
+			if b.Text == nil || b.Text.Type != slack.PlainTextType {
+				err = errors.New("text must be plain_text")
+			} else {
+				err = checkText("text", b.Text, maxSlackHeaderText)
+			}
+		case *slack.ActionBlock:
+			err = validateActionBlock(b)
+		case *slack.ContextBlock:
+			n := len(b.ContextElements.Elements)
+			if n == 0 || n > maxSlackContextElements {
+				err = fmt.Errorf("must have between 1 and %d elements", maxSlackContextElements)
+			}
+		case *slack.ImageBlock:
+			err = checkImage(b.ImageURL, b.AltText)
+		case *slack.DividerBlock, *slack.FileBlock, *slack.InputBlock, *slack.RichTextBlock:
+		default:
+			err = errors.New("unsupported block type")
+		}
+		if err != nil {
+			return fail("%v", err)
+		}
+	}
+	return nil
+}
+
+// validateSectionBlock checks a section's text and fields
+func validateSectionBlock(b *slack.SectionBlock) error {
+	if b.Text == nil && len(b.Fields) == 0 {
+		return errors.New("text or fields are required")
+	}
+	if b.Text != nil {
+		if err := checkText("text", b.Text, maxSlackSectionText); err != nil {
+			return err
+		}
+	}
+	if len(b.Fields) > maxSlackSectionFields {
+		return fmt.Errorf("more than %d fields", maxSlackSectionFields)
+	}
+	for _, field := range b.Fields {
+		if err := checkText("field", field, maxSlackFieldText); err != nil {
+			return err
+		}
+	}
+	if b.Accessory != nil && b.Accessory.ButtonElement != nil {
+		return checkButton(b.Accessory.ButtonElement)
+	}
+	return nil
+}
+
+// validateActionBlock checks an actions block's interactive elements
+func validateActionBlock(b *slack.ActionBlock) error {
+	if b.Elements == nil || len(b.Elements.ElementSet) == 0 || len(b.Elements.ElementSet) > maxSlackActionElements {
+		return fmt.Errorf("must have between 1 and %d elements", maxSlackActionElements)
+	}
+	for _, element := range b.Elements.ElementSet {
+		switch e := element.(type) {
+		case *slack.ButtonBlockElement:
+			if err := checkButton(e); err != nil {
+				return err
+			}
+		case *slack.UnknownBlockElement:
+			return fmt.Errorf("unsupported element type %q", e.Type)
+		}
+	}
+	return nil
+}
+
+// checkButton checks a button's text, action_id, url and value
+func checkButton(button *slack.ButtonBlockElement) error {
+	if button.Text == nil || button.Text.Type != slack.PlainTextType {
+		return errors.New("button text must be plain_text")
+	}
+	if err := checkText("button text", button.Text, maxSlackButtonText); err != nil {
+		return err
+	}
+	switch {
+	case len(button.ActionID) > maxSlackActionID:
+		return fmt.Errorf("action_id exceeds %d characters", maxSlackActionID)
+	case len(button.URL) > maxSlackURL:
+		return fmt.Errorf("button url exceeds %d characters", maxSlackURL)
+	case len(button.Value) > maxSlackButtonValue:
+		return fmt.Errorf("button value exceeds %d characters", maxSlackButtonValue)
+	}
+	return nil
+}
+
+// checkImage checks an image's URL and alt text
+func checkImage(imageURL, altText string) error {
+	switch {
+	case imageURL == "" || altText == "":
+		return errors.New("image_url and alt_text are required")
+	case len(imageURL) > maxSlackURL:
+		return fmt.Errorf("image_url exceeds %d characters", maxSlackURL)
+	case utf8.RuneCountInString(altText) > maxSlackAltText:
+		return fmt.Errorf("alt_text exceeds %d characters", maxSlackAltText)
+	}
+	return nil
+}
+
+// checkText checks a text object's type and length
+func checkText(name string, text *slack.TextBlockObject, limit int) error {
+	if text.Type != slack.PlainTextType && text.Type != slack.MarkdownType {
+		return fmt.Errorf("%s must be plain_text or mrkdwn", name)
+	}
+	if text.Text == "" {
+		return fmt.Errorf("%s is empty", name)
+	}
+	if utf8.RuneCountInString(text.Text) > limit {
+		return fmt.Errorf("%s exceeds %d characters", name, limit)
+	}
+	return nil
+}
+
+// blockID returns the block_id of a block, if it has one
+func blockID(block slack.Block) string {
+	switch b := block.(type) {
+	case *slack.SectionBlock:
+		return b.BlockID
+	case *slack.HeaderBlock:
+		return b.BlockID
+	case *slack.ActionBlock:
+		return b.BlockID
+	case *slack.ContextBlock:
+		return b.BlockID
+	case *slack.ImageBlock:
+		return b.BlockID
+	case *slack.DividerBlock:
+		return b.BlockID
+	case *slack.InputBlock:
+		return b.BlockID
+	case *slack.FileBlock:
+		return b.BlockID
+	case *slack.RichTextBlock:
+		return b.BlockID
+	}
+	return ""
+}
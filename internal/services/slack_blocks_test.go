@@ -0,0 +1,130 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+
+	"github.com/slack-go/slack"
+)
+
+// metadataFromJSON decodes metadata the way it arrives in a request
+func metadataFromJSON(t *testing.T, data string) models.JSON {
+	t.Helper()
+	var metadata models.JSON
+	if err := json.Unmarshal([]byte(data), &metadata); err != nil {
+		t.Fatalf("Invalid test JSON: %v", err)
+	}
+	return metadata
+}
+
+func TestSlackSenderSendsBlocksFromJSON(t *testing.T) {
+	var form map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true,"channel":"C1","ts":"1700000000.000100"}`))
+	}))
+	defer server.Close()
+
+	sender := &SlackSender{
+		config:  &config.Config{SlackChannel: "#general"},
+		client:  slack.New("xoxb-test", slack.OptionAPIURL(server.URL+"/")),
+		limiter: newSlackRateLimiter(),
+	}
+	notification := &models.Notification{
+		Type:    models.SlackNotification,
+		Message: "Deploy finished",
+		Metadata: metadataFromJSON(t, `{
+			"blocks": [
+				{"type": "header", "text": {"type": "plain_text", "text": "Deploy finished"}},
+				{"type": "section", "text": {"type": "mrkdwn", "text": "*api* is live"}},
+				{"type": "actions", "elements": [
+					{"type": "button", "action_id": "ack", "text": {"type": "plain_text", "text": "Acknowledge"}}
+				]}
+			],
+			"attachments": [{"color": "#36a64f", "text": "All checks passed"}]
+		}`),
+	}
+
+	if err := sender.Send(notification); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+
+	var blocks slack.Blocks
+	if err := json.Unmarshal([]byte(form["blocks"][0]), &blocks); err != nil || len(blocks.BlockSet) != 3 {
+		t.Fatalf("Expected three blocks to be sent, got %v (%v)", form["blocks"], err)
+	}
+	if !strings.Contains(form["attachments"][0], "All checks passed") {
+		t.Errorf("Expected the attachment to be sent, got %v", form["attachments"])
+	}
+}
+
+func TestSlackBlocksValidation(t *testing.T) {
+	long := strings.Repeat("a", 3001)
+	tests := []struct {
+		name   string
+		blocks string
+		valid  bool
+	}{
+		{"valid", `[{"type": "section", "text": {"type": "mrkdwn", "text": "hi"}}]`, true},
+		{"builder payload", `{"blocks": [{"type": "divider"}]}`, true},
+		{"section too long", `[{"type": "section", "text": {"type": "mrkdwn", "text": "` + long + `"}}]`, false},
+		{"header in mrkdwn", `[{"type": "header", "text": {"type": "mrkdwn", "text": "hi"}}]`, false},
+		{"unknown block", `[{"type": "carousel"}]`, false},
+		{"duplicate block_id", `[{"type": "divider", "block_id": "a"}, {"type": "divider", "block_id": "a"}]`, false},
+		{"empty actions", `[{"type": "actions", "elements": []}]`, false},
+		{"image without alt text", `[{"type": "image", "image_url": "https://example.com/a.png"}]`, false},
+		{"not JSON blocks", `"nope"`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var blocks interface{}
+			json.Unmarshal([]byte(tt.blocks), &blocks)
+			notification := &models.Notification{Type: models.SlackNotification, Message: "hi", Metadata: models.JSON{"blocks": blocks}}
+
+			err := validateSlackNotification(notification)
+			if tt.valid && err != nil {
+				t.Errorf("Expected valid blocks, got %v", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidSlackMessage) {
+				t.Errorf("Expected ErrInvalidSlackMessage, got %v", err)
+			}
+		})
+	}
+
+	tooMany := make([]interface{}, maxSlackBlocks+1)
+	for i := range tooMany {
+		tooMany[i] = map[string]interface{}{"type": "divider"}
+	}
+	notification := &models.Notification{Type: models.SlackNotification, Metadata: models.JSON{"blocks": tooMany}}
+	if err := validateSlackNotification(notification); !errors.Is(err, ErrInvalidSlackMessage) {
+		t.Errorf("Expected too many blocks to be rejected, got %v", err)
+	}
+}
+
+func TestRenderSlackBlocksTemplate(t *testing.T) {
+	source := `[{"type": "section", "text": {"type": "mrkdwn", "text": "Hi {{.Name}}"}}]`
+	data := models.JSON{"Name": "Ada \"<@U123>\"\nLovelace"}
+
+	rendered, err := renderTemplate("blocks", source, SlackBlocksFormat, data, nil)
+	if err != nil {
+		t.Fatalf("Failed to render: %v", err)
+	}
+	blocks, err := decodeSlackBlocks(rendered)
+	if err != nil {
+		t.Fatalf("Expected valid JSON, got %v:\n%s", err, rendered)
+	}
+	text := blocks[0].(*slack.SectionBlock).Text.Text
+	if text != "Hi Ada \"&lt;@U123&gt;\"\nLovelace" {
+		t.Errorf("Unexpected text %q", text)
+	}
+}
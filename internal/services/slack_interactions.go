@@ -0,0 +1,305 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+
+	"github.com/slack-go/slack"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrInvalidSlackSignature is returned when an interaction request is not signed
+	// with SLACK_SIGNING_SECRET or a Slack channel's signing_secret, or its timestamp
+	// is too old
+	ErrInvalidSlackSignature = errors.New("invalid Slack request signature")
+	// ErrInvalidSlackPayload is returned when an interaction request has no valid payload
+	ErrInvalidSlackPayload = errors.New("invalid Slack interaction payload")
+)
+
+// slackFollowUpTimeout bounds the message update and webhook forwarding done after
+// an interaction has been acknowledged
+const slackFollowUpTimeout = 10 * time.Second
+
+// slackActionWebhook is the body posted to a notification's action webhook for each action
+type slackActionWebhook struct {
+	Event        string               `json:"event"`
+	Action       models.SlackAction   `json:"action"`
+	Notification *models.Notification `json:"notification"`
+}
+
+// HandleSlackInteraction verifies and processes an interaction request from Slack.
+// Each block action is mapped back to the notification named by its action_id, which
+// must belong to a tenant whose signing secret signed the request, and recorded. The
+// message is then replaced with the notification's response for the action, if it has
+// one, and the action is forwarded to the notification's action webhook. Actions of a
+// replayed request, identified by its trigger_id and action_ts, are recorded and
+// followed up only once.
+func (s *NotificationService) HandleSlackInteraction(header http.Header, body []byte) ([]models.SlackAction, error) {
+	tenants, err := s.slackSigningTenants(header, body)
+	if err != nil {
+		return nil, err
+	}
+	callback, err := parseSlackInteraction(body)
+	if err != nil {
+		return nil, err
+	}
+	if callback.Type != slack.InteractionTypeBlockActions {
+		return nil, nil
+	}
+
+	var (
+		actions       []models.SlackAction
+		notifications = make(map[uint]*models.Notification)
+		taken         = make(map[uint][]models.SlackAction)
+	)
+	for _, blockAction := range callback.ActionCallback.BlockActions {
+		notificationID, actionID, ok := parseSlackActionID(blockAction.ActionID)
+		if !ok {
+			return nil, fmt.Errorf("%w: action_id %q names no notification", ErrInvalidSlackPayload, blockAction.ActionID)
+		}
+		if notifications[notificationID] == nil {
+			var notification models.Notification
+			if err := s.db.Where("tenant_id IN ? AND type = ?", tenants, models.SlackNotification).First(&notification, notificationID).Error; err != nil {
+				return nil, err
+			}
+			notifications[notificationID] = &notification
+		}
+
+		action := models.SlackAction{
+			NotificationID: notificationID,
+			ActionID:       actionID,
+			TriggerID:      callback.TriggerID,
+			ActionTS:       blockAction.ActionTs,
+			BlockID:        blockAction.BlockID,
+			Value:          slackActionValue(blockAction),
+			UserID:         callback.User.ID,
+			UserName:       callback.User.Name,
+			TeamID:         callback.Team.ID,
+		}
+		result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&action)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		actions = append(actions, action)
+		taken[notificationID] = append(taken[notificationID], action)
+	}
+
+	// Slack expects an acknowledgement within three seconds, so the follow-up
+	// requests are made in the background
+	for id, notificationActions := range taken {
+		go s.followUpSlackActions(notifications[id], notificationActions, callback.ResponseURL)
+	}
+
+	return actions, nil
+}
+
+// slackSigner is a signing secret and the tenant whose Slack app it belongs to
+type slackSigner struct {
+	tenantID string
+	secret   string
+}
+
+// slackSigners returns SLACK_SIGNING_SECRET, which signs for the default tenant's
+// app configured from the environment, and the signing_secret of every active Slack
+// channel
+func (s *NotificationService) slackSigners() ([]slackSigner, error) {
+	var signers []slackSigner
+	if s.config.SlackSigningSecret != "" {
+		signers = append(signers, slackSigner{tenantID: models.DefaultTenant, secret: s.config.SlackSigningSecret})
+	}
+
+	var channels []models.Channel
+	if err := s.db.Where("type = ? AND is_active = ?", models.SlackNotification, true).Find(&channels).Error; err != nil {
+		return nil, err
+	}
+	for i := range channels {
+		s.openChannel(&channels[i])
+		if secret := configString(channels[i].Config, "signing_secret"); secret != "" && !isSealedSecret(secret) {
+			signers = append(signers, slackSigner{tenantID: channels[i].TenantID, secret: secret})
+		}
+	}
+	return signers, nil
+}
+
+// slackSigningTenants verifies the signature of an interaction request and returns
+// the tenants whose signing secret it was signed with. Requests are rejected when no
+// signing secret is configured.
+func (s *NotificationService) slackSigningTenants(header http.Header, body []byte) ([]string, error) {
+	signers, err := s.slackSigners()
+	if err != nil {
+		return nil, err
+	}
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("%w: no Slack signing secret is configured", ErrInvalidSlackSignature)
+	}
+
+	var tenants []string
+	for _, signer := range signers {
+		if verifySlackSignature(signer.secret, header, body) == nil {
+			tenants = append(tenants, signer.tenantID)
+		}
+	}
+	if len(tenants) == 0 {
+		return nil, ErrInvalidSlackSignature
+	}
+	return tenants, nil
+}
+
+// followUpSlackActions replaces the original message with the response configured
+// for an action and forwards the actions to the notification's action webhook
+func (s *NotificationService) followUpSlackActions(notification *models.Notification, actions []models.SlackAction, responseURL string) {
+	ctx, cancel := context.WithTimeout(context.Background(), slackFollowUpTimeout)
+	defer cancel()
+
+	var channel *models.Channel
+	if notification.Channel != "" {
+		channel = s.findChannel(notification.TenantID, notification.Channel, models.SlackNotification)
+	}
+	webhookURL := slackActionWebhookURL(s.config, notification.TenantID, channel)
+
+	for _, action := range actions {
+		if text, ok := slackActionResponse(notification, action); ok && responseURL != "" {
+			msg := &slack.WebhookMessage{Text: text, ReplaceOriginal: true}
+			if err := slack.PostWebhookCustomHTTPContext(ctx, responseURL, webhookClient, msg); err != nil {
+				log.Printf("Failed to update Slack message for notification %d: %v", notification.ID, err)
+			}
+		}
+
+		if webhookURL != "" {
+			if err := forwardSlackAction(ctx, webhookURL, notification, action); err != nil {
+				log.Printf("Failed to forward Slack action %s for notification %d: %v", action.ActionID, notification.ID, err)
+			}
+		}
+	}
+}
+
+// slackActionWebhookURL returns where the actions on a tenant's notification are
+// forwarded: the action_webhook_url of its Slack channel, or else, for the default
+// tenant only, SLACK_ACTION_WEBHOOK_URL. Other tenants' actions are not forwarded
+// without a channel setting, so they never reach the environment's webhook.
+func slackActionWebhookURL(cfg *config.Config, tenantID string, channel *models.Channel) string {
+	if channel != nil {
+		if webhookURL := configString(channel.Config, "action_webhook_url"); webhookURL != "" {
+			return webhookURL
+		}
+	}
+	if tenantID == models.DefaultTenant {
+		return cfg.SlackActionWebhookURL
+	}
+	return ""
+}
+
+// webhookClient makes the requests to Slack response URLs and the action webhook
+var webhookClient = &http.Client{Timeout: slackFollowUpTimeout}
+
+// verifySlackSignature checks that an interaction request was signed with secret
+// within the last five minutes
+func verifySlackSignature(secret string, header http.Header, body []byte) error {
+	if secret == "" {
+		return fmt.Errorf("%w: no signing secret", ErrInvalidSlackSignature)
+	}
+	verifier, err := slack.NewSecretsVerifier(header, secret)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSlackSignature, err)
+	}
+	verifier.Write(body)
+	if err := verifier.Ensure(); err != nil {
+		return ErrInvalidSlackSignature
+	}
+	return nil
+}
+
+// parseSlackInteraction decodes the payload of an interaction request
+func parseSlackInteraction(body []byte) (*slack.InteractionCallback, error) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil || form.Get("payload") == "" {
+		return nil, fmt.Errorf("%w: missing payload", ErrInvalidSlackPayload)
+	}
+	var callback slack.InteractionCallback
+	if err := json.Unmarshal([]byte(form.Get("payload")), &callback); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSlackPayload, err)
+	}
+	return &callback, nil
+}
+
+// slackActionValue returns the value chosen with an interactive element: a button's
+// value, or the option, date, time, user or conversation selected
+func slackActionValue(action *slack.BlockAction) string {
+	switch {
+	case action.Value != "":
+		return action.Value
+	case action.SelectedOption.Value != "":
+		return action.SelectedOption.Value
+	case len(action.SelectedOptions) > 0:
+		values := make([]string, len(action.SelectedOptions))
+		for i, option := range action.SelectedOptions {
+			values[i] = option.Value
+		}
+		return strings.Join(values, ",")
+	case action.SelectedDate != "":
+		return action.SelectedDate
+	case action.SelectedTime != "":
+		return action.SelectedTime
+	case action.SelectedUser != "":
+		return action.SelectedUser
+	case action.SelectedConversation != "":
+		return action.SelectedConversation
+	case action.SelectedChannel != "":
+		return action.SelectedChannel
+	}
+	return ""
+}
+
+// slackActionResponse returns the text that replaces a notification's message after
+// an action, given in its metadata under "action_responses" keyed by action_id.
+// "{user}" in the text mentions the user who took the action.
+func slackActionResponse(notification *models.Notification, action models.SlackAction) (string, bool) {
+	responses, ok := notification.Metadata["action_responses"].(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	text, ok := responses[action.ActionID].(string)
+	if !ok || text == "" {
+		return "", false
+	}
+	return strings.ReplaceAll(text, "{user}", "<@"+action.UserID+">"), true
+}
+
+// forwardSlackAction posts an action and the notification it was taken on to a webhook
+func forwardSlackAction(ctx context.Context, webhookURL string, notification *models.Notification, action models.SlackAction) error {
+	body, err := json.Marshal(slackActionWebhook{Event: "slack.action", Action: action, Notification: notification})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+
+	"github.com/slack-go/slack"
+)
+
+// signedSlackRequest returns the headers Slack signs an interaction body with
+func signedSlackRequest(secret string, timestamp time.Time, body []byte) http.Header {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":"))
+	mac.Write(body)
+
+	header := http.Header{}
+	header.Set("X-Slack-Request-Timestamp", ts)
+	header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return header
+}
+
+func TestParseSlackInteraction(t *testing.T) {
+	payload := `{"type":"block_actions","user":{"id":"U123","name":"ada"},"team":{"id":"T1"},
+"container":{"type":"message","channel_id":"C1","message_ts":"1700000000.000100"},
+"response_url":"https://hooks.slack.com/actions/x","trigger_id":"123.456.abc",
+"actions":[{"type":"button","action_id":"n42:approve","block_id":"b1","value":"deploy-981","action_ts":"1700000001.000200"}]}`
+	body := []byte(url.Values{"payload": {payload}}.Encode())
+
+	if err := verifySlackSignature("secret", signedSlackRequest("secret", time.Now(), body), body); err != nil {
+		t.Fatalf("Expected a valid signature, got %v", err)
+	}
+	callback, err := parseSlackInteraction(body)
+	if err != nil {
+		t.Fatalf("Failed to parse interaction: %v", err)
+	}
+	if callback.Type != slack.InteractionTypeBlockActions || callback.Container.MessageTs != "1700000000.000100" || callback.User.ID != "U123" || callback.TriggerID != "123.456.abc" {
+		t.Errorf("Unexpected callback %+v", callback)
+	}
+	if actions := callback.ActionCallback.BlockActions; len(actions) != 1 || actions[0].ActionID != "n42:approve" || slackActionValue(actions[0]) != "deploy-981" || actions[0].ActionTs != "1700000001.000200" {
+		t.Errorf("Unexpected actions %+v", actions)
+	}
+
+	for name, header := range map[string]http.Header{
+		"wrong secret": signedSlackRequest("other", time.Now(), body),
+		"stale":        signedSlackRequest("secret", time.Now().Add(-10*time.Minute), body),
+		"unsigned":     {},
+	} {
+		if err := verifySlackSignature("secret", header, body); !errors.Is(err, ErrInvalidSlackSignature) {
+			t.Errorf("%s: expected ErrInvalidSlackSignature, got %v", name, err)
+		}
+	}
+	if err := verifySlackSignature("", signedSlackRequest("", time.Now(), body), body); !errors.Is(err, ErrInvalidSlackSignature) {
+		t.Errorf("Expected requests to be rejected without a signing secret, got %v", err)
+	}
+
+	if _, err := parseSlackInteraction([]byte("token=x")); !errors.Is(err, ErrInvalidSlackPayload) {
+		t.Errorf("Expected ErrInvalidSlackPayload, got %v", err)
+	}
+}
+
+func TestSlackActionIDs(t *testing.T) {
+	blocks, err := tagSlackActionIDs(`[{"type": "actions", "elements": [{"type": "button", "action_id": "approve"}]}]`, 42)
+	if err != nil {
+		t.Fatalf("Failed to tag action IDs: %v", err)
+	}
+	element := blocks.([]interface{})[0].(map[string]interface{})["elements"].([]interface{})[0].(map[string]interface{})
+	if element["action_id"] != "n42:approve" {
+		t.Errorf("Expected the action_id to be tagged, got %v", element["action_id"])
+	}
+
+	if id, actionID, ok := parseSlackActionID("n42:approve:v2"); !ok || id != 42 || actionID != "approve:v2" {
+		t.Errorf("Unexpected parse %d %q %v", id, actionID, ok)
+	}
+	for _, actionID := range []string{"approve", "n:approve", "nx:approve", "n0:approve", "42:approve"} {
+		if _, _, ok := parseSlackActionID(actionID); ok {
+			t.Errorf("Expected %q to name no notification", actionID)
+		}
+	}
+}
+
+func TestSlackActionValue(t *testing.T) {
+	tests := []struct {
+		action   slack.BlockAction
+		expected string
+	}{
+		{slack.BlockAction{Value: "yes"}, "yes"},
+		{slack.BlockAction{SelectedOption: slack.OptionBlockObject{Value: "p1"}}, "p1"},
+		{slack.BlockAction{SelectedOptions: []slack.OptionBlockObject{{Value: "a"}, {Value: "b"}}}, "a,b"},
+		{slack.BlockAction{SelectedDate: "2024-05-01"}, "2024-05-01"},
+		{slack.BlockAction{SelectedUser: "U1"}, "U1"},
+		{slack.BlockAction{}, ""},
+	}
+	for _, tt := range tests {
+		if value := slackActionValue(&tt.action); value != tt.expected {
+			t.Errorf("Expected %q, got %q", tt.expected, value)
+		}
+	}
+}
+
+func TestSlackActionResponse(t *testing.T) {
+	notification := &models.Notification{Metadata: models.JSON{
+		"action_responses": map[string]interface{}{"approve": "Approved by {user}"},
+	}}
+
+	text, ok := slackActionResponse(notification, models.SlackAction{ActionID: "approve", UserID: "U123"})
+	if !ok || text != "Approved by <@U123>" {
+		t.Errorf("Unexpected response %q, %v", text, ok)
+	}
+	if _, ok := slackActionResponse(notification, models.SlackAction{ActionID: "reject"}); ok {
+		t.Error("Expected no response for an action without one")
+	}
+	if _, ok := slackActionResponse(&models.Notification{}, models.SlackAction{ActionID: "approve"}); ok {
+		t.Error("Expected no response without action_responses")
+	}
+}
+
+func TestForwardSlackAction(t *testing.T) {
+	received := make(chan slackActionWebhook, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body slackActionWebhook
+		json.NewDecoder(r.Body).Decode(&body)
+		received <- body
+	}))
+	defer server.Close()
+
+	notification := &models.Notification{ID: 7, Title: "Deploy approval"}
+	action := models.SlackAction{NotificationID: 7, ActionID: "approve", Value: "deploy-981", UserID: "U123"}
+	if err := forwardSlackAction(context.Background(), server.URL, notification, action); err != nil {
+		t.Fatalf("Failed to forward action: %v", err)
+	}
+
+	body := <-received
+	if body.Event != "slack.action" || body.Action.ActionID != "approve" || body.Action.Value != "deploy-981" || body.Notification.ID != 7 {
+		t.Errorf("Unexpected webhook body %+v", body)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	if err := forwardSlackAction(context.Background(), failing.URL, notification, action); err == nil {
+		t.Error("Expected an error when the webhook fails")
+	}
+}
+
+func TestSlackActionWebhookURL(t *testing.T) {
+	cfg := &config.Config{SlackActionWebhookURL: "https://ops.example.com/actions"}
+	withWebhook := &models.Channel{Name: "deploys", Type: models.SlackNotification, Config: models.JSON{"action_webhook_url": "https://team-a.example.com/actions"}}
+	withoutWebhook := &models.Channel{Name: "alerts", Type: models.SlackNotification, Config: models.JSON{"token": "xoxb-1"}}
+
+	for _, tt := range []struct {
+		name     string
+		tenantID string
+		channel  *models.Channel
+		expected string
+	}{
+		{"default tenant without a channel", models.DefaultTenant, nil, "https://ops.example.com/actions"},
+		{"default tenant's channel without a webhook", models.DefaultTenant, withoutWebhook, "https://ops.example.com/actions"},
+		{"channel webhook", "team-a", withWebhook, "https://team-a.example.com/actions"},
+		{"other tenant's channel without a webhook", "team-a", withoutWebhook, ""},
+		{"other tenant without a channel", "team-a", nil, ""},
+	} {
+		if got := slackActionWebhookURL(cfg, tt.tenantID, tt.channel); got != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.expected, got)
+		}
+	}
+}
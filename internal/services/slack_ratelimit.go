@@ -0,0 +1,278 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// slackTier is a Slack Web API rate limit tier, from https://api.slack.com/docs/rate-limits
+type slackTier struct {
+	Name      string
+	PerMinute int
+	Burst     int
+}
+
+var (
+	slackTier2 = slackTier{Name: "tier2", PerMinute: 20, Burst: 3}
+	slackTier3 = slackTier{Name: "tier3", PerMinute: 50, Burst: 5}
+	slackTier4 = slackTier{Name: "tier4", PerMinute: 100, Burst: 10}
+	// chat.postMessage allows about one message per second per channel, with short bursts
+	slackPostMessageTier = slackTier{Name: "special", PerMinute: 60, Burst: 3}
+)
+
+// slackMethodTiers maps the Web API methods SlackSender calls to their tier. Other
+// methods are treated as tier 3.
+var slackMethodTiers = map[string]slackTier{
+	"auth.test":                   slackTier4,
+	"chat.postMessage":            slackPostMessageTier,
+	"chat.postEphemeral":          slackTier4,
+	"chat.update":                 slackTier3,
+	"chat.delete":                 slackTier3,
+	"chat.scheduleMessage":        slackTier3,
+	"chat.scheduledMessages.list": slackTier3,
+	"chat.deleteScheduledMessage": slackTier3,
+	"conversations.list":          slackTier2,
+	"conversations.open":          slackTier3,
+	"users.lookupByEmail":         slackTier3,
+}
+
+// maxSlackRateLimitRetries is how many times a request answered with HTTP 429 is retried
+const maxSlackRateLimitRetries = 2
+
+// slackBucket paces the requests of one method using the generic cell rate algorithm:
+// a request may be made once the theoretical arrival time, less the burst allowance,
+// has passed
+type slackBucket struct {
+	method        string
+	channel       string
+	tier          slackTier
+	tat           time.Time
+	retryAfterEnd time.Time
+}
+
+// interval is the time between requests at the tier's sustained rate
+func (b *slackBucket) interval() time.Duration {
+	return time.Minute / time.Duration(b.tier.PerMinute)
+}
+
+// reserve returns how long to wait before making a request and claims the slot,
+// unless the wait would exceed maxWait
+func (b *slackBucket) reserve(now time.Time, maxWait time.Duration) (time.Duration, bool) {
+	tat := b.tat
+	if tat.Before(now) {
+		tat = now
+	}
+	at := tat.Add(-time.Duration(b.tier.Burst-1) * b.interval())
+	if at.Before(b.retryAfterEnd) {
+		at = b.retryAfterEnd
+	}
+
+	wait := at.Sub(now)
+	if wait < 0 {
+		wait = 0
+	}
+	if wait > maxWait {
+		return wait, false
+	}
+
+	if tat.Before(at) {
+		tat = at
+	}
+	b.tat = tat.Add(b.interval())
+	return wait, true
+}
+
+// available returns how many requests may be made right away
+func (b *slackBucket) available(now time.Time) int {
+	if now.Before(b.retryAfterEnd) {
+		return 0
+	}
+	if !b.tat.After(now) {
+		return b.tier.Burst
+	}
+	used := int((b.tat.Sub(now) + b.interval() - 1) / b.interval())
+	if used >= b.tier.Burst {
+		return 0
+	}
+	return b.tier.Burst - used
+}
+
+// slackRateLimiter paces the Web API requests made for one workspace
+type slackRateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*slackBucket
+	// lastUsed is when the limiter was last looked up; guarded by slackLimitersMu
+	lastUsed time.Time
+}
+
+// SlackThrottle is the rate limit state of one Web API method, or for
+// chat.postMessage of one method and channel
+type SlackThrottle struct {
+	Method         string     `json:"method"`
+	Channel        string     `json:"channel,omitempty"`
+	Tier           string     `json:"tier"`
+	PerMinute      int        `json:"per_minute"`
+	Burst          int        `json:"burst"`
+	Available      int        `json:"available"`
+	ThrottledUntil *time.Time `json:"throttled_until,omitempty"`
+}
+
+// newSlackRateLimiter creates a limiter with no requests made
+func newSlackRateLimiter() *slackRateLimiter {
+	return &slackRateLimiter{buckets: make(map[string]*slackBucket)}
+}
+
+// slackLimiterIdleTimeout is how long a limiter goes unused before it is dropped. By
+// then every bucket has refilled, so a new limiter paces the same as the old one.
+const slackLimiterIdleTimeout = time.Hour
+
+var (
+	slackLimitersMu sync.Mutex
+	// slackLimiters holds the limiter of each workspace, keyed by slackLimiterKey
+	slackLimiters     = make(map[string]*slackRateLimiter)
+	slackLimiterSwept time.Time
+)
+
+// slackLimiterKey identifies the limiter of a bot token without keeping the token
+func slackLimiterKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// slackLimiterFor returns the limiter shared by every sender using the token with the
+// given slackLimiterKey. Slack applies rate limits per app and workspace, which a bot
+// token identifies. Limiters unused for slackLimiterIdleTimeout are dropped.
+func slackLimiterFor(key string) *slackRateLimiter {
+	slackLimitersMu.Lock()
+	defer slackLimitersMu.Unlock()
+
+	now := time.Now()
+	if now.Sub(slackLimiterSwept) > time.Minute {
+		for k, limiter := range slackLimiters {
+			if now.Sub(limiter.lastUsed) > slackLimiterIdleTimeout {
+				delete(slackLimiters, k)
+			}
+		}
+		slackLimiterSwept = now
+	}
+
+	limiter, ok := slackLimiters[key]
+	if !ok {
+		limiter = newSlackRateLimiter()
+		slackLimiters[key] = limiter
+	}
+	limiter.lastUsed = now
+	return limiter
+}
+
+// bucket returns the bucket for a method and channel, creating it if needed.
+// chat.postMessage is limited per channel; other methods across the workspace. The
+// caller must hold l.mu.
+func (l *slackRateLimiter) bucket(method, channel string) *slackBucket {
+	if method != "chat.postMessage" {
+		channel = ""
+	}
+	key := method + " " + channel
+	b, ok := l.buckets[key]
+	if !ok {
+		tier, ok := slackMethodTiers[method]
+		if !ok {
+			tier = slackTier3
+		}
+		b = &slackBucket{method: method, channel: channel, tier: tier}
+		l.buckets[key] = b
+	}
+	return b
+}
+
+// wait blocks until a request may be made. Rather than wait longer than maxWait it
+// returns a *slack.RateLimitedError, so the notification can be requeued.
+func (l *slackRateLimiter) wait(method, channel string, maxWait time.Duration) error {
+	l.mu.Lock()
+	delay, ok := l.bucket(method, channel).reserve(time.Now(), maxWait)
+	l.mu.Unlock()
+
+	if !ok {
+		return &slack.RateLimitedError{RetryAfter: delay}
+	}
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	return nil
+}
+
+// retryAfter holds back requests for a method until Slack's Retry-After has passed
+func (l *slackRateLimiter) retryAfter(method, channel string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.bucket(method, channel)
+	if end := time.Now().Add(d); end.After(b.retryAfterEnd) {
+		b.retryAfterEnd = end
+	}
+}
+
+// state returns the throttle state of every method and channel requested so far
+func (l *slackRateLimiter) state() []SlackThrottle {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	state := make([]SlackThrottle, 0, len(l.buckets))
+	for _, b := range l.buckets {
+		throttle := SlackThrottle{
+			Method:    b.method,
+			Channel:   b.channel,
+			Tier:      b.tier.Name,
+			PerMinute: b.tier.PerMinute,
+			Burst:     b.tier.Burst,
+			Available: b.available(now),
+		}
+		if b.retryAfterEnd.After(now) {
+			until := b.retryAfterEnd
+			throttle.ThrottledUntil = &until
+		}
+		state = append(state, throttle)
+	}
+	sort.Slice(state, func(i, j int) bool {
+		if state[i].Method != state[j].Method {
+			return state[i].Method < state[j].Method
+		}
+		return state[i].Channel < state[j].Channel
+	})
+	return state
+}
+
+// call makes a Web API request once the rate limiter allows it. Requests answered
+// with HTTP 429 hold back the method for the Retry-After Slack gives and are retried
+// when that is within SLACK_MAX_RATE_LIMIT_WAIT.
+func (s *SlackSender) call(method, channel string, request func() error) error {
+	limiter := s.rateLimiter()
+	for attempt := 0; ; attempt++ {
+		if err := limiter.wait(method, channel, s.config.SlackMaxRateLimitWait); err != nil {
+			return err
+		}
+
+		err := request()
+		var rateLimited *slack.RateLimitedError
+		if !errors.As(err, &rateLimited) || attempt == maxSlackRateLimitRetries {
+			return err
+		}
+		limiter.retryAfter(method, channel, rateLimited.RetryAfter)
+	}
+}
+
+// slackRetryAfter reports how long to wait before retrying a notification whose send
+// was rate limited
+func slackRetryAfter(err error) (time.Duration, bool) {
+	var rateLimited *slack.RateLimitedError
+	if errors.As(err, &rateLimited) {
+		return rateLimited.RetryAfter, true
+	}
+	return 0, false
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+)
+
+func TestSlackBucketPacesRequests(t *testing.T) {
+	b := &slackBucket{tier: slackTier{PerMinute: 60, Burst: 3}}
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if wait, ok := b.reserve(now, 0); !ok || wait != 0 {
+			t.Fatalf("Expected request %d to be allowed by the burst, got wait %v", i, wait)
+		}
+	}
+	if available := b.available(now); available != 0 {
+		t.Errorf("Expected the burst to be used up, got %d available", available)
+	}
+	if wait, ok := b.reserve(now, 0); ok || wait != time.Second {
+		t.Errorf("Expected the next request to wait a second, got %v %v", wait, ok)
+	}
+	if wait, ok := b.reserve(now, 5*time.Second); !ok || wait != time.Second {
+		t.Errorf("Expected the next request to be reserved a second out, got %v %v", wait, ok)
+	}
+	if available := b.available(now.Add(time.Minute)); available != 3 {
+		t.Errorf("Expected the burst to refill, got %d available", available)
+	}
+
+	b.retryAfterEnd = now.Add(time.Minute + 10*time.Second)
+	if wait, ok := b.reserve(now.Add(time.Minute), 30*time.Second); !ok || wait != 10*time.Second {
+		t.Errorf("Expected Retry-After to hold back requests, got %v %v", wait, ok)
+	}
+}
+
+func TestSlackRateLimiterBuckets(t *testing.T) {
+	limiter := newSlackRateLimiter()
+	for _, channel := range []string{"C1", "C1", "C2"} {
+		if err := limiter.wait("chat.postMessage", channel, 0); err != nil {
+			t.Fatalf("Failed to wait: %v", err)
+		}
+	}
+	limiter.wait("users.lookupByEmail", "ada@example.com", 0)
+	limiter.retryAfter("chat.update", "C1", time.Minute)
+
+	state := limiter.state()
+	if len(state) != 4 {
+		t.Fatalf("Expected 4 buckets, got %+v", state)
+	}
+	if s := state[0]; s.Method != "chat.postMessage" || s.Channel != "C1" || s.Tier != "special" || s.Available != 1 {
+		t.Errorf("Unexpected chat.postMessage state %+v", s)
+	}
+	if s := state[1]; s.Channel != "C2" || s.Available != 2 {
+		t.Errorf("Expected chat.postMessage to be limited per channel, got %+v", s)
+	}
+	if s := state[2]; s.Method != "chat.update" || s.Channel != "" || s.ThrottledUntil == nil || s.Available != 0 {
+		t.Errorf("Expected chat.update to be throttled, got %+v", s)
+	}
+	if s := state[3]; s.Method != "users.lookupByEmail" || s.Channel != "" || s.Tier != "tier3" {
+		t.Errorf("Unexpected users.lookupByEmail state %+v", s)
+	}
+
+	if err := limiter.wait("chat.update", "C1", time.Second); err == nil {
+		t.Error("Expected a wait beyond the limit to fail")
+	} else if retryAfter, ok := slackRetryAfter(err); !ok || retryAfter <= 50*time.Second {
+		t.Errorf("Expected a rate limit error to retry after about a minute, got %v", err)
+	}
+}
+
+func TestSlackSenderRetriesRateLimitedRequests(t *testing.T) {
+	api := newFakeSlackAPI(t, map[string]string{
+		"chat.postMessage": `{"ok":true,"channel":"C123","ts":"1700000000.000100"}`,
+	})
+	api.rateLimited["chat.postMessage"] = 1
+	sender := api.sender(&config.Config{SlackChannel: "#general", SlackMaxRateLimitWait: 5 * time.Second})
+
+	notification := &models.Notification{Type: models.SlackNotification, Message: "Deploy finished"}
+	if err := sender.Send(notification); err != nil {
+		t.Fatalf("Expected the rate limited request to be retried, got %v", err)
+	}
+	if posts := api.count("chat.postMessage"); posts != 2 {
+		t.Errorf("Expected 2 requests, got %d", posts)
+	}
+
+	api.rateLimited["chat.postMessage"] = 1
+	sender.config.SlackMaxRateLimitWait = 0
+	err := sender.Send(&models.Notification{Type: models.SlackNotification, Message: "Deploy started"})
+	if retryAfter, ok := slackRetryAfter(err); !ok || retryAfter <= 0 || retryAfter > time.Second {
+		t.Errorf("Expected the send to be requeued for Retry-After, got %v", err)
+	}
+	if state := sender.Throttle(); len(state) != 1 || state[0].Channel != "#general" || state[0].ThrottledUntil == nil {
+		t.Errorf("Expected chat.postMessage to be throttled, got %+v", state)
+	}
+}
+
+func TestSlackLimiterForEvictsIdleLimiters(t *testing.T) {
+	key := slackLimiterKey("xoxb-idle")
+	limiter := slackLimiterFor(key)
+	if slackLimiterFor(key) != limiter {
+		t.Fatal("Expected the same token to share a limiter")
+	}
+
+	slackLimitersMu.Lock()
+	limiter.lastUsed = time.Now().Add(-2 * slackLimiterIdleTimeout)
+	slackLimiterSwept = time.Time{}
+	slackLimitersMu.Unlock()
+
+	slackLimiterFor(slackLimiterKey("xoxb-other"))
+	slackLimitersMu.Lock()
+	defer slackLimitersMu.Unlock()
+	if slackLimiters[key] != nil {
+		t.Error("Expected an idle limiter to be dropped")
+	}
+}
@@ -1,7 +1,13 @@
 package services
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
 	"notification-service/internal/config"
 	"notification-service/internal/models"
 
@@ -12,56 +18,295 @@ import (
 type SlackSender struct {
 	config *config.Config
 	client *slack.Client
+	users  *slackUserCache
+	// limiterKey names the shared limiter of the sender's workspace; limiter, when
+	// set, is used in its place
+	limiterKey string
+	limiter    *slackRateLimiter
+	// token and apiURL are used for the Web API calls made without client
+	token  string
+	apiURL string
+	// channelName is the configured channel the sender was built from, if any
+	channelName string
 }
 
 // NewSlackSender creates a new Slack sender
 func NewSlackSender(config *config.Config) *SlackSender {
 	return &SlackSender{
-		config: config,
-		client: slack.New(config.SlackToken),
+		config:     config,
+		client:     slack.New(config.SlackToken),
+		users:      newSlackUserCache(config.SlackUserCacheTTL),
+		limiterKey: slackLimiterKey(config.SlackToken),
+		token:      config.SlackToken,
+		apiURL:     slack.APIURL,
 	}
 }
 
-// Send sends a Slack notification
-func (s *SlackSender) Send(notification *models.Notification) error {
-	// Determine channel
-	channel := s.config.SlackChannel
-	if notification.Channel != "" {
-		channel = notification.Channel
+// newChannelSlackSender creates a Slack sender for a configured channel, using the
+// channel's token and default Slack channel settings in place of SLACK_TOKEN and
+// SLACK_CHANNEL when set
+func newChannelSlackSender(cfg *config.Config, channel *models.Channel) *SlackSender {
+	channelConfig := *cfg
+	if token := configString(channel.Config, "token"); token != "" {
+		channelConfig.SlackToken = token
 	}
-
-	// Create message options
-	options := []slack.MsgOption{
-		slack.MsgOptionText(notification.Message, false),
+	if slackChannel := configString(channel.Config, "channel"); slackChannel != "" {
+		channelConfig.SlackChannel = slackChannel
 	}
 
-	// Add blocks if metadata contains them
-	if blocks, exists := notification.Metadata["blocks"]; exists {
-		if blockArray, ok := blocks.([]slack.Block); ok {
-			options = append(options, slack.MsgOptionBlocks(blockArray...))
-		}
+	sender := NewSlackSender(&channelConfig)
+	sender.channelName = channel.Name
+	return sender
+}
+
+// Send sends a Slack notification, replying in the thread given by SlackThreadTS
+// when set, and records the channel and timestamp of the posted message. A recipient
+// given as an email address or Slack user ID is sent a direct message, or for an
+// ephemeral notification is shown the message in the channel.
+func (s *SlackSender) Send(notification *models.Notification) error {
+	options, err := s.messageOptions(notification)
+	if err != nil {
+		return err
+	}
+	if notification.Ephemeral {
+		return s.postEphemeral(notification, options)
 	}
 
-	// Add attachments if metadata contains them
-	if attachments, exists := notification.Metadata["attachments"]; exists {
-		if attachmentArray, ok := attachments.([]slack.Attachment); ok {
-			options = append(options, slack.MsgOptionAttachments(attachmentArray...))
-		}
+	channel, err := s.targetChannel(notification)
+	if err != nil {
+		return err
 	}
 
 	// Send message
-	_, _, err := s.client.PostMessage(channel, options...)
+	var channelID, ts string
+	err = s.call("chat.postMessage", channel, func() (err error) {
+		channelID, ts, err = s.client.PostMessage(channel, options...)
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("failed to send Slack message: %w", err)
 	}
 
+	notification.SlackChannelID = channelID
+	notification.SlackTS = ts
+	return nil
+}
+
+// messageOptions returns the message options for a notification, threaded under
+// SlackThreadTS when set
+func (s *SlackSender) messageOptions(notification *models.Notification) ([]slack.MsgOption, error) {
+	options, err := slackMessageOptions(notification)
+	if err != nil {
+		return nil, err
+	}
+	if notification.SlackThreadTS != "" {
+		options = append(options, slack.MsgOptionTS(notification.SlackThreadTS))
+	}
+	return options, nil
+}
+
+// targetChannel returns the channel a notification is posted to: the channel of its
+// thread, a DM with its recipient, its channel, or else SLACK_CHANNEL
+func (s *SlackSender) targetChannel(notification *models.Notification) (string, error) {
+	if notification.SlackChannelID != "" {
+		return notification.SlackChannelID, nil
+	}
+	dm, err := s.directMessageChannel(notification.Recipient)
+	if err != nil || dm != "" {
+		return dm, err
+	}
+	return s.slackChannel(notification), nil
+}
+
+// slackChannel returns the Slack channel a notification names, or else SLACK_CHANNEL
+// or the default Slack channel of the configured channel the sender was built from
+func (s *SlackSender) slackChannel(notification *models.Notification) string {
+	if notification.Channel != "" && notification.Channel != s.channelName {
+		return notification.Channel
+	}
+	return s.config.SlackChannel
+}
+
+// postEphemeral shows a notification to its recipient only, with chat.postEphemeral,
+// in the channel of its thread, its channel or else SLACK_CHANNEL. Ephemeral messages
+// cannot be edited or deleted, so no timestamp is recorded.
+func (s *SlackSender) postEphemeral(notification *models.Notification, options []slack.MsgOption) error {
+	userID, err := s.slackUserID(notification.Recipient)
+	if err != nil {
+		return err
+	}
+	if userID == "" {
+		return fmt.Errorf("%w: ephemeral messages need a recipient given as an email address or Slack user ID", ErrInvalidSlackMessage)
+	}
+
+	channel := s.slackChannel(notification)
+	if notification.SlackChannelID != "" {
+		channel = notification.SlackChannelID
+	}
+
+	err = s.call("chat.postEphemeral", channel, func() error {
+		_, err := s.client.PostEphemeral(channel, userID, options...)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send ephemeral Slack message: %w", err)
+	}
+	return nil
+}
+
+// Schedule hands a notification to Slack with chat.scheduleMessage, to be posted at
+// its ScheduledAt even if our scheduler is not running, and records the channel and
+// scheduled message ID
+func (s *SlackSender) Schedule(notification *models.Notification) error {
+	options, err := s.messageOptions(notification)
+	if err != nil {
+		return err
+	}
+	channel, err := s.targetChannel(notification)
+	if err != nil {
+		return err
+	}
+
+	postAt := strconv.FormatInt(notification.ScheduledAt.Unix(), 10)
+	var channelID, id string
+	err = s.call("chat.scheduleMessage", channel, func() (err error) {
+		channelID, id, err = s.scheduleMessage(channel, postAt, options)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to schedule Slack message: %w", err)
+	}
+	if id == "" {
+		return fmt.Errorf("chat.scheduleMessage returned no scheduled_message_id for %s", channelID)
+	}
+
+	notification.SlackChannelID = channelID
+	notification.SlackScheduledMessageID = id
+	return nil
+}
+
+// slackAPIClient makes the Web API requests the Slack client has no method for
+var slackAPIClient = &http.Client{Timeout: 30 * time.Second}
+
+// scheduleMessageResponse is the response of chat.scheduleMessage
+type scheduleMessageResponse struct {
+	OK                 bool   `json:"ok"`
+	Error              string `json:"error"`
+	Channel            string `json:"channel"`
+	ScheduledMessageID string `json:"scheduled_message_id"`
+}
+
+// scheduleMessage calls chat.scheduleMessage and returns the channel ID and the
+// scheduled_message_id, which the Slack client's ScheduleMessage drops
+func (s *SlackSender) scheduleMessage(channel, postAt string, options []slack.MsgOption) (string, string, error) {
+	options = append(options[:len(options):len(options)], slack.MsgOptionSchedule(postAt))
+	endpoint, values, err := slack.UnsafeApplyMsgOptions(s.token, channel, s.apiURL, options...)
+	if err != nil {
+		return "", "", err
+	}
+	values.Del("token")
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(values.Encode()))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	resp, err := slackAPIClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter, _ := strconv.ParseInt(resp.Header.Get("Retry-After"), 10, 64)
+		return "", "", &slack.RateLimitedError{RetryAfter: time.Duration(retryAfter) * time.Second}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("chat.scheduleMessage returned status %d", resp.StatusCode)
+	}
+	var response scheduleMessageResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", "", err
+	}
+	if !response.OK {
+		return "", "", slack.SlackErrorResponse{Err: response.Error}
+	}
+	return response.Channel, response.ScheduledMessageID, nil
+}
+
+// Unschedule cancels a message scheduled with chat.scheduleMessage using
+// chat.deleteScheduledMessage. Messages already posted or cancelled are not an error.
+func (s *SlackSender) Unschedule(notification *models.Notification) error {
+	err := s.call("chat.deleteScheduledMessage", notification.SlackChannelID, func() error {
+		_, err := s.client.DeleteScheduledMessage(&slack.DeleteScheduledMessageParameters{
+			Channel:            notification.SlackChannelID,
+			ScheduledMessageID: notification.SlackScheduledMessageID,
+		})
+		return err
+	})
+	if err != nil && err.Error() != "invalid_scheduled_message_id" {
+		return fmt.Errorf("failed to cancel scheduled Slack message: %w", err)
+	}
+	return nil
+}
+
+// Update edits a posted Slack message to match the notification with chat.update
+func (s *SlackSender) Update(notification *models.Notification) error {
+	options, err := slackMessageOptions(notification)
+	if err != nil {
+		return err
+	}
+
+	err = s.call("chat.update", notification.SlackChannelID, func() error {
+		_, _, _, err := s.client.UpdateMessage(notification.SlackChannelID, notification.SlackTS, options...)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update Slack message: %w", err)
+	}
 	return nil
 }
 
+// Delete retracts a posted Slack message with chat.delete. Messages that are
+// already gone are not an error.
+func (s *SlackSender) Delete(notification *models.Notification) error {
+	err := s.call("chat.delete", notification.SlackChannelID, func() error {
+		_, _, err := s.client.DeleteMessage(notification.SlackChannelID, notification.SlackTS)
+		return err
+	})
+	if err != nil && err.Error() != "message_not_found" {
+		return fmt.Errorf("failed to delete Slack message: %w", err)
+	}
+	return nil
+}
+
+// rateLimiter returns the limiter that paces the sender's requests
+func (s *SlackSender) rateLimiter() *slackRateLimiter {
+	if s.limiter != nil {
+		return s.limiter
+	}
+	return slackLimiterFor(s.limiterKey)
+}
+
+// Throttle returns the rate limit state of the Web API methods called for the
+// workspace
+func (s *SlackSender) Throttle() []SlackThrottle {
+	return s.rateLimiter().state()
+}
+
+// ContentFormat reports that Slack messages are Slack mrkdwn
+func (s *SlackSender) ContentFormat() ContentFormat {
+	return SlackFormat
+}
+
 // TestConnection tests the Slack connection
 func (s *SlackSender) TestConnection() error {
 	// Test authentication
-	_, err := s.client.AuthTest()
+	err := s.call("auth.test", "", func() error {
+		_, err := s.client.AuthTest()
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("failed to authenticate with Slack: %w", err)
 	}
@@ -71,12 +316,16 @@ func (s *SlackSender) TestConnection() error {
 
 // GetChannels retrieves available Slack channels
 func (s *SlackSender) GetChannels() ([]slack.Channel, error) {
-	channels, _, err := s.client.GetConversations(&slack.GetConversationsParameters{
-		Types: []string{"public_channel", "private_channel"},
+	var channels []slack.Channel
+	err := s.call("conversations.list", "", func() (err error) {
+		channels, _, err = s.client.GetConversations(&slack.GetConversationsParameters{
+			Types: []string{"public_channel", "private_channel"},
+		})
+		return err
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get Slack channels: %w", err)
 	}
 
 	return channels, nil
-} 
\ No newline at end of file
+}
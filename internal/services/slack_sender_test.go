@@ -0,0 +1,300 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+
+	"github.com/slack-go/slack"
+)
+
+// slackCall is a Web API request received by fakeSlackAPI
+type slackCall struct {
+	method string
+	form   url.Values
+	header http.Header
+}
+
+// fakeSlackAPI is a Slack Web API stand-in that answers each method with a canned response
+type fakeSlackAPI struct {
+	server    *httptest.Server
+	responses map[string]string
+	// rateLimited is how many requests to each method are answered with HTTP 429
+	rateLimited map[string]int
+
+	mu    sync.Mutex
+	calls []slackCall
+}
+
+func newFakeSlackAPI(t *testing.T, responses map[string]string) *fakeSlackAPI {
+	api := &fakeSlackAPI{responses: responses, rateLimited: make(map[string]int)}
+	api.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		method := strings.TrimPrefix(r.URL.Path, "/")
+
+		api.mu.Lock()
+		api.calls = append(api.calls, slackCall{method: method, form: r.Form, header: r.Header})
+		limited := api.rateLimited[method] > 0
+		if limited {
+			api.rateLimited[method]--
+		}
+		api.mu.Unlock()
+
+		if limited {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		response, ok := api.responses[method]
+		if !ok {
+			response = `{"ok":true}`
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(api.server.Close)
+	return api
+}
+
+// sender returns a SlackSender that talks to the fake API
+func (api *fakeSlackAPI) sender(cfg *config.Config) *SlackSender {
+	sender := NewSlackSender(cfg)
+	sender.client = slack.New("xoxb-test", slack.OptionAPIURL(api.server.URL+"/"))
+	sender.token, sender.apiURL = "xoxb-test", api.server.URL+"/"
+	sender.limiter = newSlackRateLimiter()
+	return sender
+}
+
+// lastCall returns the most recent request for a method
+func (api *fakeSlackAPI) lastCall(method string) *slackCall {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	for i := len(api.calls) - 1; i >= 0; i-- {
+		if api.calls[i].method == method {
+			return &api.calls[i]
+		}
+	}
+	return nil
+}
+
+// count returns the number of requests for a method
+func (api *fakeSlackAPI) count(method string) int {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	n := 0
+	for _, call := range api.calls {
+		if call.method == method {
+			n++
+		}
+	}
+	return n
+}
+
+func TestSlackSenderThreadsUpdatesAndDeletes(t *testing.T) {
+	api := newFakeSlackAPI(t, map[string]string{
+		"chat.postMessage": `{"ok":true,"channel":"C123","ts":"1700000000.000200"}`,
+		"chat.delete":      `{"ok":false,"error":"message_not_found"}`,
+	})
+	sender := api.sender(&config.Config{SlackChannel: "#general"})
+
+	notification := &models.Notification{Type: models.SlackNotification, Message: "Investigating", Channel: "#incidents", SlackThreadTS: "1700000000.000100"}
+	if err := sender.Send(notification); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	if notification.SlackChannelID != "C123" || notification.SlackTS != "1700000000.000200" {
+		t.Errorf("Expected the posted message to be recorded, got %s %s", notification.SlackChannelID, notification.SlackTS)
+	}
+	post := api.lastCall("chat.postMessage")
+	if post.form.Get("channel") != "#incidents" || post.form.Get("thread_ts") != "1700000000.000100" {
+		t.Errorf("Expected a threaded reply in #incidents, got %v", post.form)
+	}
+
+	notification.Message = "Resolved"
+	if err := sender.Update(notification); err != nil {
+		t.Fatalf("Failed to update: %v", err)
+	}
+	update := api.lastCall("chat.update")
+	if update == nil || update.form.Get("channel") != "C123" || update.form.Get("ts") != "1700000000.000200" || update.form.Get("text") != "Resolved" {
+		t.Errorf("Unexpected chat.update request %+v", update)
+	}
+
+	if err := sender.Delete(notification); err != nil {
+		t.Errorf("Expected an already deleted message to be ignored, got %v", err)
+	}
+	api.responses["chat.delete"] = `{"ok":false,"error":"cant_delete_message"}`
+	if err := sender.Delete(notification); err == nil {
+		t.Error("Expected other chat.delete errors to be reported")
+	}
+}
+
+func TestSlackSenderDirectMessages(t *testing.T) {
+	api := newFakeSlackAPI(t, map[string]string{
+		"users.lookupByEmail": `{"ok":true,"user":{"id":"U0123456789"}}`,
+		"conversations.open":  `{"ok":true,"channel":{"id":"D0123456789"}}`,
+		"chat.postMessage":    `{"ok":true,"channel":"D0123456789","ts":"1700000000.000100"}`,
+	})
+	sender := api.sender(&config.Config{SlackChannel: "#general", SlackUserCacheTTL: time.Hour})
+
+	for _, recipient := range []string{"Ada <Ada@example.com>", "ada@example.com", "U0123456789"} {
+		notification := &models.Notification{Type: models.SlackNotification, Message: "Your report is ready", Recipient: recipient}
+		if err := sender.Send(notification); err != nil {
+			t.Fatalf("Failed to send to %s: %v", recipient, err)
+		}
+		if channel := api.lastCall("chat.postMessage").form.Get("channel"); channel != "D0123456789" {
+			t.Errorf("Expected a DM for %s, got channel %s", recipient, channel)
+		}
+		if users := api.lastCall("conversations.open").form.Get("users"); users != "U0123456789" {
+			t.Errorf("Expected a DM opened with U0123456789, got %s", users)
+		}
+	}
+
+	if lookups := api.count("users.lookupByEmail"); lookups != 1 {
+		t.Errorf("Expected the email lookup to be cached, got %d lookups", lookups)
+	}
+
+	notification := &models.Notification{Type: models.SlackNotification, Message: "Hi", Recipient: "#ops"}
+	if err := sender.Send(notification); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	if channel := api.lastCall("chat.postMessage").form.Get("channel"); channel != "#general" {
+		t.Errorf("Expected other recipients to use the channel, got %s", channel)
+	}
+
+	api.responses["users.lookupByEmail"] = `{"ok":false,"error":"users_not_found"}`
+	notification = &models.Notification{Type: models.SlackNotification, Message: "Hi", Recipient: "nobody@example.com"}
+	if err := sender.Send(notification); err == nil || !strings.Contains(err.Error(), "users_not_found") {
+		t.Errorf("Expected an unknown email to fail, got %v", err)
+	}
+}
+
+func TestSlackUserCacheExpires(t *testing.T) {
+	cache := newSlackUserCache(time.Minute)
+	now := time.Now()
+	cache.put("ada@example.com", "U0123456789", now)
+
+	if id, ok := cache.get("ada@example.com", now.Add(30*time.Second)); !ok || id != "U0123456789" {
+		t.Errorf("Expected a cached user, got %q %v", id, ok)
+	}
+	if _, ok := cache.get("ada@example.com", now.Add(2*time.Minute)); ok {
+		t.Error("Expected the entry to expire")
+	}
+}
+
+func TestSlackSenderSchedulesMessages(t *testing.T) {
+	postAt := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+	api := newFakeSlackAPI(t, map[string]string{
+		"chat.scheduleMessage":        `{"ok":true,"channel":"C123","scheduled_message_id":"Q2","post_at":"` + strconv.FormatInt(postAt.Unix(), 10) + `"}`,
+		"chat.deleteScheduledMessage": `{"ok":false,"error":"invalid_scheduled_message_id"}`,
+	})
+	sender := api.sender(&config.Config{SlackChannel: "#general"})
+
+	notification := &models.Notification{Type: models.SlackNotification, Message: "Maintenance starts", Channel: "#ops", ScheduledAt: &postAt}
+	if err := sender.Schedule(notification); err != nil {
+		t.Fatalf("Failed to schedule: %v", err)
+	}
+	schedule := api.lastCall("chat.scheduleMessage")
+	if schedule.form.Get("channel") != "#ops" || schedule.form.Get("post_at") != strconv.FormatInt(postAt.Unix(), 10) {
+		t.Errorf("Unexpected chat.scheduleMessage request %v", schedule.form)
+	}
+	if notification.SlackChannelID != "C123" || notification.SlackScheduledMessageID != "Q2" {
+		t.Errorf("Expected scheduled message Q2 in C123, got %s in %s", notification.SlackScheduledMessageID, notification.SlackChannelID)
+	}
+
+	if auth := api.lastCall("chat.scheduleMessage").header.Get("Authorization"); auth != "Bearer xoxb-test" || schedule.form.Get("token") != "" {
+		t.Errorf("Expected the token in the Authorization header only, got %q", auth)
+	}
+
+	api.responses["chat.scheduleMessage"] = `{"ok":true,"channel":"C123"}`
+	if err := sender.Schedule(&models.Notification{Type: models.SlackNotification, Message: "Hi", Channel: "#ops", ScheduledAt: &postAt}); err == nil {
+		t.Error("Expected a response without scheduled_message_id to fail")
+	}
+
+	if err := sender.Unschedule(notification); err != nil {
+		t.Errorf("Expected an already posted message to be ignored, got %v", err)
+	}
+	cancel := api.lastCall("chat.deleteScheduledMessage")
+	if cancel == nil || cancel.form.Get("channel") != "C123" || cancel.form.Get("scheduled_message_id") != "Q2" {
+		t.Errorf("Unexpected chat.deleteScheduledMessage request %+v", cancel)
+	}
+	api.responses["chat.deleteScheduledMessage"] = `{"ok":false,"error":"channel_not_found"}`
+	if err := sender.Unschedule(notification); err == nil {
+		t.Error("Expected other chat.deleteScheduledMessage errors to be reported")
+	}
+}
+
+func TestSlackSenderEphemeralMessages(t *testing.T) {
+	api := newFakeSlackAPI(t, map[string]string{
+		"users.lookupByEmail": `{"ok":true,"user":{"id":"U0123456789"}}`,
+		"chat.postEphemeral":  `{"ok":true,"message_ts":"1700000000.000100"}`,
+	})
+	sender := api.sender(&config.Config{SlackChannel: "#general", SlackUserCacheTTL: time.Hour})
+
+	notification := &models.Notification{Type: models.SlackNotification, Message: "Only you can see this", Recipient: "ada@example.com", Channel: "#ops", Ephemeral: true}
+	if err := sender.Send(notification); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	post := api.lastCall("chat.postEphemeral")
+	if post == nil || post.form.Get("channel") != "#ops" || post.form.Get("user") != "U0123456789" {
+		t.Errorf("Unexpected chat.postEphemeral request %+v", post)
+	}
+	if api.count("conversations.open") != 0 || api.count("chat.postMessage") != 0 {
+		t.Error("Expected an ephemeral message rather than a DM")
+	}
+	if notification.SlackTS != "" {
+		t.Errorf("Expected no timestamp for an ephemeral message, got %s", notification.SlackTS)
+	}
+
+	notification = &models.Notification{Type: models.SlackNotification, Message: "Hi", Recipient: "#ops", Ephemeral: true}
+	if err := validateSlackNotification(notification); !errors.Is(err, ErrInvalidSlackMessage) {
+		t.Errorf("Expected an ephemeral message to a channel to be invalid, got %v", err)
+	}
+	if err := sender.Send(notification); !errors.Is(err, ErrInvalidSlackMessage) {
+		t.Errorf("Expected an ephemeral message to a channel to fail, got %v", err)
+	}
+}
+
+func TestChannelSlackSender(t *testing.T) {
+	cfg := &config.Config{SlackToken: "xoxb-default", SlackChannel: "#general"}
+	channel := &models.Channel{Name: "ops_slack", Type: models.SlackNotification, Config: models.JSON{"token": "xoxb-ops", "channel": "#ops"}}
+
+	sender := newChannelSlackSender(cfg, channel)
+	if sender.config.SlackToken != "xoxb-ops" || sender.config.SlackChannel != "#ops" || cfg.SlackToken != "xoxb-default" {
+		t.Errorf("Expected the channel's token and Slack channel, got %+v", sender.config)
+	}
+	if sender.rateLimiter() == NewSlackSender(cfg).rateLimiter() {
+		t.Error("Expected a workspace's rate limits not to be shared with another token")
+	}
+	if sender.limiterKey == "xoxb-ops" || sender.rateLimiter() != newChannelSlackSender(cfg, channel).rateLimiter() {
+		t.Error("Expected senders with the same token to share a limiter keyed by its hash")
+	}
+
+	tests := []struct {
+		channel  string
+		expected string
+	}{
+		{"ops_slack", "#ops"},
+		{"", "#ops"},
+		{"#incidents", "#incidents"},
+	}
+	for _, tt := range tests {
+		notification := &models.Notification{Type: models.SlackNotification, Channel: tt.channel}
+		if target, err := sender.targetChannel(notification); err != nil || target != tt.expected {
+			t.Errorf("Channel %q: expected %s, got %s (%v)", tt.channel, tt.expected, target, err)
+		}
+	}
+
+	defaults := newChannelSlackSender(cfg, &models.Channel{Name: "plain", Type: models.SlackNotification})
+	if target, _ := defaults.targetChannel(&models.Notification{Channel: "plain"}); target != "#general" || defaults.config.SlackToken != "xoxb-default" {
+		t.Errorf("Expected a channel without settings to use the environment's, got %s", target)
+	}
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// slackUserIDPattern matches Slack user IDs, which start with U or, for Enterprise
+// Grid users, W
+var slackUserIDPattern = regexp.MustCompile(`^[UW][A-Z0-9]{8,}$`)
+
+// slackUserCache remembers which Slack user an email address belongs to
+type slackUserCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]slackUserEntry
+}
+
+// slackUserEntry is a cached user lookup
+type slackUserEntry struct {
+	userID  string
+	expires time.Time
+}
+
+// newSlackUserCache creates a cache whose entries expire after ttl
+func newSlackUserCache(ttl time.Duration) *slackUserCache {
+	return &slackUserCache{ttl: ttl, entries: make(map[string]slackUserEntry)}
+}
+
+// get returns the cached user ID for an email address
+func (c *slackUserCache) get(email string, now time.Time) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[email]
+	if !ok || now.After(entry.expires) {
+		delete(c.entries, email)
+		return "", false
+	}
+	return entry.userID, true
+}
+
+// put caches the user ID for an email address
+func (c *slackUserCache) put(email, userID string, now time.Time) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[email] = slackUserEntry{userID: userID, expires: now.Add(c.ttl)}
+}
+
+// isSlackUserRecipient reports whether a recipient names a Slack user, by email
+// address or user ID, rather than a channel
+func isSlackUserRecipient(recipient string) bool {
+	recipient = strings.TrimSpace(recipient)
+	if slackUserIDPattern.MatchString(recipient) {
+		return true
+	}
+	if !strings.Contains(recipient, "@") || strings.HasPrefix(recipient, "@") {
+		return false
+	}
+	_, err := mail.ParseAddress(recipient)
+	return err == nil
+}
+
+// slackUserID returns the Slack user ID of a recipient given as an email address or
+// user ID. It returns "" for any other recipient, such as a channel name.
+func (s *SlackSender) slackUserID(recipient string) (string, error) {
+	recipient = strings.TrimSpace(recipient)
+	if !isSlackUserRecipient(recipient) {
+		return "", nil
+	}
+	if slackUserIDPattern.MatchString(recipient) {
+		return recipient, nil
+	}
+	addr, _ := mail.ParseAddress(recipient)
+	return s.lookupUserByEmail(strings.ToLower(addr.Address))
+}
+
+// directMessageChannel returns the DM channel for a recipient given as an email
+// address or Slack user ID. It returns "" for any other recipient, such as a
+// channel name.
+func (s *SlackSender) directMessageChannel(recipient string) (string, error) {
+	userID, err := s.slackUserID(recipient)
+	if err != nil || userID == "" {
+		return "", err
+	}
+
+	var channel *slack.Channel
+	err = s.call("conversations.open", "", func() (err error) {
+		channel, _, _, err = s.client.OpenConversation(&slack.OpenConversationParameters{Users: []string{userID}})
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to open Slack DM with %s: %w", userID, err)
+	}
+	return channel.ID, nil
+}
+
+// lookupUserByEmail resolves an email address to a Slack user ID with
+// users.lookupByEmail, caching the result for SLACK_USER_CACHE_TTL
+func (s *SlackSender) lookupUserByEmail(email string) (string, error) {
+	if userID, ok := s.users.get(email, time.Now()); ok {
+		return userID, nil
+	}
+
+	var user *slack.User
+	err := s.call("users.lookupByEmail", "", func() (err error) {
+		user, err = s.client.GetUserByEmail(email)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to find Slack user %s: %w", email, err)
+	}
+	s.users.put(email, user.ID, time.Now())
+	return user.ID, nil
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// smtpDialTimeout bounds connecting to the SMTP server
+const smtpDialTimeout = 10 * time.Second
+
+// errAllRecipientsRejected is returned when the server accepts none of a message's recipients
+var errAllRecipientsRejected = errors.New("all recipients were rejected")
+
+// smtpDialer opens authenticated connections to an SMTP server
+type smtpDialer struct {
+	host     string
+	port     int
+	username string
+	password string
+}
+
+// dial connects to the server, upgrading to TLS and authenticating when possible.
+// Port 465 uses implicit TLS; other ports use STARTTLS if the server offers it.
+func (d *smtpDialer) dial() (*smtp.Client, error) {
+	addr := net.JoinHostPort(d.host, strconv.Itoa(d.port))
+	tlsConfig := &tls.Config{ServerName: d.host}
+
+	var conn net.Conn
+	var err error
+	if d.port == 465 {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: smtpDialTimeout}, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, smtpDialTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := smtp.NewClient(conn, d.host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if d.port != 465 {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(tlsConfig); err != nil {
+				c.Close()
+				return nil, err
+			}
+		}
+	}
+
+	if d.username != "" {
+		if ok, _ := c.Extension("AUTH"); ok {
+			if err := c.Auth(smtp.PlainAuth("", d.username, d.password, d.host)); err != nil {
+				c.Close()
+				return nil, err
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// sendMail sends one message over an open connection. Addresses the server refuses
+// are reported in rejected instead of failing the whole message; an error is only
+// returned when the message could not be sent to anyone.
+func sendMail(c *smtp.Client, from string, recipients []string, msg io.WriterTo) (rejected map[string]error, err error) {
+	if err := c.Mail(from); err != nil {
+		return nil, err
+	}
+
+	rejected = make(map[string]error)
+	for _, addr := range recipients {
+		if err := c.Rcpt(addr); err != nil {
+			rejected[addr] = err
+		}
+	}
+	if len(rejected) == len(recipients) {
+		// Reset the transaction so the connection stays usable
+		c.Reset()
+		return rejected, errAllRecipientsRejected
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return rejected, err
+	}
+	if _, err := msg.WriteTo(w); err != nil {
+		w.Close()
+		return rejected, fmt.Errorf("failed to write message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return rejected, err
+	}
+
+	return rejected, nil
+}
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"net/smtp"
+	"sync"
+	"time"
+)
+
+// smtpPool keeps authenticated SMTP connections open and reuses them across
+// messages. A connection is recycled after maxMessages messages or once it has been
+// idle for idleTimeout, and dropped whenever it fails.
+type smtpPool struct {
+	dialer      *smtpDialer
+	size        int
+	maxMessages int
+	idleTimeout time.Duration
+
+	// slots holds one token per connection in use, capping open connections at size
+	slots chan struct{}
+	stop  chan struct{}
+
+	mu     sync.Mutex
+	idle   []*pooledConn
+	closed bool
+}
+
+// pooledConn is an open SMTP connection owned by a pool
+type pooledConn struct {
+	client   *smtp.Client
+	messages int
+	lastUsed time.Time
+}
+
+// newSMTPPool creates a pool of at most size connections to the dialer's server
+func newSMTPPool(dialer *smtpDialer, size, maxMessages int, idleTimeout time.Duration) *smtpPool {
+	if size < 1 {
+		size = 1
+	}
+	p := &smtpPool{
+		dialer:      dialer,
+		size:        size,
+		maxMessages: maxMessages,
+		idleTimeout: idleTimeout,
+		slots:       make(chan struct{}, size),
+		stop:        make(chan struct{}),
+	}
+	if idleTimeout > 0 {
+		go p.reapIdle()
+	}
+	return p
+}
+
+// send sends one message on a pooled connection. See sendMail for how rejected
+// recipients are reported.
+func (p *smtpPool) send(from string, recipients []string, msg io.WriterTo) (map[string]error, error) {
+	conn, err := p.get()
+	if err != nil {
+		return nil, err
+	}
+
+	rejected, err := sendMail(conn.client, from, recipients, msg)
+	// A rejection of every recipient leaves the connection reset and usable;
+	// anything else may have left it in an unknown state
+	healthy := err == nil || errors.Is(err, errAllRecipientsRejected)
+	if err == nil {
+		conn.messages++
+	}
+	p.put(conn, healthy)
+
+	return rejected, err
+}
+
+// get checks out an idle connection, or dials a new one when none is available.
+// It blocks while size connections are in use.
+func (p *smtpPool) get() (*pooledConn, error) {
+	p.slots <- struct{}{}
+
+	for {
+		p.mu.Lock()
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		conn := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+
+		if p.expired(conn, time.Now()) {
+			closeSMTP(conn.client)
+			continue
+		}
+		// The server may have closed the connection while it sat idle
+		if err := conn.client.Noop(); err != nil {
+			conn.client.Close()
+			continue
+		}
+		return conn, nil
+	}
+
+	client, err := p.dialer.dial()
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return &pooledConn{client: client}, nil
+}
+
+// put returns a checked-out connection, closing it if it failed, has sent its
+// quota of messages or the pool is closed
+func (p *smtpPool) put(conn *pooledConn, healthy bool) {
+	defer func() { <-p.slots }()
+
+	if !healthy {
+		conn.client.Close()
+		return
+	}
+	if p.maxMessages > 0 && conn.messages >= p.maxMessages {
+		closeSMTP(conn.client)
+		return
+	}
+
+	conn.lastUsed = time.Now()
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		closeSMTP(conn.client)
+		return
+	}
+	p.idle = append(p.idle, conn)
+	p.mu.Unlock()
+}
+
+// expired reports whether a connection has been idle for longer than the idle timeout
+func (p *smtpPool) expired(conn *pooledConn, now time.Time) bool {
+	return p.idleTimeout > 0 && now.Sub(conn.lastUsed) > p.idleTimeout
+}
+
+// reapIdle periodically closes connections that have been idle too long
+func (p *smtpPool) reapIdle() {
+	interval := p.idleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case now := <-ticker.C:
+			p.mu.Lock()
+			var expired []*pooledConn
+			kept := p.idle[:0]
+			for _, conn := range p.idle {
+				if p.expired(conn, now) {
+					expired = append(expired, conn)
+				} else {
+					kept = append(kept, conn)
+				}
+			}
+			p.idle = kept
+			p.mu.Unlock()
+
+			for _, conn := range expired {
+				closeSMTP(conn.client)
+			}
+		}
+	}
+}
+
+// close closes the pool's idle connections. Connections in use are closed when
+// they are returned.
+func (p *smtpPool) close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	close(p.stop)
+	for _, conn := range idle {
+		closeSMTP(conn.client)
+	}
+}
+
+// closeSMTP ends an SMTP session politely, then closes the connection
+func closeSMTP(c *smtp.Client) {
+	if err := c.Quit(); err != nil {
+		c.Close()
+	}
+}
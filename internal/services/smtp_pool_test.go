@@ -0,0 +1,198 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"notification-service/internal/models"
+
+	"gopkg.in/gomail.v2"
+)
+
+func testPoolMessage() *gomail.Message {
+	m := gomail.NewMessage()
+	m.SetHeader("From", "noreply@example.com")
+	m.SetHeader("To", "ada@example.com")
+	m.SetBody("text/plain", "Hi")
+	return m
+}
+
+func newTestPool(server *fakeSMTPServer, size, maxMessages int, idleTimeout time.Duration) *smtpPool {
+	cfg := server.config()
+	return newSMTPPool(&smtpDialer{host: cfg.EmailHost, port: cfg.EmailPort}, size, maxMessages, idleTimeout)
+}
+
+func TestSMTPPoolReusesConnections(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxMessages int
+		expected    int
+	}{
+		{"Reused", 0, 1},
+		{"Recycled after max messages", 2, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeSMTPServer(t)
+			pool := newTestPool(server, 2, tt.maxMessages, time.Minute)
+			defer pool.close()
+
+			for i := 0; i < 5; i++ {
+				if _, err := pool.send("noreply@example.com", []string{"ada@example.com"}, testPoolMessage()); err != nil {
+					t.Fatalf("Failed to send message %d: %v", i, err)
+				}
+			}
+
+			if connections, messages := server.stats(); connections != tt.expected || messages != 5 {
+				t.Errorf("Expected %d connections for 5 messages, got %d for %d", tt.expected, connections, messages)
+			}
+		})
+	}
+}
+
+func TestSMTPPoolReconnects(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	pool := newTestPool(server, 1, 0, time.Minute)
+	defer pool.close()
+
+	if _, err := pool.send("noreply@example.com", []string{"ada@example.com"}, testPoolMessage()); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	server.dropConnections()
+	if _, err := pool.send("noreply@example.com", []string{"ada@example.com"}, testPoolMessage()); err != nil {
+		t.Fatalf("Expected the pool to reconnect, got %v", err)
+	}
+
+	if connections, _ := server.stats(); connections != 2 {
+		t.Errorf("Expected 2 connections, got %d", connections)
+	}
+}
+
+func TestSMTPPoolIdleTimeout(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	pool := newTestPool(server, 1, 0, 10*time.Millisecond)
+	defer pool.close()
+
+	for i := 0; i < 2; i++ {
+		if _, err := pool.send("noreply@example.com", []string{"ada@example.com"}, testPoolMessage()); err != nil {
+			t.Fatalf("Failed to send: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if connections, _ := server.stats(); connections != 2 {
+		t.Errorf("Expected idle connections to be recycled, got %d connections", connections)
+	}
+}
+
+func TestSMTPPoolLimitsConnections(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	pool := newTestPool(server, 2, 0, time.Minute)
+	defer pool.close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := pool.send("noreply@example.com", []string{"ada@example.com"}, testPoolMessage()); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Failed to send: %v", err)
+	}
+
+	if connections, messages := server.stats(); connections > 2 || messages != 10 {
+		t.Errorf("Expected at most 2 connections for 10 messages, got %d for %d", connections, messages)
+	}
+}
+
+func TestEmailSenderPoolPerChannel(t *testing.T) {
+	cfg := newFakeSMTPServer(t).config()
+	cfg.SMTPPoolSize = 3
+	cfg.EmailUsername = "env-user"
+	size := 5.0
+	channels := func(tenantID, name string, notificationType models.NotificationType) *models.Channel {
+		if name != "bulk_email" {
+			return nil
+		}
+		return &models.Channel{TenantID: tenantID, Name: name, Type: notificationType, Config: models.JSON{"smtp_host": cfg.EmailHost, "smtp_pool_size": size}}
+	}
+	sender := NewEmailSender(cfg, nil, channels)
+
+	defaultPool, err := sender.poolFor(models.DefaultTenant, "")
+	if err != nil || defaultPool.size != 3 {
+		t.Fatalf("Expected the default tenant to have a pool of size 3 for the environment's server, got %v", err)
+	}
+	if pool, _ := sender.poolFor(models.DefaultTenant, "unknown"); pool != defaultPool {
+		t.Errorf("Expected unknown channels of the default tenant to share its default pool")
+	}
+	for _, name := range []string{"", "unknown"} {
+		if _, err := sender.poolFor("team-a", name); !errors.Is(err, ErrTenantChannelRequired) {
+			t.Errorf("Expected other tenants not to use the environment's server for %q, got %v", name, err)
+		}
+	}
+
+	bulk, err := sender.poolFor("team-a", "bulk_email")
+	if err != nil || bulk.size != 5 || bulk == defaultPool {
+		t.Fatalf("Expected the channel to have its own pool of size 5, got %v", err)
+	}
+	if bulk.dialer.username != "" || bulk.dialer.port != defaultSMTPPort {
+		t.Errorf("Expected another tenant's channel not to inherit the environment's settings, got %+v", bulk.dialer)
+	}
+	if other, _ := sender.poolFor("team-b", "bulk_email"); other == bulk {
+		t.Errorf("Expected another tenant's channel of the same name not to use the pool")
+	}
+
+	size = 8
+	if resized, _ := sender.poolFor("team-a", "bulk_email"); resized == bulk || resized.size != 8 {
+		t.Errorf("Expected the pool to be replaced when the channel's size changes")
+	}
+}
+
+func TestEmailSenderChannelServer(t *testing.T) {
+	defaultServer, channelServer := newFakeSMTPServer(t), newFakeSMTPServer(t)
+	channelConfig := channelServer.config()
+	settings := models.JSON{"smtp_host": channelConfig.EmailHost, "smtp_port": float64(channelConfig.EmailPort), "smtp_username": "bulk"}
+	channels := func(tenantID, name string, notificationType models.NotificationType) *models.Channel {
+		if name != "bulk_email" {
+			return nil
+		}
+		return &models.Channel{Name: name, Type: notificationType, Config: settings}
+	}
+	sender := NewEmailSender(defaultServer.config(), nil, channels)
+
+	pool, err := sender.poolFor(models.DefaultTenant, "bulk_email")
+	if err != nil || pool.dialer.port != channelConfig.EmailPort || pool.dialer.username != "bulk" {
+		t.Fatalf("Expected the channel's server settings, got %+v", pool.dialer)
+	}
+	if _, err := pool.send("noreply@example.com", []string{"ada@example.com"}, testPoolMessage()); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	if _, messages := channelServer.stats(); messages != 1 {
+		t.Errorf("Expected the message to reach the channel's server, got %d messages", messages)
+	}
+	if _, messages := defaultServer.stats(); messages != 0 {
+		t.Errorf("Expected no messages on the default server, got %d", messages)
+	}
+	if err := sender.testConnection(models.DefaultTenant, "bulk_email"); err != nil {
+		t.Errorf("Failed to test the channel's connection: %v", err)
+	}
+
+	settings["smtp_password"] = "rotated"
+	if replaced, _ := sender.poolFor(models.DefaultTenant, "bulk_email"); replaced == pool || replaced.dialer.password != "rotated" {
+		t.Errorf("Expected the pool to be replaced when the channel's credentials change")
+	}
+	sender.closePool(models.DefaultTenant, "bulk_email")
+	if _, ok := sender.pools[channelKey(models.DefaultTenant, "bulk_email")]; ok {
+		t.Error("Expected the channel's pool to be closed")
+	}
+}
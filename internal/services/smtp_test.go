@@ -0,0 +1,281 @@
+package services
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+)
+
+// fakeSMTPServer is a minimal SMTP server that rejects some recipients
+type fakeSMTPServer struct {
+	listener net.Listener
+	rejected map[string]bool
+
+	mu          sync.Mutex
+	connections int
+	open        []net.Conn
+	messages    []fakeSMTPMessage
+}
+
+// fakeSMTPMessage is a message the fake server accepted
+type fakeSMTPMessage struct {
+	from       string
+	recipients []string
+	data       string
+}
+
+func newFakeSMTPServer(t *testing.T, rejected ...string) *fakeSMTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := &fakeSMTPServer{listener: listener, rejected: make(map[string]bool)}
+	for _, addr := range rejected {
+		s.rejected[addr] = true
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.connections++
+			s.open = append(s.open, conn)
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// config returns a configuration pointing EmailSender at the server
+func (s *fakeSMTPServer) config() *config.Config {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	return &config.Config{
+		EmailHost:        addr.IP.String(),
+		EmailPort:        addr.Port,
+		EmailFromAddress: "noreply@example.com",
+	}
+}
+
+// dropConnections closes every client connection, as a server timing out idle clients would
+func (s *fakeSMTPServer) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.open {
+		conn.Close()
+	}
+	s.open = nil
+}
+
+// stats returns the number of connections opened and messages accepted
+func (s *fakeSMTPServer) stats() (connections, messages int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connections, len(s.messages)
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { fmt.Fprintf(conn, "%s\r\n", line) }
+
+	reply("220 fake ESMTP")
+	var msg fakeSMTPMessage
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+		switch verb {
+		case "EHLO", "HELO", "NOOP":
+			reply("250 fake")
+		case "MAIL":
+			msg = fakeSMTPMessage{from: strings.Trim(line[len("MAIL FROM:"):], "<>")}
+			reply("250 OK")
+		case "RCPT":
+			addr := strings.Trim(line[len("RCPT TO:"):], "<>")
+			if s.rejected[addr] {
+				reply("550 5.1.1 No such user")
+				continue
+			}
+			msg.recipients = append(msg.recipients, addr)
+			reply("250 OK")
+		case "DATA":
+			reply("354 Go ahead")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			msg.data = data.String()
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			reply("250 Queued")
+		case "RSET":
+			msg = fakeSMTPMessage{}
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Not implemented")
+		}
+	}
+}
+
+func TestEmailSenderPartialDelivery(t *testing.T) {
+	server := newFakeSMTPServer(t, "missing@example.com")
+	sender := NewEmailSender(server.config(), nil, nil)
+
+	notification := &models.Notification{
+		TenantID:  models.DefaultTenant,
+		Type:      models.EmailNotification,
+		Title:     "Hello",
+		Message:   "Hi all",
+		Recipient: "ada@example.com",
+		ReplyTo:   "support@example.com",
+		Deliveries: []models.Delivery{
+			{Address: "ada@example.com", Kind: models.ToDelivery},
+			{Address: "missing@example.com", Kind: models.ToDelivery},
+			{Address: "grace@example.com", Kind: models.CCDelivery},
+			{Address: "audit@example.com", Kind: models.BCCDelivery},
+		},
+	}
+
+	if err := sender.Send(notification); err != nil {
+		t.Fatalf("Expected a partial delivery to succeed, got %v", err)
+	}
+
+	expected := map[string]models.DeliveryStatus{
+		"ada@example.com":     models.DeliverySent,
+		"missing@example.com": models.DeliveryFailed,
+		"grace@example.com":   models.DeliverySent,
+		"audit@example.com":   models.DeliverySent,
+	}
+	for _, delivery := range notification.Deliveries {
+		if delivery.Status != expected[delivery.Address] {
+			t.Errorf("Expected %s to be %s, got %s (%s)", delivery.Address, expected[delivery.Address], delivery.Status, delivery.Error)
+		}
+	}
+	if !hasFailedDelivery(notification.Deliveries) {
+		t.Error("Expected a failed delivery to be reported")
+	}
+
+	if len(server.messages) != 1 {
+		t.Fatalf("Expected one message, got %d", len(server.messages))
+	}
+	msg := server.messages[0]
+	if msg.from != "noreply@example.com" || len(msg.recipients) != 3 {
+		t.Errorf("Unexpected envelope: %+v", msg)
+	}
+	for _, header := range []string{
+		"To: ada@example.com, missing@example.com",
+		"Cc: grace@example.com",
+		"Reply-To: support@example.com",
+	} {
+		if !strings.Contains(msg.data, header) {
+			t.Errorf("Expected header %q in:\n%s", header, msg.data)
+		}
+	}
+	if strings.Contains(msg.data, "audit@example.com") {
+		t.Error("BCC address must not appear in the message headers")
+	}
+}
+
+func TestEmailSenderAllRejected(t *testing.T) {
+	server := newFakeSMTPServer(t, "missing@example.com")
+	sender := NewEmailSender(server.config(), nil, nil)
+
+	notification := &models.Notification{
+		TenantID:   models.DefaultTenant,
+		Type:       models.EmailNotification,
+		Title:      "Hello",
+		Message:    "Hi",
+		Recipient:  "missing@example.com",
+		Deliveries: []models.Delivery{{Address: "missing@example.com", Kind: models.ToDelivery}},
+	}
+
+	if err := sender.Send(notification); !errors.Is(err, errAllRecipientsRejected) {
+		t.Fatalf("Expected all recipients to be rejected, got %v", err)
+	}
+	if notification.Deliveries[0].Status != models.DeliveryFailed || !strings.Contains(notification.Deliveries[0].Error, "550") {
+		t.Errorf("Expected the rejection to be recorded, got %+v", notification.Deliveries[0])
+	}
+}
+
+func TestBuildDeliveries(t *testing.T) {
+	deliveries, err := buildDeliveries(&models.NotificationRequest{
+		Type:      models.EmailNotification,
+		Recipient: "Ada <ada@example.com>",
+		To:        []string{"grace@example.com", "ADA@example.com"},
+		CC:        []string{"grace@example.com", "linus@example.com"},
+		BCC:       []string{"audit@example.com"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to build deliveries: %v", err)
+	}
+
+	expected := []models.Delivery{
+		{Address: "ada@example.com", Kind: models.ToDelivery},
+		{Address: "grace@example.com", Kind: models.ToDelivery},
+		{Address: "linus@example.com", Kind: models.CCDelivery},
+		{Address: "audit@example.com", Kind: models.BCCDelivery},
+	}
+	if len(deliveries) != len(expected) {
+		t.Fatalf("Expected %d deliveries, got %+v", len(expected), deliveries)
+	}
+	for i, want := range expected {
+		if deliveries[i].Address != want.Address || deliveries[i].Kind != want.Kind {
+			t.Errorf("Delivery %d: expected %s %s, got %s %s", i, want.Kind, want.Address, deliveries[i].Kind, deliveries[i].Address)
+		}
+	}
+
+	invalid := []*models.NotificationRequest{
+		{Type: models.EmailNotification, Recipient: "not an address"},
+		{Type: models.EmailNotification, CC: []string{"grace@example.com"}},
+		{Type: models.SlackNotification, Recipient: "#general", CC: []string{"grace@example.com"}},
+	}
+	for _, req := range invalid {
+		if _, err := buildDeliveries(req); !errors.Is(err, ErrInvalidRecipient) {
+			t.Errorf("Expected ErrInvalidRecipient for %+v, got %v", req, err)
+		}
+	}
+}
+
+func TestEmailSenderOnlyDefaultTenantUsesEnvironment(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	sender := NewEmailSender(server.config(), nil, nil)
+
+	notification := &models.Notification{TenantID: "team-a", Type: models.EmailNotification, Title: "Hello", Message: "Hi", Recipient: "ada@example.com"}
+	if err := sender.Send(notification); !errors.Is(err, ErrTenantChannelRequired) {
+		t.Errorf("Expected another tenant not to send with the environment's from address, got %v", err)
+	}
+	notification.From = "team-a@example.com"
+	if err := sender.Send(notification); !errors.Is(err, ErrTenantChannelRequired) {
+		t.Errorf("Expected another tenant not to send through the environment's server, got %v", err)
+	}
+	if _, messages := server.stats(); messages != 0 {
+		t.Errorf("Expected no messages on the environment's server, got %d", messages)
+	}
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"notification-service/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Suppress adds the addresses of bounce and complaint events to a tenant's suppression
+// list. Soft bounces suppress an address for SOFT_BOUNCE_SUPPRESSION and never
+// replace a permanent suppression; hard bounces and complaints do not expire.
+func (s *NotificationService) Suppress(tenantID string, events []BounceEvent, source string) ([]models.Suppression, error) {
+	var suppressions []models.Suppression
+	for _, event := range events {
+		expiresAt := (*time.Time)(nil)
+		if event.Reason == models.SoftBounceSuppression {
+			if s.config.SoftBounceSuppression <= 0 {
+				continue
+			}
+			until := time.Now().Add(s.config.SoftBounceSuppression)
+			expiresAt = &until
+		}
+
+		suppression, err := s.upsertSuppression(tenantID, event.Address, event.Reason, source, event.Detail, expiresAt)
+		if err != nil {
+			return nil, err
+		}
+		if suppression != nil {
+			suppressions = append(suppressions, *suppression)
+		}
+	}
+	return suppressions, nil
+}
+
+// AddSuppression suppresses an address manually for a tenant
+func (s *NotificationService) AddSuppression(tenantID string, req *models.SuppressionRequest) (*models.Suppression, error) {
+	address, err := normalizeAddress(req.Address)
+	if err != nil {
+		return nil, err
+	}
+	reason := req.Reason
+	if reason == "" {
+		reason = models.ManualSuppression
+	}
+	return s.upsertSuppression(tenantID, address, reason, "api", req.Detail, req.ExpiresAt)
+}
+
+// upsertSuppression creates or updates a tenant's suppression of an address. It returns
+// nil when an active permanent suppression is kept in place of a temporary one.
+func (s *NotificationService) upsertSuppression(tenantID, address string, reason models.SuppressionReason, source, detail string, expiresAt *time.Time) (*models.Suppression, error) {
+	address = strings.ToLower(strings.TrimSpace(address))
+	if address == "" {
+		return nil, nil
+	}
+
+	var suppression models.Suppression
+	err := s.db.Where("tenant_id = ? AND address = ?", tenantID, address).First(&suppression).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		suppression = models.Suppression{TenantID: tenantID, Address: address}
+	case err != nil:
+		return nil, err
+	case expiresAt != nil && suppression.ExpiresAt == nil:
+		return nil, nil
+	}
+
+	suppression.Reason = reason
+	suppression.Source = source
+	suppression.Detail = detail
+	suppression.ExpiresAt = expiresAt
+	if err := s.db.Save(&suppression).Error; err != nil {
+		return nil, err
+	}
+	return &suppression, nil
+}
+
+// GetSuppressions lists a tenant's active suppressions
+func (s *NotificationService) GetSuppressions(tenantID string, limit, offset int) ([]models.Suppression, int64, error) {
+	var suppressions []models.Suppression
+	var total int64
+
+	query := s.db.Model(&models.Suppression{}).Where("tenant_id = ? AND (expires_at IS NULL OR expires_at > ?)", tenantID, time.Now())
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if err := query.Limit(limit).Offset(offset).Order("created_at DESC").Find(&suppressions).Error; err != nil {
+		return nil, 0, err
+	}
+	return suppressions, total, nil
+}
+
+// DeleteSuppression removes an address from a tenant's suppression list
+func (s *NotificationService) DeleteSuppression(tenantID, address string) error {
+	result := s.db.Where("tenant_id = ? AND address = ?", tenantID, strings.ToLower(strings.TrimSpace(address))).Delete(&models.Suppression{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// suppressedAddresses returns which of the given lowercased addresses a tenant actively suppresses
+func (s *NotificationService) suppressedAddresses(tenantID string, addresses []string) (map[string]bool, error) {
+	var found []string
+	if err := s.db.Model(&models.Suppression{}).
+		Where("tenant_id = ? AND address IN ? AND (expires_at IS NULL OR expires_at > ?)", tenantID, addresses, time.Now()).
+		Pluck("address", &found).Error; err != nil {
+		return nil, err
+	}
+
+	suppressed := make(map[string]bool, len(found))
+	for _, address := range found {
+		suppressed[address] = true
+	}
+	return suppressed, nil
+}
+
+// applySuppressions marks the deliveries of an email to addresses its tenant suppresses,
+// and to recipients who unsubscribed from its category, and reports whether every address
+// is skipped
+func (s *NotificationService) applySuppressions(notification *models.Notification) (bool, error) {
+	if len(notification.Deliveries) == 0 {
+		notification.Deliveries = []models.Delivery{{Address: notification.Recipient, Kind: models.ToDelivery}}
+	}
+
+	addresses := make([]string, len(notification.Deliveries))
+	for i, delivery := range notification.Deliveries {
+		addresses[i] = strings.ToLower(delivery.Address)
+	}
+	suppressed, err := s.suppressedAddresses(notification.TenantID, addresses)
+	if err != nil {
+		return false, err
+	}
+	unsubscribed, err := s.unsubscribedAddresses(notification.TenantID, notification.Category, addresses)
+	if err != nil {
+		return false, err
+	}
+
+	all := true
+	for i, address := range addresses {
+		switch {
+		case suppressed[address]:
+			notification.Deliveries[i].Status = models.DeliverySuppressed
+			notification.Deliveries[i].Error = "address is on the suppression list"
+		case unsubscribed[address]:
+			notification.Deliveries[i].Status = models.DeliverySuppressed
+			notification.Deliveries[i].Error = fmt.Sprintf("recipient unsubscribed from %s", notification.Category)
+		default:
+			all = false
+		}
+	}
+	return all, nil
+}
+
+// BounceWebhookAuthorized reports whether a bounce webhook token matches
+// BOUNCE_WEBHOOK_SECRET. Every token is rejected when no secret is configured.
+func (s *NotificationService) BounceWebhookAuthorized(token string) bool {
+	if s.config.BounceWebhookSecret == "" || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.config.BounceWebhookSecret)) == 1
+}
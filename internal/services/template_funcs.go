@@ -0,0 +1,458 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+	_ "time/tzdata" // timezone names must resolve in minimal containers
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/currency"
+	"golang.org/x/text/feature/plural"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
+)
+
+// TemplateFunc documents a helper function available inside templates
+type TemplateFunc struct {
+	Name        string `json:"name"`
+	Signature   string `json:"signature"`
+	Description string `json:"description"`
+	Example     string `json:"example"`
+}
+
+// funcEnv is the environment template helpers are built for
+type funcEnv struct {
+	tag     language.Tag
+	printer *message.Printer
+	now     time.Time
+
+	// unsubscribeURL builds the recipient's unsubscribe link, when there is one
+	unsubscribeURL func() (string, error)
+}
+
+// templateFuncDef pairs a helper's documentation with its implementation
+type templateFuncDef struct {
+	TemplateFunc
+	build func(env *funcEnv) interface{}
+}
+
+// timeLayouts are the named layouts accepted by format_time
+var timeLayouts = map[string]string{
+	"date":     "2006-01-02",
+	"time":     "15:04",
+	"datetime": "2006-01-02 15:04",
+	"rfc3339":  time.RFC3339,
+	"rfc1123":  time.RFC1123,
+	"kitchen":  time.Kitchen,
+}
+
+var templateFuncDefs = []templateFuncDef{
+	{
+		TemplateFunc: TemplateFunc{
+			Name:        "locale",
+			Signature:   "locale() string",
+			Description: "Returns the BCP 47 locale the template is being rendered for.",
+			Example:     `{{locale}}`,
+		},
+		build: func(env *funcEnv) interface{} {
+			return func() string {
+				return env.tag.String()
+			}
+		},
+	},
+	{
+		TemplateFunc: TemplateFunc{
+			Name:        "format_number",
+			Signature:   "format_number(value number, [decimals int]) string",
+			Description: "Formats a number with the locale's grouping and decimal separators, optionally rounded to a fixed number of decimals.",
+			Example:     `{{format_number .Total 2}}`,
+		},
+		build: func(env *funcEnv) interface{} {
+			return func(value interface{}, decimals ...interface{}) (string, error) {
+				n, err := toFloat(value)
+				if err != nil {
+					return "", err
+				}
+				if len(decimals) == 0 {
+					return env.printer.Sprint(number.Decimal(n)), nil
+				}
+				scale, err := toFloat(decimals[0])
+				if err != nil {
+					return "", err
+				}
+				// Round half away from zero; x/text would otherwise round half to even
+				factor := math.Pow(10, math.Floor(scale))
+				n = math.Round(n*factor) / factor
+				return env.printer.Sprint(number.Decimal(n, number.Scale(int(scale)))), nil
+			}
+		},
+	},
+	{
+		TemplateFunc: TemplateFunc{
+			Name:        "format_currency",
+			Signature:   "format_currency(amount number, code string) string",
+			Description: "Formats an amount in an ISO 4217 currency using the locale's separators and the currency's standard precision.",
+			Example:     `{{format_currency .Amount "EUR"}}`,
+		},
+		build: func(env *funcEnv) interface{} {
+			return func(amount interface{}, code string) (string, error) {
+				n, err := toFloat(amount)
+				if err != nil {
+					return "", err
+				}
+				unit, err := currency.ParseISO(code)
+				if err != nil {
+					return "", fmt.Errorf("invalid currency %q: %w", code, err)
+				}
+				return env.printer.Sprint(currency.Symbol(unit.Amount(n))), nil
+			}
+		},
+	},
+	{
+		TemplateFunc: TemplateFunc{
+			Name:        "plural",
+			Signature:   "plural(count number, one string, other string) string",
+			Description: "Chooses the singular or plural form using the locale's CLDR plural rules.",
+			Example:     `{{.Count}} {{plural .Count "item" "items"}}`,
+		},
+		build: func(env *funcEnv) interface{} {
+			return func(count interface{}, one, other string) (string, error) {
+				n, err := toFloat(count)
+				if err != nil {
+					return "", err
+				}
+				if pluralForm(env.tag, n) == plural.One {
+					return one, nil
+				}
+				return other, nil
+			}
+		},
+	},
+	{
+		TemplateFunc: TemplateFunc{
+			Name:        "format_date",
+			Signature:   "format_date(value time, [timezone string]) string",
+			Description: "Formats a date in the locale's numeric date format, optionally converted to an IANA timezone. Strings must be RFC 3339.",
+			Example:     `{{format_date .DueAt "America/New_York"}}`,
+		},
+		build: func(env *funcEnv) interface{} {
+			return func(value interface{}, timezone ...string) (string, error) {
+				t, err := toTimeIn(value, timezone)
+				if err != nil {
+					return "", err
+				}
+				return t.Format(dateLayoutFor(env.tag)), nil
+			}
+		},
+	},
+	{
+		TemplateFunc: TemplateFunc{
+			Name:        "format_time",
+			Signature:   "format_time(value time, layout string, [timezone string]) string",
+			Description: "Formats a time with a Go layout or one of date, time, datetime, rfc3339, rfc1123 or kitchen, optionally converted to an IANA timezone.",
+			Example:     `{{format_time .StartsAt "datetime" "Europe/Berlin"}}`,
+		},
+		build: func(env *funcEnv) interface{} {
+			return func(value interface{}, layout string, timezone ...string) (string, error) {
+				t, err := toTimeIn(value, timezone)
+				if err != nil {
+					return "", err
+				}
+				if named, ok := timeLayouts[layout]; ok {
+					layout = named
+				}
+				return t.Format(layout), nil
+			}
+		},
+	},
+	{
+		TemplateFunc: TemplateFunc{
+			Name:        "relative_time",
+			Signature:   "relative_time(value time) string",
+			Description: "Describes a time relative to when the template is rendered, such as \"3 hours ago\" or \"in 2 days\".",
+			Example:     `{{relative_time .CreatedAt}}`,
+		},
+		build: func(env *funcEnv) interface{} {
+			return func(value interface{}) (string, error) {
+				t, err := toTime(value)
+				if err != nil {
+					return "", err
+				}
+				return relativeTime(t, env.now), nil
+			}
+		},
+	},
+	{
+		TemplateFunc: TemplateFunc{
+			Name:        "truncate",
+			Signature:   "truncate(length int, s string) string",
+			Description: "Shortens a string to at most length characters, ending with an ellipsis when cut.",
+			Example:     `{{.Description | truncate 80}}`,
+		},
+		build: func(env *funcEnv) interface{} {
+			return func(length interface{}, s string) (string, error) {
+				n, err := toFloat(length)
+				if err != nil {
+					return "", err
+				}
+				return truncate(s, int(n)), nil
+			}
+		},
+	},
+	{
+		TemplateFunc: TemplateFunc{
+			Name:        "default",
+			Signature:   "default(fallback any, value any) any",
+			Description: "Returns value, or fallback when value is missing, empty or zero.",
+			Example:     `{{.Nickname | default "there"}}`,
+		},
+		build: func(env *funcEnv) interface{} {
+			return func(fallback, value interface{}) interface{} {
+				if isEmptyValue(value) {
+					return fallback
+				}
+				return value
+			}
+		},
+	},
+	{
+		TemplateFunc: TemplateFunc{
+			Name:        "upper",
+			Signature:   "upper(s string) string",
+			Description: "Converts a string to upper case using the locale's casing rules.",
+			Example:     `{{upper .Code}}`,
+		},
+		build: func(env *funcEnv) interface{} {
+			caser := cases.Upper(env.tag)
+			return func(s string) string {
+				return caser.String(s)
+			}
+		},
+	},
+	{
+		TemplateFunc: TemplateFunc{
+			Name:        "lower",
+			Signature:   "lower(s string) string",
+			Description: "Converts a string to lower case using the locale's casing rules.",
+			Example:     `{{lower .Email}}`,
+		},
+		build: func(env *funcEnv) interface{} {
+			caser := cases.Lower(env.tag)
+			return func(s string) string {
+				return caser.String(s)
+			}
+		},
+	},
+	{
+		TemplateFunc: TemplateFunc{
+			Name:        "title",
+			Signature:   "title(s string) string",
+			Description: "Capitalizes the first letter of each word using the locale's casing rules.",
+			Example:     `{{title .Name}}`,
+		},
+		build: func(env *funcEnv) interface{} {
+			caser := cases.Title(env.tag)
+			return func(s string) string {
+				return caser.String(s)
+			}
+		},
+	},
+	{
+		TemplateFunc: TemplateFunc{
+			Name:        "url_query",
+			Signature:   "url_query(base string, key string, value any, ...) string",
+			Description: "Adds key/value pairs to the query string of a URL, escaping them as needed.",
+			Example:     `{{url_query "https://example.com/orders" "id" .OrderID "utm_source" "email"}}`,
+		},
+		build: func(env *funcEnv) interface{} {
+			return func(base string, pairs ...interface{}) (string, error) {
+				return buildURLQuery(base, pairs...)
+			}
+		},
+	},
+	{
+		TemplateFunc: TemplateFunc{
+			Name:        "json",
+			Signature:   "json(value any) string",
+			Description: "Encodes a value as JSON.",
+			Example:     `{{json .Items}}`,
+		},
+		build: func(env *funcEnv) interface{} {
+			return func(value interface{}) (string, error) {
+				encoded, err := json.Marshal(value)
+				if err != nil {
+					return "", err
+				}
+				return string(encoded), nil
+			}
+		},
+	},
+	{
+		TemplateFunc: TemplateFunc{
+			Name:        "unsubscribe_url",
+			Signature:   "unsubscribe_url() string",
+			Description: "Returns the signed link that unsubscribes the recipient from the notification's category.",
+			Example:     `<a href="{{unsubscribe_url}}">Unsubscribe</a>`,
+		},
+		build: func(env *funcEnv) interface{} {
+			return func() (string, error) {
+				if env.unsubscribeURL == nil {
+					return "", errors.New("unsubscribe_url is only available for email notifications")
+				}
+				return env.unsubscribeURL()
+			}
+		},
+	},
+}
+
+// TemplateFuncs returns the documentation for every helper available to templates
+func TemplateFuncs() []TemplateFunc {
+	funcs := make([]TemplateFunc, len(templateFuncDefs))
+	for i, def := range templateFuncDefs {
+		funcs[i] = def.TemplateFunc
+	}
+	return funcs
+}
+
+// templateFuncs returns the helpers for rendering in a locale at a point in time
+func templateFuncs(locale string, now time.Time) map[string]interface{} {
+	return newFuncEnv(locale, now).funcs()
+}
+
+// newFuncEnv creates the environment for rendering in a locale at a point in time
+func newFuncEnv(locale string, now time.Time) *funcEnv {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		tag = language.English
+	}
+	return &funcEnv{tag: tag, printer: message.NewPrinter(tag), now: now}
+}
+
+// funcs builds every template helper for the environment
+func (env *funcEnv) funcs() map[string]interface{} {
+	funcs := make(map[string]interface{}, len(templateFuncDefs))
+	for _, def := range templateFuncDefs {
+		funcs[def.Name] = def.build(env)
+	}
+	return funcs
+}
+
+// toTimeIn converts a template value into a time, in the first timezone given if any
+func toTimeIn(value interface{}, timezone []string) (time.Time, error) {
+	t, err := toTime(value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(timezone) == 0 || timezone[0] == "" {
+		return t, nil
+	}
+	loc, err := time.LoadLocation(timezone[0])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timezone %q: %w", timezone[0], err)
+	}
+	return t.In(loc), nil
+}
+
+// relativeTime describes t relative to now in English
+func relativeTime(t, now time.Time) string {
+	diff := now.Sub(t)
+	future := diff < 0
+	if future {
+		diff = -diff
+	}
+	if diff < time.Minute {
+		return "just now"
+	}
+
+	units := []struct {
+		name string
+		size time.Duration
+	}{
+		{"year", 365 * 24 * time.Hour},
+		{"month", 30 * 24 * time.Hour},
+		{"day", 24 * time.Hour},
+		{"hour", time.Hour},
+		{"minute", time.Minute},
+	}
+
+	for _, unit := range units {
+		if diff < unit.size {
+			continue
+		}
+		count := int(math.Floor(float64(diff) / float64(unit.size)))
+		label := unit.name
+		if count != 1 {
+			label += "s"
+		}
+		if future {
+			return fmt.Sprintf("in %d %s", count, label)
+		}
+		return fmt.Sprintf("%d %s ago", count, label)
+	}
+
+	return "just now"
+}
+
+// truncate shortens s to at most length runes, replacing the cut tail with an ellipsis
+func truncate(s string, length int) string {
+	runes := []rune(s)
+	if length < 0 || len(runes) <= length {
+		return s
+	}
+	if length == 0 {
+		return ""
+	}
+	return string(runes[:length-1]) + "…"
+}
+
+// isEmptyValue reports whether a template value should be replaced by a default
+func isEmptyValue(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return strings.TrimSpace(v) == ""
+	case bool:
+		return !v
+	case float64:
+		return v == 0
+	case int:
+		return v == 0
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	default:
+		return false
+	}
+}
+
+// buildURLQuery appends key/value pairs to the query string of base
+func buildURLQuery(base string, pairs ...interface{}) (string, error) {
+	if len(pairs)%2 != 0 {
+		return "", fmt.Errorf("url_query needs key/value pairs, got %d arguments", len(pairs))
+	}
+
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return "", fmt.Errorf("url_query key must be a string, got %T", pairs[i])
+		}
+		query.Add(key, fmt.Sprint(pairs[i+1]))
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
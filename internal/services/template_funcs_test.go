@@ -0,0 +1,94 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"notification-service/internal/models"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	now := time.Date(2024, 3, 5, 12, 0, 0, 0, time.UTC)
+	data := models.JSON{
+		"Amount":      float64(1234.5),
+		"At":          "2024-03-05T09:00:00Z",
+		"Description": "A very long description",
+		"Empty":       "",
+		"Items":       []interface{}{"a", "b"},
+		"Name":        "jane doe",
+		"Query":       "a&b",
+	}
+
+	tests := []struct {
+		name     string
+		locale   string
+		source   string
+		expected string
+	}{
+		{"Currency", "en-US", `{{format_currency .Amount "USD"}}`, "$ 1,234.50"},
+		{"Currency in German", "de-DE", `{{format_currency .Amount "EUR"}}`, "€ 1.234,50"},
+		{"Number with decimals", "en-US", `{{format_number .Amount 0}}`, "1,235"},
+		{"Time with timezone", "en-US", `{{format_time .At "datetime" "Europe/Berlin"}}`, "2024-03-05 10:00"},
+		{"Time with Go layout", "en-US", `{{format_time .At "Jan 2 15:04"}}`, "Mar 5 09:00"},
+		{"Relative time", "en-US", `{{relative_time .At}}`, "3 hours ago"},
+		{"Truncate", "en-US", `{{.Description | truncate 10}}`, "A very lo…"},
+		{"Default for empty value", "en-US", `{{.Empty | default "n/a"}}`, "n/a"},
+		{"Default for missing value", "en-US", `{{.Missing | default "n/a"}}`, "n/a"},
+		{"Default keeps value", "en-US", `{{.Name | default "n/a"}}`, "jane doe"},
+		{"Case helpers", "en-US", `{{upper .Name}} {{lower "ABC"}} {{title .Name}}`, "JANE DOE abc Jane Doe"},
+		{"URL query", "en-US", `{{url_query "https://example.com/p?x=1" "q" .Query "n" 2}}`, "https://example.com/p?n=2&q=a%26b&x=1"},
+		{"JSON", "en-US", `{{json .Items}}`, `["a","b"]`},
+		{"Locale", "pt-BR", `{{locale}}`, "pt-BR"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := &renderContext{Funcs: templateFuncs(tt.locale, now)}
+			result, err := renderTemplate("test", tt.source, TextFormat, data, ctx)
+			if err != nil {
+				t.Fatalf("Failed to render template: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected '%s', got '%s'", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestRelativeTime(t *testing.T) {
+	now := time.Date(2024, 3, 5, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		at       time.Time
+		expected string
+	}{
+		{now.Add(-30 * time.Second), "just now"},
+		{now.Add(-1 * time.Minute), "1 minute ago"},
+		{now.Add(-49 * time.Hour), "2 days ago"},
+		{now.Add(2 * time.Hour), "in 2 hours"},
+		{now.Add(-400 * 24 * time.Hour), "1 year ago"},
+	}
+
+	for _, tt := range tests {
+		if result := relativeTime(tt.at, now); result != tt.expected {
+			t.Errorf("relativeTime(%v): expected '%s', got '%s'", tt.at, tt.expected, result)
+		}
+	}
+}
+
+func TestTemplateFuncsAreDocumented(t *testing.T) {
+	funcs := templateFuncs("en", time.Now())
+	docs := TemplateFuncs()
+
+	if len(docs) != len(funcs) {
+		t.Fatalf("Expected %d documented functions, got %d", len(funcs), len(docs))
+	}
+	for _, doc := range docs {
+		if _, ok := funcs[doc.Name]; !ok {
+			t.Errorf("Documented function %s is not available", doc.Name)
+		}
+		if doc.Signature == "" || doc.Description == "" {
+			t.Errorf("Function %s is missing its signature or description", doc.Name)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template/parse"
+
+	"notification-service/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// templateDependencies holds the layout and partials a template needs at render time
+type templateDependencies struct {
+	Layout   *models.Template
+	Partials map[string]*models.Template
+}
+
+// textContext returns the render context for plain-text and channel parts
+func (d *templateDependencies) textContext(funcs map[string]interface{}, limits TemplateLimits) *renderContext {
+	ctx := &renderContext{Funcs: funcs, Limits: limits, Partials: make(map[string]string, len(d.Partials))}
+	for name, partial := range d.Partials {
+		ctx.Partials[name] = partial.Content
+	}
+	if d.Layout != nil {
+		ctx.Layout = d.Layout.Content
+	}
+	return ctx
+}
+
+// htmlContext returns the render context for HTML parts, preferring the HTML
+// source of each partial and layout
+func (d *templateDependencies) htmlContext(funcs map[string]interface{}, limits TemplateLimits) *renderContext {
+	ctx := &renderContext{Funcs: funcs, Limits: limits, Partials: make(map[string]string, len(d.Partials))}
+	for name, partial := range d.Partials {
+		ctx.Partials[name] = partial.HTMLContent
+		if ctx.Partials[name] == "" {
+			ctx.Partials[name] = partial.Content
+		}
+	}
+	if d.Layout != nil {
+		ctx.Layout = d.Layout.HTMLContent
+	}
+	return ctx
+}
+
+// subjectContext returns the render context for subjects, which never use a layout
+func (d *templateDependencies) subjectContext(funcs map[string]interface{}, limits TemplateLimits) *renderContext {
+	ctx := d.textContext(funcs, limits)
+	ctx.Layout = ""
+	return ctx
+}
+
+// templateFinder loads a tenant's layout or partial by name
+type templateFinder func(tenantID, name string, kind models.TemplateKind) (*models.Template, error)
+
+// ResolveTemplateDependencies validates a template's sources, then loads the layout
+// and every partial it references, directly or through other partials, from the
+// template's tenant, rejecting missing references and include cycles
+func (s *NotificationService) ResolveTemplateDependencies(tmpl *models.Template) (*templateDependencies, error) {
+	return resolveTemplateDependencies(tmpl, s.findTemplateByKind)
+}
+
+// resolveTemplateDependencies resolves a template's layout and partials with find
+func resolveTemplateDependencies(tmpl *models.Template, find templateFinder) (*templateDependencies, error) {
+	deps := &templateDependencies{Partials: make(map[string]*models.Template)}
+
+	if err := validateTemplateSources(templateSources(tmpl)...); err != nil {
+		return nil, err
+	}
+
+	refs, err := templateReferences(templateSources(tmpl)...)
+	if err != nil {
+		return nil, err
+	}
+
+	if tmpl.Layout != "" {
+		layout, err := find(tmpl.TenantID, tmpl.Layout, models.LayoutTemplate)
+		if err != nil {
+			return nil, err
+		}
+		layoutRefs, err := templateReferences(templateSources(layout)...)
+		if err != nil {
+			return nil, fmt.Errorf("layout %s: %w", layout.Name, err)
+		}
+		deps.Layout = layout
+		refs = append(refs, layoutRefs...)
+	}
+
+	state := make(map[string]int)
+	path := []string{tmpl.Name}
+	if tmpl.Kind == models.PartialTemplate {
+		state[tmpl.Name] = visiting
+	}
+	for _, ref := range refs {
+		if err := resolvePartial(find, tmpl.TenantID, ref, deps, state, path); err != nil {
+			return nil, err
+		}
+	}
+
+	return deps, nil
+}
+
+const (
+	visiting = iota + 1
+	visited
+)
+
+// resolvePartial loads a tenant's partial and, depth first, everything it includes
+func resolvePartial(find templateFinder, tenantID, name string, deps *templateDependencies, state map[string]int, path []string) error {
+	if name == layoutContentName {
+		return nil
+	}
+
+	path = append(path, name)
+	switch state[name] {
+	case visiting:
+		return fmt.Errorf("template include cycle: %s", strings.Join(path, " → "))
+	case visited:
+		return nil
+	}
+	state[name] = visiting
+
+	partial, err := find(tenantID, name, models.PartialTemplate)
+	if err != nil {
+		return err
+	}
+	refs, err := templateReferences(templateSources(partial)...)
+	if err != nil {
+		return fmt.Errorf("partial %s: %w", name, err)
+	}
+	for _, ref := range refs {
+		if err := resolvePartial(find, tenantID, ref, deps, state, path); err != nil {
+			return err
+		}
+	}
+
+	state[name] = visited
+	deps.Partials[name] = partial
+	return nil
+}
+
+// findTemplateByKind loads a tenant's layout or partial by name
+func (s *NotificationService) findTemplateByKind(tenantID, name string, kind models.TemplateKind) (*models.Template, error) {
+	var tmpl models.Template
+	if err := s.db.Where("tenant_id = ? AND name = ? AND kind = ?", tenantID, name, kind).First(&tmpl).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%s %q not found", kind, name)
+		}
+		return nil, err
+	}
+	return &tmpl, nil
+}
+
+// TemplateDependents returns the names of a tenant's active templates that use the
+// named layout or partial
+func (s *NotificationService) TemplateDependents(tenantID, name string) ([]string, error) {
+	var templates []models.Template
+	if err := s.db.Preload("Variants").Where("tenant_id = ? AND is_active = ? AND name <> ?", tenantID, true, name).Find(&templates).Error; err != nil {
+		return nil, err
+	}
+
+	return templateDependents(templates, name), nil
+}
+
+// templateDependents returns the names of the active templates that use the named
+// layout or partial
+func templateDependents(templates []models.Template, name string) []string {
+	var dependents []string
+	for i := range templates {
+		if !templates[i].IsActive || templates[i].Name == name {
+			continue
+		}
+		if templates[i].Layout == name {
+			dependents = append(dependents, templates[i].Name)
+			continue
+		}
+		refs, err := templateReferences(templateSources(&templates[i])...)
+		if err != nil {
+			continue
+		}
+		for _, ref := range refs {
+			if ref == name {
+				dependents = append(dependents, templates[i].Name)
+				break
+			}
+		}
+	}
+
+	sort.Strings(dependents)
+	return dependents
+}
+
+// TemplateUpdateReleases reports whether updating a layout or partial to the given
+// name, kind and active state would take it away from the templates that use it
+func TemplateUpdateReleases(tmpl *models.Template, name string, kind models.TemplateKind, isActive *bool) bool {
+	if tmpl.Kind != models.LayoutTemplate && tmpl.Kind != models.PartialTemplate {
+		return false
+	}
+	renamed := name != tmpl.Name || kind != tmpl.Kind
+	deactivated := isActive != nil && !*isActive && tmpl.IsActive
+	return renamed || deactivated
+}
+
+// templateSources returns every source a template renders, including its variants
+func templateSources(tmpl *models.Template) []string {
+	sources := []string{tmpl.Subject, tmpl.Content, tmpl.HTMLContent, tmpl.Blocks}
+	for _, variant := range tmpl.Variants {
+		sources = append(sources, variant.Subject, variant.Content, variant.HTMLContent)
+	}
+	return sources
+}
+
+// templateReferences returns the names included with {{template}} by the given
+// sources, excluding templates a source defines for itself
+func templateReferences(sources ...string) ([]string, error) {
+	seen := make(map[string]bool)
+	var refs []string
+
+	for _, source := range sources {
+		if source == "" {
+			continue
+		}
+		tree := parse.New("source")
+		tree.Mode = parse.SkipFuncCheck
+		treeSet := make(map[string]*parse.Tree)
+		if _, err := tree.Parse(source, "", "", treeSet); err != nil {
+			return nil, err
+		}
+		trees := []*parse.Tree{tree}
+		defined := make(map[string]bool)
+		for name, t := range treeSet {
+			if t != tree {
+				defined[name] = true
+				trees = append(trees, t)
+			}
+		}
+		for _, t := range trees {
+			collectReferences(t.Root, func(name string) {
+				if !defined[name] && !seen[name] {
+					seen[name] = true
+					refs = append(refs, name)
+				}
+			})
+		}
+	}
+
+	return refs, nil
+}
+
+// collectReferences walks a parse tree calling found for every {{template}} action
+func collectReferences(node parse.Node, found func(string)) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			collectReferences(child, found)
+		}
+	case *parse.TemplateNode:
+		found(n.Name)
+	case *parse.IfNode:
+		collectReferences(n.List, found)
+		collectReferences(n.ElseList, found)
+	case *parse.RangeNode:
+		collectReferences(n.List, found)
+		collectReferences(n.ElseList, found)
+	case *parse.WithNode:
+		collectReferences(n.List, found)
+		collectReferences(n.ElseList, found)
+	}
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"notification-service/internal/models"
+)
+
+func TestTemplateReferences(t *testing.T) {
+	refs, err := templateReferences(
+		`{{template "header" .}}{{if .Show}}{{template "promo" .}}{{else}}{{template "fallback" .}}{{end}}`,
+		`{{define "local"}}x{{end}}{{range .Items}}{{template "local" .}}{{template "header" .}}{{end}}`,
+		`{{format_date .Date}}{{template "footer" .}}`,
+	)
+	if err != nil {
+		t.Fatalf("Failed to collect references: %v", err)
+	}
+
+	expected := []string{"header", "promo", "fallback", "footer"}
+	if !reflect.DeepEqual(refs, expected) {
+		t.Errorf("Expected %v, got %v", expected, refs)
+	}
+}
+
+func TestTemplateReferencesRejectsInvalidSyntax(t *testing.T) {
+	if _, err := templateReferences(`{{template "footer" .}`); err == nil {
+		t.Error("Expected a parse error for an unterminated action")
+	}
+}
+
+func TestTemplateReferencesTracksDefinitionsPerSource(t *testing.T) {
+	refs, err := templateReferences(`{{define "promo"}}x{{end}}{{template "promo" .}}`, `{{template "promo" .}}`)
+	if err != nil {
+		t.Fatalf("Failed to collect references: %v", err)
+	}
+
+	expected := []string{"promo"}
+	if !reflect.DeepEqual(refs, expected) {
+		t.Errorf("Expected a definition in one source not to hide a reference from another, got %v", refs)
+	}
+}
+
+// fakeTemplateFinder looks templates up in memory by kind and name
+func fakeTemplateFinder(templates ...models.Template) templateFinder {
+	return func(tenantID, name string, kind models.TemplateKind) (*models.Template, error) {
+		for i := range templates {
+			if templates[i].TenantID == tenantID && templates[i].Name == name && templates[i].Kind == kind {
+				return &templates[i], nil
+			}
+		}
+		return nil, fmt.Errorf("%s %q not found", kind, name)
+	}
+}
+
+func TestResolveTemplateDependenciesRejectsCycles(t *testing.T) {
+	partial := func(name, content string) models.Template {
+		return models.Template{TenantID: models.DefaultTenant, Name: name, Kind: models.PartialTemplate, Content: content}
+	}
+
+	tests := []struct {
+		name     string
+		partials []models.Template
+		expected string
+	}{
+		{
+			name:     "mutual",
+			partials: []models.Template{partial("a", `{{template "b" .}}`), partial("b", `{{template "a" .}}`)},
+			expected: "welcome → a → b → a",
+		},
+		{
+			name:     "self",
+			partials: []models.Template{partial("a", `{{template "a" .}}`)},
+			expected: "welcome → a → a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl := &models.Template{TenantID: models.DefaultTenant, Name: "welcome", Kind: models.MessageTemplate, Content: `{{template "a" .}}`}
+			_, err := resolveTemplateDependencies(tmpl, fakeTemplateFinder(tt.partials...))
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected include cycle %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestResolveTemplateDependenciesRejectsPartialIncludingItself(t *testing.T) {
+	tmpl := &models.Template{TenantID: models.DefaultTenant, Name: "a", Kind: models.PartialTemplate, Content: `{{template "b" .}}`}
+	finder := fakeTemplateFinder(models.Template{TenantID: models.DefaultTenant, Name: "b", Kind: models.PartialTemplate, Content: `{{template "a" .}}`})
+
+	_, err := resolveTemplateDependencies(tmpl, finder)
+	if err == nil || !strings.Contains(err.Error(), "a → b → a") {
+		t.Errorf("Expected an include cycle through the partial being saved, got %v", err)
+	}
+}
+
+func TestResolveTemplateDependenciesRejectsMissingPartial(t *testing.T) {
+	tmpl := &models.Template{TenantID: "team-a", Name: "welcome", Kind: models.MessageTemplate, Content: `{{template "footer" .}}`}
+	finder := fakeTemplateFinder(models.Template{TenantID: models.DefaultTenant, Name: "footer", Kind: models.PartialTemplate, Content: "x"})
+
+	if _, err := resolveTemplateDependencies(tmpl, finder); err == nil {
+		t.Error("Expected a partial from another tenant not to resolve")
+	}
+}
+
+func TestRenderTemplateThroughLayout(t *testing.T) {
+	finder := fakeTemplateFinder(
+		models.Template{TenantID: models.DefaultTenant, Name: "base", Kind: models.LayoutTemplate,
+			Content:     `[{{template "content" .}}]{{template "footer" .}}`,
+			HTMLContent: `<main>{{template "content" .}}</main>{{template "footer" .}}`},
+		models.Template{TenantID: models.DefaultTenant, Name: "footer", Kind: models.PartialTemplate,
+			Content: ` -- {{.Team}}`, HTMLContent: `<footer>{{.Team}}</footer>`},
+	)
+	tmpl := &models.Template{TenantID: models.DefaultTenant, Name: "welcome", Kind: models.MessageTemplate, Layout: "base",
+		Subject: "Hi {{.Name}}", Content: "Hello {{.Name}}", HTMLContent: "<p>Hello {{.Name}}</p>"}
+
+	deps, err := resolveTemplateDependencies(tmpl, finder)
+	if err != nil {
+		t.Fatalf("Failed to resolve dependencies: %v", err)
+	}
+
+	data := models.JSON{"Name": "Ada", "Team": "Ops"}
+	funcs := newFuncEnv("en", time.Now()).funcs()
+
+	text, err := renderTemplate("content", tmpl.Content, TextFormat, data, deps.textContext(funcs, TemplateLimits{}))
+	if err != nil {
+		t.Fatalf("Failed to render text: %v", err)
+	}
+	if text != "[Hello Ada] -- Ops" {
+		t.Errorf("Expected the text content wrapped in the layout, got %q", text)
+	}
+
+	html, err := renderTemplate("html_content", tmpl.HTMLContent, HTMLFormat, data, deps.htmlContext(funcs, TemplateLimits{}))
+	if err != nil {
+		t.Fatalf("Failed to render HTML: %v", err)
+	}
+	if html != "<main><p>Hello Ada</p></main><footer>Ops</footer>" {
+		t.Errorf("Expected the HTML content wrapped in the layout, got %q", html)
+	}
+
+	subject, err := renderTemplate("subject", tmpl.Subject, TextFormat, data, deps.subjectContext(funcs, TemplateLimits{}))
+	if err != nil {
+		t.Fatalf("Failed to render subject: %v", err)
+	}
+	if subject != "Hi Ada" {
+		t.Errorf("Expected the subject rendered without the layout, got %q", subject)
+	}
+}
+
+func TestTemplateDependents(t *testing.T) {
+	templates := []models.Template{
+		{Name: "welcome", IsActive: true, Layout: "base", Content: "Hello"},
+		{Name: "reset", IsActive: true, Content: `{{template "footer" .}}`},
+		{Name: "digest", IsActive: true, Variants: []models.TemplateVariant{{Content: `{{template "footer" .}}`}}},
+		{Name: "archived", IsActive: false, Layout: "base", Content: `{{template "footer" .}}`},
+		{Name: "own", IsActive: true, Content: `{{define "footer"}}x{{end}}{{template "footer" .}}`},
+		{Name: "footer", IsActive: true, Kind: models.PartialTemplate, Content: "x"},
+	}
+
+	if got := templateDependents(templates, "base"); !reflect.DeepEqual(got, []string{"welcome"}) {
+		t.Errorf("Expected the active layout users, got %v", got)
+	}
+	if got := templateDependents(templates, "footer"); !reflect.DeepEqual(got, []string{"digest", "reset"}) {
+		t.Errorf("Expected the active partial users, got %v", got)
+	}
+	if got := templateDependents(templates, "unused"); len(got) != 0 {
+		t.Errorf("Expected no dependents, got %v", got)
+	}
+}
+
+func TestTemplateUpdateReleases(t *testing.T) {
+	inactive := false
+	active := true
+	layout := &models.Template{Name: "base", Kind: models.LayoutTemplate, IsActive: true}
+	partial := &models.Template{Name: "footer", Kind: models.PartialTemplate, IsActive: true}
+	message := &models.Template{Name: "welcome", Kind: models.MessageTemplate, IsActive: true}
+
+	tests := []struct {
+		name     string
+		tmpl     *models.Template
+		newName  string
+		kind     models.TemplateKind
+		isActive *bool
+		expected bool
+	}{
+		{"layout content edit", layout, "base", models.LayoutTemplate, &active, false},
+		{"layout renamed", layout, "base-v2", models.LayoutTemplate, nil, true},
+		{"layout deactivated", layout, "base", models.LayoutTemplate, &inactive, true},
+		{"partial re-kinded", partial, "footer", models.MessageTemplate, nil, true},
+		{"partial deactivated", partial, "footer", models.PartialTemplate, &inactive, true},
+		{"message renamed", message, "hello", models.MessageTemplate, &inactive, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TemplateUpdateReleases(tt.tmpl, tt.newName, tt.kind, tt.isActive); got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
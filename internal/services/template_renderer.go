@@ -0,0 +1,219 @@
+package services
+
+import (
+	"encoding/json"
+	"html"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+	"text/template/parse"
+	"unicode"
+
+	"notification-service/internal/models"
+)
+
+// ContentFormat identifies the output format a template part is rendered for
+type ContentFormat string
+
+const (
+	TextFormat          ContentFormat = "text"
+	HTMLFormat          ContentFormat = "html"
+	SanitizedHTMLFormat ContentFormat = "sanitized_html"
+	SlackFormat         ContentFormat = "slack"
+	SlackBlocksFormat   ContentFormat = "slack_blocks"
+	MarkdownFormat      ContentFormat = "markdown"
+	SMSFormat           ContentFormat = "sms"
+)
+
+// renderContext carries everything a template part needs besides its own source
+type renderContext struct {
+	// Funcs are the helper functions available to the template
+	Funcs map[string]interface{}
+	// Partials maps partial names to their source for {{template "name" .}}
+	Partials map[string]string
+	// Layout wraps the part, which the layout includes with {{template "content" .}}
+	Layout string
+	// Limits bounds the time, output and iterations the render may use
+	Limits TemplateLimits
+}
+
+// layoutContentName is the name a layout uses to include the template it wraps
+const layoutContentName = "content"
+
+// layoutRootName is the name a layout renders under when the part it wraps is itself
+// named "content"
+const layoutRootName = "content layout"
+
+// renderTemplate renders a template part with the engine appropriate for the format.
+// HTML is rendered with html/template; every other format uses text/template with the
+// string values in data escaped for the target channel. Plain templates rendered for
+// sanitized HTML are plain text, so their whole output is HTML-escaped. Every render
+// runs in a sandbox that enforces ctx.Limits, and returns once ctx.Limits.Timeout is
+// spent even if a helper call is still running. ctx may be nil.
+func renderTemplate(name, source string, format ContentFormat, data models.JSON, ctx *renderContext) (string, error) {
+	if ctx == nil {
+		ctx = &renderContext{}
+	}
+	// The layout includes the part as "content", so it must render under another name
+	if ctx.Layout != "" && name == layoutContentName {
+		name = layoutRootName
+	}
+
+	sandbox := newTemplateSandbox(ctx.Limits)
+	funcs := sandbox.funcs(ctx.Funcs)
+
+	trees, err := parseTemplateSet(name, source, ctx, funcs)
+	if err != nil {
+		return "", err
+	}
+	if err := sandbox.instrument(trees, funcs); err != nil {
+		return "", err
+	}
+
+	out := sandbox.writer()
+	if format == HTMLFormat {
+		t := htmltemplate.New(name).Funcs(funcs)
+		for treeName, tree := range trees {
+			if _, err := t.AddParseTree(treeName, tree); err != nil {
+				return "", err
+			}
+		}
+		// AddParseTree registers the root under its name rather than on t itself
+		err = sandbox.run(func() error {
+			return t.Lookup(name).Execute(out, data)
+		})
+	} else {
+		t := texttemplate.New(name).Funcs(funcs)
+		for treeName, tree := range trees {
+			if _, err := t.AddParseTree(treeName, tree); err != nil {
+				return "", err
+			}
+		}
+		err = sandbox.run(func() error {
+			return t.Execute(out, escapeData(data, escaperFor(format)))
+		})
+	}
+	if err != nil {
+		return "", sandbox.explain(err)
+	}
+
+	if format == SanitizedHTMLFormat {
+		return html.EscapeString(out.String()), nil
+	}
+	return out.String(), nil
+}
+
+// parseTemplateSet parses a part together with its layout and partials, returning
+// the parse tree of every template in the set keyed by name
+func parseTemplateSet(name, source string, ctx *renderContext, funcs map[string]interface{}) (map[string]*parse.Tree, error) {
+	t := texttemplate.New(name).Funcs(funcs)
+	if ctx.Layout != "" {
+		if _, err := t.Parse(ctx.Layout); err != nil {
+			return nil, err
+		}
+		if _, err := t.New(layoutContentName).Parse(source); err != nil {
+			return nil, err
+		}
+	} else if _, err := t.Parse(source); err != nil {
+		return nil, err
+	}
+	for partialName, partial := range ctx.Partials {
+		if _, err := t.New(partialName).Parse(partial); err != nil {
+			return nil, err
+		}
+	}
+
+	trees := make(map[string]*parse.Tree)
+	for _, defined := range t.Templates() {
+		if defined.Tree != nil {
+			trees[defined.Name()] = defined.Tree
+		}
+	}
+	return trees, nil
+}
+
+// escaperFor returns the escaping function applied to template data for a format
+func escaperFor(format ContentFormat) func(string) string {
+	switch format {
+	case SlackFormat:
+		return escapeSlack
+	case SlackBlocksFormat:
+		return escapeSlackJSON
+	case SMSFormat:
+		return escapeSMS
+	case MarkdownFormat:
+		return escapeMarkdown
+	default:
+		return nil
+	}
+}
+
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// escapeSlack escapes the control characters of Slack mrkdwn so user data
+// cannot form links, mentions or channel references
+func escapeSlack(s string) string {
+	return slackEscaper.Replace(s)
+}
+
+// escapeSlackJSON escapes a value for Slack mrkdwn inside a JSON string, so user
+// data can neither form Slack links nor break out of the string it is placed in
+func escapeSlackJSON(s string) string {
+	encoded, _ := json.Marshal(escapeSlack(s))
+	return string(encoded[1 : len(encoded)-1])
+}
+
+var smsReplacer = strings.NewReplacer(
+	"\u2018", "'", "\u2019", "'",
+	"\u201c", "\"", "\u201d", "\"",
+	"\u2013", "-", "\u2014", "-",
+	"\u2026", "...",
+	"\u00a0", " ",
+)
+
+// escapeSMS replaces typographic punctuation with its GSM 03.38 equivalent and
+// drops control characters other than newlines
+func escapeSMS(s string) string {
+	s = smsReplacer.Replace(s)
+	return strings.Map(func(r rune) rune {
+		if r != '\n' && unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// escapeData returns a copy of data with every string value passed through escape
+func escapeData(data models.JSON, escape func(string) string) models.JSON {
+	if escape == nil || data == nil {
+		return data
+	}
+	return escapeValue(data, escape).(models.JSON)
+}
+
+func escapeValue(value interface{}, escape func(string) string) interface{} {
+	switch v := value.(type) {
+	case string:
+		return escape(v)
+	case models.JSON:
+		out := make(models.JSON, len(v))
+		for key, item := range v {
+			out[key] = escapeValue(item, escape)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			out[key] = escapeValue(item, escape)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = escapeValue(item, escape)
+		}
+		return out
+	default:
+		return value
+	}
+}
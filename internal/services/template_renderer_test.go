@@ -0,0 +1,115 @@
+package services
+
+import (
+	"testing"
+
+	"notification-service/internal/models"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	data := models.JSON{
+		"Name":    "O'Brien <ops>",
+		"Company": "Smith & Sons",
+	}
+
+	tests := []struct {
+		name     string
+		source   string
+		format   ContentFormat
+		expected string
+	}{
+		{
+			name:     "Plain text is not escaped",
+			source:   "Hello {{.Name}} from {{.Company}}",
+			format:   TextFormat,
+			expected: "Hello O'Brien <ops> from Smith & Sons",
+		},
+		{
+			name:     "Slack escapes mrkdwn control characters",
+			source:   "*Alert* for {{.Name}} at {{.Company}} <https://example.com|details>",
+			format:   SlackFormat,
+			expected: "*Alert* for O'Brien &lt;ops&gt; at Smith &amp; Sons <https://example.com|details>",
+		},
+		{
+			name:     "SMS keeps apostrophes and angle brackets",
+			source:   "Hi {{.Name}}",
+			format:   SMSFormat,
+			expected: "Hi O'Brien <ops>",
+		},
+		{
+			name:     "HTML is escaped by html/template",
+			source:   "<p>Hello {{.Name}} from {{.Company}}</p>",
+			format:   HTMLFormat,
+			expected: "<p>Hello O&#39;Brien &lt;ops&gt; from Smith &amp; Sons</p>",
+		},
+		{
+			name:     "Plain text for sanitized HTML is escaped as a whole",
+			source:   "<b>Hello</b> {{.Name}} from {{.Company}}",
+			format:   SanitizedHTMLFormat,
+			expected: "&lt;b&gt;Hello&lt;/b&gt; O&#39;Brien &lt;ops&gt; from Smith &amp; Sons",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := renderTemplate("test", tt.source, tt.format, data, nil)
+			if err != nil {
+				t.Fatalf("Failed to render template: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected '%s', got '%s'", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestRenderTemplateSMSNormalizesPunctuation(t *testing.T) {
+	data := models.JSON{"Message": "It’s “done”…\x07"}
+
+	result, err := renderTemplate("test", "{{.Message}}", SMSFormat, data, nil)
+	if err != nil {
+		t.Fatalf("Failed to render template: %v", err)
+	}
+
+	expected := "It's \"done\"..."
+	if result != expected {
+		t.Errorf("Expected '%s', got '%s'", expected, result)
+	}
+}
+
+func TestWithDeclaredVariables(t *testing.T) {
+	data := withDeclaredVariables(
+		models.JSON{"Name": "string", "ActionUrl": "string"},
+		models.JSON{"Name": "Jane"},
+	)
+
+	result, err := renderTemplate("test", "{{.Name}}:{{.ActionUrl}}", TextFormat, data, nil)
+	if err != nil {
+		t.Fatalf("Failed to render template: %v", err)
+	}
+
+	if result != "Jane:" {
+		t.Errorf("Expected 'Jane:', got '%s'", result)
+	}
+}
+
+func TestRenderTemplateWithLayoutAndPartials(t *testing.T) {
+	ctx := &renderContext{
+		Layout: "<h1>Acme</h1>{{template \"content\" .}}{{template \"footer\" .}}",
+		Partials: map[string]string{
+			"footer": "<p>Sent to {{.Email}}</p>{{template \"legal\" .}}",
+			"legal":  "<small>Acme Inc.</small>",
+		},
+	}
+	data := models.JSON{"Name": "Jane", "Email": "jane@example.com"}
+
+	result, err := renderTemplate("html_content", "<p>Hi {{.Name}}</p>", HTMLFormat, data, ctx)
+	if err != nil {
+		t.Fatalf("Failed to render template: %v", err)
+	}
+
+	expected := "<h1>Acme</h1><p>Hi Jane</p><p>Sent to jane@example.com</p><small>Acme Inc.</small>"
+	if result != expected {
+		t.Errorf("Expected '%s', got '%s'", expected, result)
+	}
+}
@@ -0,0 +1,333 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"text/template/parse"
+	"time"
+)
+
+// ErrTemplateLimitExceeded is returned when a template render exceeds its resource limits
+var ErrTemplateLimitExceeded = errors.New("template resource limit exceeded")
+
+// ErrTemplateDisallowed is returned when a template uses a construct the sandbox rejects
+var ErrTemplateDisallowed = errors.New("template construct not allowed")
+
+// TemplateLimits bounds the resources a single template render may use. Zero values
+// disable the corresponding limit.
+type TemplateLimits struct {
+	// Timeout is the wall-clock budget for rendering one template part. The render
+	// returns once it is spent; an execution abandoned inside a slow helper call
+	// stops at its next iteration or write.
+	Timeout time.Duration
+	// MaxOutputBytes caps the size of the rendered output
+	MaxOutputBytes int
+	// MaxIterations caps range iterations plus {{template}} invocations
+	MaxIterations int
+}
+
+// sandboxTickFunc is the helper injected into templates to count iterations
+const sandboxTickFunc = "_sandbox_tick"
+
+// disallowedFuncs are builtins templates may not call. call would let data invoke
+// arbitrary functions.
+var disallowedFuncs = map[string]bool{
+	"call": true,
+}
+
+// templateSandbox enforces TemplateLimits on a single render
+type templateSandbox struct {
+	limits     TemplateLimits
+	deadline   time.Time
+	iterations int
+
+	mu  sync.Mutex
+	err error
+}
+
+// newTemplateSandbox starts the clock for a render
+func newTemplateSandbox(limits TemplateLimits) *templateSandbox {
+	s := &templateSandbox{limits: limits}
+	if limits.Timeout > 0 {
+		s.deadline = time.Now().Add(limits.Timeout)
+	}
+	return s
+}
+
+// funcs returns funcs extended with the sandbox's own helpers
+func (s *templateSandbox) funcs(funcs map[string]interface{}) map[string]interface{} {
+	extended := make(map[string]interface{}, len(funcs)+1)
+	for name, fn := range funcs {
+		extended[name] = fn
+	}
+	extended[sandboxTickFunc] = s.tick
+	return extended
+}
+
+// tick counts one iteration and fails once a limit is exceeded
+func (s *templateSandbox) tick() (bool, error) {
+	s.iterations++
+	if s.limits.MaxIterations > 0 && s.iterations > s.limits.MaxIterations {
+		return false, s.fail(fmt.Errorf("%w: more than %d iterations", ErrTemplateLimitExceeded, s.limits.MaxIterations))
+	}
+	return false, s.checkDeadline()
+}
+
+// checkDeadline fails once the render has run past its timeout
+func (s *templateSandbox) checkDeadline() error {
+	if !s.deadline.IsZero() && time.Now().After(s.deadline) {
+		return s.fail(s.timeoutError())
+	}
+	return nil
+}
+
+// timeoutError reports a render that ran past its timeout
+func (s *templateSandbox) timeoutError() error {
+	return fmt.Errorf("%w: rendering took longer than %s", ErrTemplateLimitExceeded, s.limits.Timeout)
+}
+
+// run executes a render, returning once it finishes or its deadline passes. A render
+// still running at the deadline is left to fail at its next limit check, and its
+// output must not be read.
+func (s *templateSandbox) run(execute func() error) error {
+	if s.deadline.IsZero() {
+		return execute()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- execute()
+	}()
+
+	timer := time.NewTimer(time.Until(s.deadline))
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return s.fail(s.timeoutError())
+	}
+}
+
+// fail records the first limit error so it can be reported without the
+// execution context text/template wraps around it
+func (s *templateSandbox) fail(err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+	return s.err
+}
+
+// explain returns the limit error behind a failed execution, if any
+func (s *templateSandbox) explain(err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	return err
+}
+
+// writer returns an output buffer that enforces the sandbox's limits
+func (s *templateSandbox) writer() *sandboxWriter {
+	return &sandboxWriter{sandbox: s}
+}
+
+// sandboxWriter buffers template output on behalf of a sandbox
+type sandboxWriter struct {
+	bytes.Buffer
+	sandbox *templateSandbox
+}
+
+// Write implements io.Writer, enforcing the output size limit and timeout
+func (w *sandboxWriter) Write(p []byte) (int, error) {
+	if err := w.sandbox.checkDeadline(); err != nil {
+		return 0, err
+	}
+	max := w.sandbox.limits.MaxOutputBytes
+	if max > 0 && w.Len()+len(p) > max {
+		return 0, w.sandbox.fail(fmt.Errorf("%w: output larger than %d bytes", ErrTemplateLimitExceeded, max))
+	}
+	return w.Buffer.Write(p)
+}
+
+// instrument rejects disallowed constructs and recursive includes in a set of
+// parse trees, then injects an iteration check at the start of every template and
+// every range body
+func (s *templateSandbox) instrument(trees map[string]*parse.Tree, funcs map[string]interface{}) error {
+	for name, tree := range trees {
+		if err := checkDisallowed(name, tree.Root); err != nil {
+			return err
+		}
+	}
+	if err := checkRecursion(trees); err != nil {
+		return err
+	}
+
+	// The check is wrapped in an if so that html/template adds no escaping to it
+	tick, err := texttemplate.New("tick").Funcs(funcs).Parse("{{if " + sandboxTickFunc + "}}{{end}}")
+	if err != nil {
+		return err
+	}
+	tickNode := tick.Tree.Root.Nodes[0]
+
+	for _, tree := range trees {
+		injectTicks(tree.Root, tickNode)
+		tree.Root.Nodes = append([]parse.Node{tickNode.Copy()}, tree.Root.Nodes...)
+	}
+	return nil
+}
+
+// injectTicks prepends a copy of tick to every range body below node
+func injectTicks(node parse.Node, tick parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			injectTicks(child, tick)
+		}
+	case *parse.RangeNode:
+		injectTicks(n.List, tick)
+		injectTicks(n.ElseList, tick)
+		n.List.Nodes = append([]parse.Node{tick.Copy()}, n.List.Nodes...)
+	case *parse.IfNode:
+		injectTicks(n.List, tick)
+		injectTicks(n.ElseList, tick)
+	case *parse.WithNode:
+		injectTicks(n.List, tick)
+		injectTicks(n.ElseList, tick)
+	}
+}
+
+// checkDisallowed rejects calls to disallowed builtins anywhere below node
+func checkDisallowed(name string, node parse.Node) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkDisallowed(name, child); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		return checkDisallowed(name, n.Pipe)
+	case *parse.TemplateNode:
+		return checkDisallowed(name, n.Pipe)
+	case *parse.IfNode:
+		return checkDisallowedBranch(name, &n.BranchNode)
+	case *parse.RangeNode:
+		return checkDisallowedBranch(name, &n.BranchNode)
+	case *parse.WithNode:
+		return checkDisallowedBranch(name, &n.BranchNode)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		for _, cmd := range n.Cmds {
+			if err := checkDisallowed(name, cmd); err != nil {
+				return err
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if err := checkDisallowed(name, arg); err != nil {
+				return err
+			}
+		}
+	case *parse.ChainNode:
+		return checkDisallowed(name, n.Node)
+	case *parse.IdentifierNode:
+		if disallowedFuncs[n.Ident] {
+			return fmt.Errorf("%w: %s uses %q", ErrTemplateDisallowed, name, n.Ident)
+		}
+	}
+	return nil
+}
+
+func checkDisallowedBranch(name string, n *parse.BranchNode) error {
+	if err := checkDisallowed(name, n.Pipe); err != nil {
+		return err
+	}
+	if err := checkDisallowed(name, n.List); err != nil {
+		return err
+	}
+	return checkDisallowed(name, n.ElseList)
+}
+
+// checkRecursion rejects templates that include themselves, directly or through others
+func checkRecursion(trees map[string]*parse.Tree) error {
+	graph := make(map[string][]string, len(trees))
+	for name, tree := range trees {
+		collectReferences(tree.Root, func(ref string) {
+			graph[name] = append(graph[name], ref)
+		})
+	}
+
+	state := make(map[string]int)
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		path = append(path, name)
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("%w: recursive template include %s", ErrTemplateDisallowed, strings.Join(path, " → "))
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		for _, ref := range graph[name] {
+			if err := visit(ref, path); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+
+	for name := range trees {
+		if err := visit(name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateTemplateSources parses sources with the real helper functions and
+// rejects syntax errors, unknown functions and constructs the sandbox disallows
+func validateTemplateSources(sources ...string) error {
+	funcs := newTemplateSandbox(TemplateLimits{}).funcs(templateFuncs("en", time.Now()))
+
+	for _, source := range sources {
+		if source == "" {
+			continue
+		}
+		t, err := texttemplate.New("source").Funcs(funcs).Parse(source)
+		if err != nil {
+			return err
+		}
+		trees := make(map[string]*parse.Tree)
+		for _, defined := range t.Templates() {
+			if defined.Tree != nil {
+				trees[defined.Name()] = defined.Tree
+			}
+		}
+		for name, tree := range trees {
+			if err := checkDisallowed(name, tree.Root); err != nil {
+				return err
+			}
+		}
+		if err := checkRecursion(trees); err != nil {
+			return err
+		}
+	}
+	return nil
+}
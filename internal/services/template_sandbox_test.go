@@ -0,0 +1,137 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"notification-service/internal/models"
+)
+
+func TestTemplateSandboxLimits(t *testing.T) {
+	items := make([]interface{}, 50)
+	for i := range items {
+		items[i] = float64(i)
+	}
+	data := models.JSON{"Items": items}
+
+	tests := []struct {
+		name   string
+		source string
+		format ContentFormat
+		limits TemplateLimits
+	}{
+		{
+			name:   "Iterations",
+			source: `{{range .Items}}{{range $.Items}}{{end}}{{end}}`,
+			format: TextFormat,
+			limits: TemplateLimits{MaxIterations: 100},
+		},
+		{
+			name:   "Iterations in HTML",
+			source: `<ul>{{range .Items}}<li>{{.}}</li>{{end}}</ul>`,
+			format: HTMLFormat,
+			limits: TemplateLimits{MaxIterations: 10},
+		},
+		{
+			name:   "Output size",
+			source: `{{range .Items}}0123456789{{end}}`,
+			format: TextFormat,
+			limits: TemplateLimits{MaxOutputBytes: 100},
+		},
+		{
+			name:   "Timeout",
+			source: `{{range .Items}}{{range $.Items}}{{range $.Items}}{{end}}{{end}}{{end}}`,
+			format: TextFormat,
+			limits: TemplateLimits{Timeout: time.Nanosecond},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := renderTemplate("test", tt.source, tt.format, data, &renderContext{Limits: tt.limits})
+			if !errors.Is(err, ErrTemplateLimitExceeded) {
+				t.Fatalf("Expected a limit error, got %v", err)
+			}
+			if strings.Contains(err.Error(), sandboxTickFunc) {
+				t.Errorf("Limit error should not expose sandbox internals: %v", err)
+			}
+		})
+	}
+}
+
+func TestTemplateSandboxTimeoutInterruptsSlowHelpers(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	ctx := &renderContext{
+		Funcs:  map[string]interface{}{"slow": func() string { <-release; return "done" }},
+		Limits: TemplateLimits{Timeout: 50 * time.Millisecond},
+	}
+
+	start := time.Now()
+	_, err := renderTemplate("test", `{{slow}}`, TextFormat, nil, ctx)
+	if !errors.Is(err, ErrTemplateLimitExceeded) {
+		t.Fatalf("Expected a limit error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the render to return at its timeout, took %s", elapsed)
+	}
+}
+
+func TestTemplateSandboxWithinLimits(t *testing.T) {
+	data := models.JSON{"Items": []interface{}{"<a>", "<b>"}}
+	limits := TemplateLimits{Timeout: time.Second, MaxOutputBytes: 100, MaxIterations: 10}
+
+	result, err := renderTemplate("test", `{{range .Items}}<li>{{.}}</li>{{end}}`, HTMLFormat, data, &renderContext{Limits: limits})
+	if err != nil {
+		t.Fatalf("Failed to render template: %v", err)
+	}
+
+	if result != "<li>&lt;a&gt;</li><li>&lt;b&gt;</li>" {
+		t.Errorf("Expected escaped items, got '%s'", result)
+	}
+}
+
+func TestTemplateSandboxRejectsDisallowedConstructs(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		partials map[string]string
+	}{
+		{
+			name:   "call builtin",
+			source: `{{call .Fn}}`,
+		},
+		{
+			name:   "Self-recursive define",
+			source: `{{define "loop"}}{{template "loop" .}}{{end}}{{template "loop" .}}`,
+		},
+		{
+			name:     "Recursive partials",
+			source:   `{{template "a" .}}`,
+			partials: map[string]string{"a": `{{template "b" .}}`, "b": `{{template "a" .}}`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := renderTemplate("test", tt.source, TextFormat, models.JSON{}, &renderContext{Partials: tt.partials})
+			if !errors.Is(err, ErrTemplateDisallowed) {
+				t.Errorf("Expected a disallowed construct error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateTemplateSources(t *testing.T) {
+	if err := validateTemplateSources(`Hello {{.Name | default "there"}}`, ""); err != nil {
+		t.Errorf("Expected valid sources, got %v", err)
+	}
+	if err := validateTemplateSources(`{{unknown_func .Name}}`); err == nil {
+		t.Error("Expected an error for an unknown function")
+	}
+	if err := validateTemplateSources(`{{call .Fn}}`); !errors.Is(err, ErrTemplateDisallowed) {
+		t.Errorf("Expected a disallowed construct error, got %v", err)
+	}
+}
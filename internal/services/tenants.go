@@ -0,0 +1,243 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrInvalidTenant is returned when a tenant ID is empty or malformed
+	ErrInvalidTenant = errors.New("invalid tenant")
+	// ErrQuotaExceeded is returned when a tenant has used up one of its quotas
+	ErrQuotaExceeded = errors.New("tenant quota exceeded")
+	// ErrTenantChannelRequired is returned when a tenant other than the default one
+	// sends without one of its own channels. Only the default tenant may use the
+	// SMTP server, Slack token, from address and DKIM key configured in the environment.
+	ErrTenantChannelRequired = errors.New("tenant must send through one of its configured channels")
+)
+
+// tenantIDPattern limits tenant IDs to short names that are safe in cache keys and logs
+var tenantIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// validateTenantID checks that a tenant ID is well formed
+func validateTenantID(tenantID string) error {
+	if !tenantIDPattern.MatchString(tenantID) {
+		return fmt.Errorf("%w: %q", ErrInvalidTenant, tenantID)
+	}
+	return nil
+}
+
+// channelKey identifies a tenant's channel in the sender caches. Tenant IDs cannot
+// contain a slash, so keys of different tenants never collide.
+func channelKey(tenantID, name string) string {
+	return tenantID + "/" + name
+}
+
+// quotaWindow is the period the notifications-per-day quota counts over
+const quotaWindow = 24 * time.Hour
+
+// TenantQuota holds a tenant's limits, or its usage of them. A limit of 0 is unlimited.
+type TenantQuota struct {
+	// NotificationsPerDay limits the notifications sent or scheduled in the last 24 hours
+	NotificationsPerDay int `json:"notifications_per_day"`
+	Templates           int `json:"templates"`
+	Channels            int `json:"channels"`
+}
+
+// TenantUsage reports a tenant's quotas and how much of them it has used
+type TenantUsage struct {
+	TenantID string      `json:"tenant_id"`
+	Limits   TenantQuota `json:"limits"`
+	Usage    TenantQuota `json:"usage"`
+}
+
+// tenantQuotas holds the quotas every tenant gets and the overrides for some tenants
+type tenantQuotas struct {
+	defaults TenantQuota
+	tenants  map[string]TenantQuota
+}
+
+// tenantQuotaOverride is a tenant's entry in TENANT_QUOTAS_FILE. Limits left out keep
+// the default.
+type tenantQuotaOverride struct {
+	NotificationsPerDay *int `json:"notifications_per_day"`
+	Templates           *int `json:"templates"`
+	Channels            *int `json:"channels"`
+}
+
+// loadTenantQuotas loads the default quotas from TENANT_NOTIFICATIONS_PER_DAY,
+// TENANT_MAX_TEMPLATES and TENANT_MAX_CHANNELS, and the per-tenant overrides from
+// TENANT_QUOTAS_FILE, a JSON object keyed by tenant ID
+func loadTenantQuotas(cfg *config.Config) (*tenantQuotas, error) {
+	quotas := &tenantQuotas{
+		defaults: TenantQuota{
+			NotificationsPerDay: cfg.TenantNotificationsPerDay,
+			Templates:           cfg.TenantMaxTemplates,
+			Channels:            cfg.TenantMaxChannels,
+		},
+		tenants: make(map[string]TenantQuota),
+	}
+	if err := quotas.defaults.validate(); err != nil {
+		return nil, err
+	}
+	if cfg.TenantQuotasFile == "" {
+		return quotas, nil
+	}
+
+	data, err := os.ReadFile(cfg.TenantQuotasFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenant quotas file: %w", err)
+	}
+	var overrides map[string]tenantQuotaOverride
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("failed to parse tenant quotas file: %w", err)
+	}
+	for tenantID, override := range overrides {
+		if err := validateTenantID(tenantID); err != nil {
+			return nil, err
+		}
+		quota := quotas.defaults
+		if override.NotificationsPerDay != nil {
+			quota.NotificationsPerDay = *override.NotificationsPerDay
+		}
+		if override.Templates != nil {
+			quota.Templates = *override.Templates
+		}
+		if override.Channels != nil {
+			quota.Channels = *override.Channels
+		}
+		if err := quota.validate(); err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", tenantID, err)
+		}
+		quotas.tenants[tenantID] = quota
+	}
+	return quotas, nil
+}
+
+// validate checks that no limit is negative
+func (q TenantQuota) validate() error {
+	if q.NotificationsPerDay < 0 || q.Templates < 0 || q.Channels < 0 {
+		return errors.New("tenant quotas cannot be negative")
+	}
+	return nil
+}
+
+// quotaFor returns the quotas of a tenant
+func (q *tenantQuotas) quotaFor(tenantID string) TenantQuota {
+	if quota, ok := q.tenants[tenantID]; ok {
+		return quota
+	}
+	return q.defaults
+}
+
+// checkQuota fails with ErrQuotaExceeded when used has reached a limit
+func checkQuota(limit int, used int64, what string) error {
+	if limit > 0 && used >= int64(limit) {
+		return fmt.Errorf("%w: limit of %d %s reached", ErrQuotaExceeded, limit, what)
+	}
+	return nil
+}
+
+// recentNotifications counts the notifications a tenant sent or scheduled within the
+// quota window. Deleted notifications still count, so deleting them frees no quota.
+func recentNotifications(db *gorm.DB, tenantID string) (int64, error) {
+	var count int64
+	err := db.Unscoped().Model(&models.Notification{}).
+		Where("tenant_id = ? AND created_at > ?", tenantID, time.Now().Add(-quotaWindow)).
+		Count(&count).Error
+	return count, err
+}
+
+// countTenantRecords counts the records of a model that a tenant owns
+func countTenantRecords(db *gorm.DB, model interface{}, tenantID string) (int64, error) {
+	var count int64
+	err := db.Model(model).Where("tenant_id = ?", tenantID).Count(&count).Error
+	return count, err
+}
+
+// lockTenant locks a tenant's row until the end of tx, creating the row first if needed
+func lockTenant(tx *gorm.DB, tenantID string) error {
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.Tenant{ID: tenantID}).Error; err != nil {
+		return err
+	}
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&models.Tenant{}, "id = ?", tenantID).Error
+}
+
+// reserveQuota fails with ErrQuotaExceeded when a tenant's usage has reached limit. The
+// usage is counted while holding the tenant's lock, which tx keeps until it ends, so the
+// record the caller then creates in tx is counted by the next check.
+func reserveQuota(tx *gorm.DB, tenantID string, limit int, what string, used func(db *gorm.DB) (int64, error)) error {
+	if limit == 0 {
+		return nil
+	}
+	if err := lockTenant(tx, tenantID); err != nil {
+		return err
+	}
+	count, err := used(tx)
+	if err != nil {
+		return err
+	}
+	return checkQuota(limit, count, what)
+}
+
+// checkNotificationQuota fails within tx when a tenant cannot send or schedule another
+// notification
+func (s *NotificationService) checkNotificationQuota(tx *gorm.DB, tenantID string) error {
+	return reserveQuota(tx, tenantID, s.quotas.quotaFor(tenantID).NotificationsPerDay, "notifications per day", func(db *gorm.DB) (int64, error) {
+		return recentNotifications(db, tenantID)
+	})
+}
+
+// checkTemplateQuota fails within tx when a tenant cannot create another template
+func (s *NotificationService) checkTemplateQuota(tx *gorm.DB, tenantID string) error {
+	return reserveQuota(tx, tenantID, s.quotas.quotaFor(tenantID).Templates, "templates", func(db *gorm.DB) (int64, error) {
+		return countTenantRecords(db, &models.Template{}, tenantID)
+	})
+}
+
+// CreateTemplate saves a new template if its tenant has quota left for it
+func (s *NotificationService) CreateTemplate(template *models.Template) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.checkTemplateQuota(tx, template.TenantID); err != nil {
+			return err
+		}
+		return tx.Create(template).Error
+	})
+}
+
+// checkChannelQuota fails within tx when a tenant cannot create another channel
+func (s *NotificationService) checkChannelQuota(tx *gorm.DB, tenantID string) error {
+	return reserveQuota(tx, tenantID, s.quotas.quotaFor(tenantID).Channels, "channels", func(db *gorm.DB) (int64, error) {
+		return countTenantRecords(db, &models.Channel{}, tenantID)
+	})
+}
+
+// GetTenantUsage returns a tenant's quotas and its current usage of them
+func (s *NotificationService) GetTenantUsage(tenantID string) (*TenantUsage, error) {
+	usage := &TenantUsage{TenantID: tenantID, Limits: s.quotas.quotaFor(tenantID)}
+
+	notifications, err := recentNotifications(s.db, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	templates, err := countTenantRecords(s.db, &models.Template{}, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	channels, err := countTenantRecords(s.db, &models.Channel{}, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	usage.Usage = TenantQuota{NotificationsPerDay: int(notifications), Templates: int(templates), Channels: int(channels)}
+	return usage, nil
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"notification-service/internal/config"
+
+	"gorm.io/gorm"
+)
+
+func TestValidateTenantID(t *testing.T) {
+	for _, tenantID := range []string{"default", "team-a", "Billing.EU_1"} {
+		if err := validateTenantID(tenantID); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", tenantID, err)
+		}
+	}
+	for _, tenantID := range []string{"", "-team", "team/a", "team a", string(make([]byte, 65))} {
+		if err := validateTenantID(tenantID); !errors.Is(err, ErrInvalidTenant) {
+			t.Errorf("Expected %q to be rejected, got %v", tenantID, err)
+		}
+	}
+}
+
+func TestLoadTenantQuotas(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quotas.json")
+	if err := os.WriteFile(path, []byte(`{"team-a": {"notifications_per_day": 5000, "channels": 0}}`), 0o600); err != nil {
+		t.Fatalf("Failed to write quotas file: %v", err)
+	}
+
+	quotas, err := loadTenantQuotas(&config.Config{TenantNotificationsPerDay: 100, TenantMaxTemplates: 20, TenantMaxChannels: 3, TenantQuotasFile: path})
+	if err != nil {
+		t.Fatalf("Failed to load quotas: %v", err)
+	}
+	if quota := quotas.quotaFor("team-b"); quota != (TenantQuota{NotificationsPerDay: 100, Templates: 20, Channels: 3}) {
+		t.Errorf("Expected the defaults for a tenant without overrides, got %+v", quota)
+	}
+	if quota := quotas.quotaFor("team-a"); quota != (TenantQuota{NotificationsPerDay: 5000, Templates: 20, Channels: 0}) {
+		t.Errorf("Expected the overrides with the defaults for limits left out, got %+v", quota)
+	}
+
+	for name, contents := range map[string]string{
+		"negative limit": `{"team-a": {"templates": -1}}`,
+		"bad tenant":     `{"team/a": {"templates": 1}}`,
+		"malformed":      `[]`,
+	} {
+		if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+			t.Fatalf("Failed to write quotas file: %v", err)
+		}
+		if _, err := loadTenantQuotas(&config.Config{TenantQuotasFile: path}); err == nil {
+			t.Errorf("%s: expected the quotas file to be rejected", name)
+		}
+	}
+	if _, err := loadTenantQuotas(&config.Config{TenantQuotasFile: filepath.Join(t.TempDir(), "missing.json")}); err == nil {
+		t.Error("Expected a missing quotas file to be rejected")
+	}
+}
+
+func TestCheckQuota(t *testing.T) {
+	if err := checkQuota(0, 1000, "templates"); err != nil {
+		t.Errorf("Expected a limit of 0 to be unlimited, got %v", err)
+	}
+	if err := checkQuota(10, 9, "templates"); err != nil {
+		t.Errorf("Expected usage below the limit to be allowed, got %v", err)
+	}
+	if err := checkQuota(10, 10, "templates"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded at the limit, got %v", err)
+	}
+
+	// An unlimited quota neither locks the tenant nor counts its usage
+	counted := false
+	if err := reserveQuota(nil, "team-a", 0, "templates", func(*gorm.DB) (int64, error) {
+		counted = true
+		return 0, nil
+	}); err != nil || counted {
+		t.Errorf("Expected an unlimited quota to be reserved without counting, got %v (counted %v)", err, counted)
+	}
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"gorm.io/gorm"
+)
+
+// trackingClaims identifies the notification, and for clicks the link, a tracking
+// token was issued for
+type trackingClaims struct {
+	Notification uint `json:"n"`
+	Link         uint `json:"l,omitempty"`
+}
+
+// trackingURL returns the signed open pixel or click redirect URL for a claim
+func trackingURL(cfg *config.Config, kind string, claims trackingClaims) (string, error) {
+	secret, err := linkSecret(cfg)
+	if err != nil {
+		return "", err
+	}
+	token, err := signToken(secret, claims)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(cfg.PublicURL, "/") + "/track/" + kind + "/" + token, nil
+}
+
+// trackingEnabled reports whether opens and clicks are tracked for an email, following
+// TRACK_OPENS and TRACK_CLICKS unless its category is listed in UNTRACKED_CATEGORIES or
+// its template disables tracking
+func (s *NotificationService) trackingEnabled(notification *models.Notification) (opens, clicks bool) {
+	opens, clicks = s.config.TrackOpens, s.config.TrackClicks
+	if !opens && !clicks {
+		return false, false
+	}
+
+	for _, category := range s.config.UntrackedCategories {
+		if notification.Category != "" && category == notification.Category {
+			return false, false
+		}
+	}
+
+	if notification.TemplateID != nil {
+		var tmpl models.Template
+		if err := s.db.Select("disable_tracking").First(&tmpl, *notification.TemplateID).Error; err == nil && tmpl.DisableTracking {
+			return false, false
+		}
+	}
+
+	return opens, clicks
+}
+
+// prepareTracking decides whether an email is tracked and records the links in its
+// HTML body that the click redirect may send readers to
+func (s *NotificationService) prepareTracking(notification *models.Notification) error {
+	if notification.HTMLMessage == "" {
+		return nil
+	}
+	opens, clicks := s.trackingEnabled(notification)
+	notification.TrackOpens = opens
+	notification.TrackClicks = clicks
+	if !clicks || len(notification.Links) > 0 {
+		return nil
+	}
+
+	// Links were recorded by an earlier attempt to send a scheduled email
+	if err := s.db.Where("notification_id = ?", notification.ID).Find(&notification.Links).Error; err != nil {
+		return err
+	}
+	if len(notification.Links) > 0 {
+		return nil
+	}
+
+	urls, err := trackableLinks(notification.HTMLMessage, strings.TrimRight(s.config.PublicURL, "/")+"/unsubscribe/")
+	if err != nil {
+		return err
+	}
+	for _, link := range urls {
+		notification.Links = append(notification.Links, models.TrackedLink{NotificationID: notification.ID, URL: link})
+	}
+	if len(notification.Links) == 0 {
+		return nil
+	}
+	return s.db.Create(&notification.Links).Error
+}
+
+// trackableLinks returns the distinct http and https links in an HTML body, in order,
+// except those starting with skipPrefix
+func trackableLinks(body, skipPrefix string) ([]string, error) {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var links []string
+	seen := make(map[string]bool)
+	walkHTML(doc, func(n *html.Node) {
+		if n.Type != html.ElementNode || n.DataAtom != atom.A {
+			return
+		}
+		href := strings.TrimSpace(htmlAttr(n, "href"))
+		u, err := url.Parse(href)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || strings.HasPrefix(href, skipPrefix) || seen[href] {
+			return
+		}
+		seen[href] = true
+		links = append(links, href)
+	})
+	return links, nil
+}
+
+// addTracking rewrites the links of an HTML body found in redirects to their click
+// tracking URLs and, when pixel is set, appends an open tracking image to the body
+func addTracking(body string, redirects map[string]string, pixel string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+
+	var bodyNode *html.Node
+	walkHTML(doc, func(n *html.Node) {
+		if n.Type != html.ElementNode {
+			return
+		}
+		switch n.DataAtom {
+		case atom.Body:
+			bodyNode = n
+		case atom.A:
+			for i, attr := range n.Attr {
+				if redirect, ok := redirects[strings.TrimSpace(attr.Val)]; attr.Key == "href" && ok {
+					n.Attr[i].Val = redirect
+				}
+			}
+		}
+	})
+
+	if pixel != "" && bodyNode != nil {
+		bodyNode.AppendChild(&html.Node{
+			Type:     html.ElementNode,
+			Data:     "img",
+			DataAtom: atom.Img,
+			Attr: []html.Attribute{
+				{Key: "src", Val: pixel},
+				{Key: "width", Val: "1"},
+				{Key: "height", Val: "1"},
+				{Key: "alt", Val: ""},
+				{Key: "style", Val: "display:block;border:0;width:1px;height:1px"},
+			},
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// trackedHTML adds the open pixel and click redirects an email is tracked with
+func (e *EmailSender) trackedHTML(notification *models.Notification, body string) (string, error) {
+	redirects := make(map[string]string)
+	if notification.TrackClicks {
+		for _, link := range notification.Links {
+			redirect, err := trackingURL(e.config, "click", trackingClaims{Notification: notification.ID, Link: link.ID})
+			if err != nil {
+				return "", err
+			}
+			redirects[link.URL] = redirect
+		}
+	}
+
+	var pixel string
+	if notification.TrackOpens {
+		var err error
+		if pixel, err = trackingURL(e.config, "open", trackingClaims{Notification: notification.ID}); err != nil {
+			return "", err
+		}
+	}
+
+	if len(redirects) == 0 && pixel == "" {
+		return body, nil
+	}
+	return addTracking(body, redirects, pixel)
+}
+
+// RecordOpen records that the email a signed open tracking token was issued for was opened
+func (s *NotificationService) RecordOpen(token string) error {
+	secret, err := linkSecret(s.config)
+	if err != nil {
+		return err
+	}
+	var claims trackingClaims
+	if err := verifyToken(secret, token, &claims); err != nil {
+		return err
+	}
+	if claims.Notification == 0 || claims.Link != 0 {
+		return ErrInvalidToken
+	}
+
+	return s.db.Model(&models.Notification{}).Where("id = ?", claims.Notification).Updates(map[string]interface{}{
+		"opens":     gorm.Expr("opens + 1"),
+		"opened_at": gorm.Expr("COALESCE(opened_at, ?)", time.Now()),
+	}).Error
+}
+
+// RecordClick records a click on a tracked link and returns the URL to redirect to.
+// Only links recorded from the email when it was sent can be redirected to.
+func (s *NotificationService) RecordClick(token string) (string, error) {
+	secret, err := linkSecret(s.config)
+	if err != nil {
+		return "", err
+	}
+	var claims trackingClaims
+	if err := verifyToken(secret, token, &claims); err != nil {
+		return "", err
+	}
+	if claims.Notification == 0 || claims.Link == 0 {
+		return "", ErrInvalidToken
+	}
+
+	var link models.TrackedLink
+	if err := s.db.Where("id = ? AND notification_id = ?", claims.Link, claims.Notification).First(&link).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", ErrInvalidToken
+		}
+		return "", err
+	}
+
+	now := time.Now()
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&link).Updates(map[string]interface{}{
+			"clicks":     gorm.Expr("clicks + 1"),
+			"clicked_at": gorm.Expr("COALESCE(clicked_at, ?)", now),
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Notification{}).Where("id = ?", claims.Notification).
+			Update("clicked_at", gorm.Expr("COALESCE(clicked_at, ?)", now)).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return link.URL, nil
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"bytes"
+	"io"
+	"mime/quotedprintable"
+	"strings"
+	"testing"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+)
+
+func TestTrackableLinks(t *testing.T) {
+	body := `<p><a href="https://example.com/a">A</a> <a href="mailto:ada@example.com">Mail</a>
+<a href="https://example.com/a">A again</a> <a href="http://example.com/b">B</a>
+<a href="https://notify.example.com/unsubscribe/token">Unsubscribe</a> <a href="#top">Top</a></p>`
+
+	links, err := trackableLinks(body, "https://notify.example.com/unsubscribe/")
+	if err != nil {
+		t.Fatalf("Failed to find links: %v", err)
+	}
+	if strings.Join(links, " ") != "https://example.com/a http://example.com/b" {
+		t.Errorf("Unexpected links %v", links)
+	}
+}
+
+func TestEmailSenderTracking(t *testing.T) {
+	cfg := &config.Config{EmailFromAddress: "noreply@example.com", PublicURL: "https://notify.example.com", LinkSigningSecret: "secret"}
+	sender := NewEmailSender(cfg, nil, nil)
+
+	notification := &models.Notification{
+		ID:          42,
+		Title:       "News",
+		Recipient:   "ada@example.com",
+		HTMLMessage: `<html><body><p>Read <a href="https://example.com/post">the post</a> or <a href="https://example.com/other">this</a></p></body></html>`,
+		Links:       []models.TrackedLink{{ID: 7, NotificationID: 42, URL: "https://example.com/post"}},
+		TrackOpens:  true,
+		TrackClicks: true,
+	}
+
+	m, err := sender.buildMessage(notification)
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+	var buf bytes.Buffer
+	m.WriteTo(&buf)
+	decoded, _ := io.ReadAll(quotedprintable.NewReader(&buf))
+	raw := string(decoded)
+
+	click, _ := trackingURL(cfg, "click", trackingClaims{Notification: 42, Link: 7})
+	open, _ := trackingURL(cfg, "open", trackingClaims{Notification: 42})
+	for _, expected := range []string{
+		`<a href="` + click + `">the post</a>`,
+		`<a href="https://example.com/other">this</a>`,
+		`<img src="` + open + `" width="1" height="1"`,
+		// The text part keeps the original links
+		"the post (https://example.com/post)",
+	} {
+		if !strings.Contains(raw, expected) {
+			t.Errorf("Expected %q in:\n%s", expected, raw)
+		}
+	}
+
+	var claims trackingClaims
+	token := click[strings.LastIndex(click, "/")+1:]
+	if err := verifyToken([]byte(cfg.LinkSigningSecret), token, &claims); err != nil || claims.Notification != 42 || claims.Link != 7 {
+		t.Errorf("Unexpected click token claims %+v (%v)", claims, err)
+	}
+
+	notification.TrackOpens, notification.TrackClicks = false, false
+	m, _ = sender.buildMessage(notification)
+	buf.Reset()
+	m.WriteTo(&buf)
+	if strings.Contains(buf.String(), "/track/") {
+		t.Error("Expected no tracking when it is disabled")
+	}
+}
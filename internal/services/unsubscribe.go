@@ -0,0 +1,184 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+)
+
+var (
+	// ErrInvalidToken is returned when a signed link token is malformed or its signature
+	// does not match
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrLinkSecretNotConfigured is returned when a signed link is issued or verified
+	// without LINK_SIGNING_SECRET
+	ErrLinkSecretNotConfigured = errors.New("LINK_SIGNING_SECRET is not configured")
+)
+
+// unsubscribeClaims identifies the tenant, recipient and category an unsubscribe link
+// is for. Links issued before tenants carry no tenant and are the default tenant's.
+type unsubscribeClaims struct {
+	Tenant   string `json:"t,omitempty"`
+	Address  string `json:"a"`
+	Category string `json:"c"`
+}
+
+// linkSecret returns the key signed links are authenticated with. It is kept apart
+// from JWT_SECRET so that a bearer-token key never signs public links.
+func linkSecret(cfg *config.Config) ([]byte, error) {
+	if cfg.LinkSigningSecret == "" {
+		return nil, ErrLinkSecretNotConfigured
+	}
+	return []byte(cfg.LinkSigningSecret), nil
+}
+
+// signToken encodes claims as a URL-safe token authenticated with HMAC-SHA256
+func signToken(secret []byte, claims interface{}) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(encoded))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// verifyToken checks a token's signature and decodes its claims
+func verifyToken(secret []byte, token string, claims interface{}) error {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return ErrInvalidToken
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(encoded))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return ErrInvalidToken
+	}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// unsubscribeURL returns the signed link that unsubscribes an address from a tenant's category
+func unsubscribeURL(cfg *config.Config, tenantID, address, category string) (string, error) {
+	if category == "" {
+		return "", errors.New("unsubscribe links require a notification category")
+	}
+	secret, err := linkSecret(cfg)
+	if err != nil {
+		return "", err
+	}
+	token, err := signToken(secret, unsubscribeClaims{Tenant: tenantID, Address: strings.ToLower(address), Category: category})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(cfg.PublicURL, "/") + "/unsubscribe/" + token, nil
+}
+
+// notificationUnsubscribeURL returns the unsubscribe link of an email. The link is
+// signed for one address, so email to several addresses has none: a CC or BCC reader
+// following it would unsubscribe the primary recipient.
+func notificationUnsubscribeURL(cfg *config.Config, notification *models.Notification) (string, error) {
+	if len(notification.Deliveries) > 1 {
+		return "", fmt.Errorf("%w: unsubscribe links are only available in email to a single address", ErrInvalidRecipient)
+	}
+	return unsubscribeURL(cfg, notification.TenantID, notification.Recipient, notification.Category)
+}
+
+// Unsubscribe records that the recipient of a signed unsubscribe token no longer
+// wants notifications in the token's category
+func (s *NotificationService) Unsubscribe(token string) (*models.Recipient, string, error) {
+	secret, err := linkSecret(s.config)
+	if err != nil {
+		return nil, "", err
+	}
+	var claims unsubscribeClaims
+	if err := verifyToken(secret, token, &claims); err != nil {
+		return nil, "", err
+	}
+	if claims.Address == "" || claims.Category == "" {
+		return nil, "", ErrInvalidToken
+	}
+
+	if claims.Tenant == "" {
+		claims.Tenant = models.DefaultTenant
+	}
+
+	recipient, err := s.UpdateRecipientCategories(claims.Tenant, claims.Address, map[string]bool{claims.Category: false})
+	if err != nil {
+		return nil, "", err
+	}
+	return recipient, claims.Category, nil
+}
+
+// UpdateRecipientCategories merges category subscriptions into the preferences of a
+// tenant's recipient, creating the recipient if needed
+func (s *NotificationService) UpdateRecipientCategories(tenantID, address string, categories map[string]bool) (*models.Recipient, error) {
+	var recipient models.Recipient
+	if err := s.db.Where(models.Recipient{TenantID: tenantID, Address: address}).FirstOrInit(&recipient).Error; err != nil {
+		return nil, err
+	}
+
+	if recipient.Categories == nil {
+		recipient.Categories = make(models.JSON, len(categories))
+	}
+	for category, subscribed := range categories {
+		recipient.Categories[category] = subscribed
+	}
+
+	if err := s.db.Save(&recipient).Error; err != nil {
+		return nil, err
+	}
+	return &recipient, nil
+}
+
+// unsubscribedAddresses returns which of the given lowercased addresses have
+// unsubscribed from a tenant's category
+func (s *NotificationService) unsubscribedAddresses(tenantID, category string, addresses []string) (map[string]bool, error) {
+	unsubscribed := make(map[string]bool)
+	if category == "" {
+		return unsubscribed, nil
+	}
+
+	var recipients []models.Recipient
+	if err := s.db.Where("tenant_id = ? AND LOWER(address) IN ?", tenantID, addresses).Find(&recipients).Error; err != nil {
+		return nil, err
+	}
+	for _, recipient := range recipients {
+		if subscribed, ok := recipient.Categories[category].(bool); ok && !subscribed {
+			unsubscribed[strings.ToLower(recipient.Address)] = true
+		}
+	}
+	return unsubscribed, nil
+}
+
+// unsubscribeHeaders returns the RFC 2369 and RFC 8058 one-click unsubscribe headers
+// for an email in a tenant's category
+func unsubscribeHeaders(cfg *config.Config, notification *models.Notification) (map[string]string, error) {
+	link, err := notificationUnsubscribeURL(cfg, notification)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"List-Unsubscribe":      fmt.Sprintf("<%s>", link),
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}, nil
+}
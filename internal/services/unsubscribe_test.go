@@ -0,0 +1,155 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+)
+
+func TestSignedTokens(t *testing.T) {
+	secret := []byte("secret")
+	token, err := signToken(secret, unsubscribeClaims{Address: "ada@example.com", Category: "newsletter"})
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+
+	var claims unsubscribeClaims
+	if err := verifyToken(secret, token, &claims); err != nil {
+		t.Fatalf("Failed to verify token: %v", err)
+	}
+	if claims.Address != "ada@example.com" || claims.Category != "newsletter" {
+		t.Errorf("Unexpected claims %+v", claims)
+	}
+
+	forged, _ := signToken(secret, unsubscribeClaims{Address: "grace@example.com", Category: "newsletter"})
+	tampered := strings.SplitN(forged, ".", 2)[0] + "." + strings.SplitN(token, ".", 2)[1]
+	for _, bad := range []string{tampered, "garbage", token + "x"} {
+		if err := verifyToken(secret, bad, &claims); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Expected ErrInvalidToken for %q, got %v", bad, err)
+		}
+	}
+	if err := verifyToken([]byte("other"), token, &claims); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected a token signed with another secret to be rejected, got %v", err)
+	}
+}
+
+func TestEmailSenderUnsubscribeHeaders(t *testing.T) {
+	cfg := &config.Config{EmailFromAddress: "noreply@example.com", PublicURL: "https://notify.example.com/", LinkSigningSecret: "secret"}
+	sender := NewEmailSender(cfg, nil, nil)
+
+	for _, tt := range []struct {
+		category string
+		expected bool
+	}{
+		{"newsletter", true},
+		{"", false},
+	} {
+		notification := &models.Notification{TenantID: models.DefaultTenant, Title: "News", Recipient: "Ada@example.com", Message: "Hello", Category: tt.category}
+		m, err := sender.buildMessage(notification)
+		if err != nil {
+			t.Fatalf("Failed to build message: %v", err)
+		}
+		var buf bytes.Buffer
+		m.WriteTo(&buf)
+		raw := buf.String()
+
+		if got := strings.Contains(raw, "List-Unsubscribe-Post: List-Unsubscribe=One-Click"); got != tt.expected {
+			t.Errorf("Category %q: expected one-click header %v, got %v", tt.category, tt.expected, got)
+		}
+		if !tt.expected {
+			continue
+		}
+
+		link, _ := unsubscribeURL(cfg, notification.TenantID, notification.Recipient, tt.category)
+		if !strings.Contains(raw, "List-Unsubscribe: <"+link+">") {
+			t.Errorf("Expected List-Unsubscribe header with %s in:\n%s", link, raw)
+		}
+		if !strings.HasPrefix(link, "https://notify.example.com/unsubscribe/") {
+			t.Errorf("Unexpected unsubscribe link %s", link)
+		}
+	}
+}
+
+func TestUnsubscribeURLTemplateHelper(t *testing.T) {
+	cfg := &config.Config{PublicURL: "https://notify.example.com", LinkSigningSecret: "secret"}
+	env := newFuncEnv("en", time.Now())
+	env.unsubscribeURL = func() (string, error) {
+		return unsubscribeURL(cfg, "team-a", "ada@example.com", "newsletter")
+	}
+
+	result, err := renderTemplate("html_content", `<a href="{{unsubscribe_url}}">Unsubscribe</a>`, HTMLFormat, models.JSON{}, &renderContext{Funcs: env.funcs()})
+	if err != nil {
+		t.Fatalf("Failed to render template: %v", err)
+	}
+	link, _ := unsubscribeURL(cfg, "team-a", "ada@example.com", "newsletter")
+	if result != `<a href="`+link+`">Unsubscribe</a>` {
+		t.Errorf("Unexpected result %s", result)
+	}
+
+	_, err = renderTemplate("content", "{{unsubscribe_url}}", TextFormat, models.JSON{}, &renderContext{Funcs: templateFuncs("en", time.Now())})
+	if err == nil {
+		t.Error("Expected unsubscribe_url to fail outside email notifications")
+	}
+}
+
+func TestUnsubscribeURLCarriesTenant(t *testing.T) {
+	cfg := &config.Config{PublicURL: "https://notify.example.com", LinkSigningSecret: "secret"}
+	link, err := unsubscribeURL(cfg, "team-a", "Ada@example.com", "newsletter")
+	if err != nil {
+		t.Fatalf("Failed to build unsubscribe link: %v", err)
+	}
+
+	var claims unsubscribeClaims
+	if err := verifyToken([]byte("secret"), strings.TrimPrefix(link, "https://notify.example.com/unsubscribe/"), &claims); err != nil {
+		t.Fatalf("Failed to verify token: %v", err)
+	}
+	if claims != (unsubscribeClaims{Tenant: "team-a", Address: "ada@example.com", Category: "newsletter"}) {
+		t.Errorf("Expected the link to name the tenant, recipient and category, got %+v", claims)
+	}
+}
+
+func TestUnsubscribeOmittedForSeveralRecipients(t *testing.T) {
+	cfg := &config.Config{EmailFromAddress: "noreply@example.com", PublicURL: "https://notify.example.com", LinkSigningSecret: "secret"}
+	sender := NewEmailSender(cfg, nil, nil)
+	notification := &models.Notification{
+		TenantID:  models.DefaultTenant,
+		Title:     "News",
+		Recipient: "ada@example.com",
+		Message:   "Hello",
+		Category:  "newsletter",
+		Deliveries: []models.Delivery{
+			{Address: "ada@example.com", Kind: models.ToDelivery},
+			{Address: "grace@example.com", Kind: models.CCDelivery},
+			{Address: "alan@example.com", Kind: models.BCCDelivery},
+		},
+	}
+
+	m, err := sender.buildMessage(notification)
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+	var buf bytes.Buffer
+	m.WriteTo(&buf)
+	if strings.Contains(buf.String(), "List-Unsubscribe") {
+		t.Errorf("Expected no unsubscribe headers in email to several addresses:\n%s", buf.String())
+	}
+
+	env := newFuncEnv("en", time.Now())
+	env.unsubscribeURL = func() (string, error) {
+		return notificationUnsubscribeURL(cfg, notification)
+	}
+	_, err = renderTemplate("html_content", `<a href="{{unsubscribe_url}}">Unsubscribe</a>`, HTMLFormat, models.JSON{}, &renderContext{Funcs: env.funcs()})
+	if !errors.Is(err, ErrInvalidRecipient) {
+		t.Errorf("Expected unsubscribe_url to fail with ErrInvalidRecipient in email to several addresses, got %v", err)
+	}
+
+	notification.Deliveries = notification.Deliveries[:1]
+	if _, err := notificationUnsubscribeURL(cfg, notification); err != nil {
+		t.Errorf("Expected a link for email to a single address, got %v", err)
+	}
+}
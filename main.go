@@ -31,6 +31,13 @@ func main() {
 
 	// Initialize services
 	notificationService := services.NewNotificationService(db)
+
+	// Re-encrypt channel secrets stored in plaintext or under a previous master key
+	if rotated, err := notificationService.RotateAllChannelSecrets(); err != nil {
+		log.Println("Failed to encrypt channel secrets:", err)
+	} else if rotated > 0 {
+		log.Printf("Re-encrypted the secrets of %d channels", rotated)
+	}
 	schedulerService := scheduler.NewScheduler(notificationService)
 
 	// Start the scheduler
@@ -39,6 +46,16 @@ func main() {
 	// Initialize handlers
 	handler := handlers.NewHandler(notificationService, schedulerService)
 
+	if cfg.LinkSigningSecret == "" {
+		log.Println("LINK_SIGNING_SECRET is not set; email in a category and tracked email cannot be sent")
+	}
+
+	// API requests are authenticated with a JWT bearer token or an API key
+	verifier, err := services.NewJWTVerifier(cfg)
+	if err != nil {
+		log.Fatal("Failed to configure JWT verification:", err)
+	}
+
 	// Setup router
 	router := gin.Default()
 
@@ -46,7 +63,7 @@ func main() {
 	router.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
 		
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -56,29 +73,82 @@ func main() {
 		c.Next()
 	})
 
-	// API routes
-	api := router.Group("/api/v1")
+	// Bounce webhooks come from email providers and are authenticated by their token
+	router.POST("/api/v1/webhooks/bounces", handler.ReceiveBounceWebhook)
+
+	// API routes, each requiring the scope the caller's token or API key must grant
+	api := router.Group("/api/v1", handlers.Authenticate(verifier, notificationService))
 	{
+		send := handlers.RequireScope(services.ScopeNotificationsSend)
+		read := handlers.RequireScope(services.ScopeNotificationsRead)
+		templatesRead := handlers.RequireScope(services.ScopeTemplatesRead)
+		templatesWrite := handlers.RequireScope(services.ScopeTemplatesWrite)
+		recipientsAdmin := handlers.RequireScope(services.ScopeRecipientsAdmin)
+		channelsAdmin := handlers.RequireScope(services.ScopeChannelsAdmin)
+		apiKeysAdmin := handlers.RequireScope(services.ScopeAPIKeysAdmin)
+
 		// Notification routes
-		api.POST("/notifications", handler.SendNotification)
-		api.POST("/notifications/schedule", handler.ScheduleNotification)
-		api.GET("/notifications", handler.GetNotifications)
-		api.GET("/notifications/:id", handler.GetNotification)
-		api.PUT("/notifications/:id", handler.UpdateNotification)
-		api.DELETE("/notifications/:id", handler.DeleteNotification)
+		api.POST("/notifications", send, handler.SendNotification)
+		api.POST("/notifications/schedule", send, handler.ScheduleNotification)
+		api.GET("/notifications", read, handler.GetNotifications)
+		api.GET("/notifications/:id", read, handler.GetNotification)
+		api.PUT("/notifications/:id", send, handler.UpdateNotification)
+		api.DELETE("/notifications/:id", send, handler.DeleteNotification)
 
 		// Template routes
-		api.POST("/templates", handler.CreateTemplate)
-		api.GET("/templates", handler.GetTemplates)
-		api.GET("/templates/:id", handler.GetTemplate)
-		api.PUT("/templates/:id", handler.UpdateTemplate)
-		api.DELETE("/templates/:id", handler.DeleteTemplate)
+		api.POST("/templates", templatesWrite, handler.CreateTemplate)
+		api.GET("/templates", templatesRead, handler.GetTemplates)
+		api.GET("/templates/functions", templatesRead, handler.GetTemplateFunctions)
+		api.GET("/templates/:id", templatesRead, handler.GetTemplate)
+		api.PUT("/templates/:id", templatesWrite, handler.UpdateTemplate)
+		api.DELETE("/templates/:id", templatesWrite, handler.DeleteTemplate)
+
+		// DKIM routes
+		api.GET("/dkim/record", channelsAdmin, handler.GetDKIMRecord)
+
+		// Suppression routes
+		api.GET("/suppressions", recipientsAdmin, handler.GetSuppressions)
+		api.POST("/suppressions", recipientsAdmin, handler.CreateSuppression)
+		api.DELETE("/suppressions/:address", recipientsAdmin, handler.DeleteSuppression)
+
+		// Blob routes
+		api.POST("/blobs", send, handler.UploadBlob)
+		api.GET("/blobs/:id", read, handler.GetBlob)
+
+		// Recipient routes
+		api.GET("/recipients/:address", recipientsAdmin, handler.GetRecipient)
+		api.PUT("/recipients/:address", recipientsAdmin, handler.UpdateRecipient)
 
 		// Channel routes
-		api.GET("/channels", handler.GetChannels)
-		api.POST("/channels/test", handler.TestChannel)
+		api.GET("/channels", channelsAdmin, handler.GetChannels)
+		api.GET("/channels/:id", channelsAdmin, handler.GetChannel)
+		api.POST("/channels", channelsAdmin, handler.CreateChannel)
+		api.PUT("/channels/:id", channelsAdmin, handler.UpdateChannel)
+		api.DELETE("/channels/:id", channelsAdmin, handler.DeleteChannel)
+		api.POST("/channels/test", channelsAdmin, handler.TestChannel)
+		api.POST("/channels/secrets/rotate", channelsAdmin, handler.RotateChannelSecrets)
+		api.GET("/channels/slack/throttle", channelsAdmin, handler.GetSlackThrottle)
+
+		// API key routes
+		api.GET("/api-keys", apiKeysAdmin, handler.GetAPIKeys)
+		api.POST("/api-keys", apiKeysAdmin, handler.CreateAPIKey)
+		api.DELETE("/api-keys/:id", apiKeysAdmin, handler.DeleteAPIKey)
+
+		// Tenant routes
+		api.GET("/tenant/usage", read, handler.GetTenantUsage)
 	}
 
+	// Unsubscribe links are public and authenticated by their signed token
+	router.GET("/unsubscribe/:token", handler.ShowUnsubscribe)
+	router.POST("/unsubscribe/:token", handler.Unsubscribe)
+
+	// Tracking links in outbound email are public and authenticated by their signed token
+	router.GET("/track/open/:token", handler.TrackOpen)
+	router.GET("/track/click/:token", handler.TrackClick)
+
+	// Slack interactivity requests are authenticated by their signature
+	router.POST("/slack/interactions", handler.SlackInteractions)
+
 	// Health check
 	router.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "ok", "service": "notification-service"})
@@ -85,7 +85,7 @@ func main() {
 
 	// Example 4: Get notifications with filtering
 	fmt.Println("\n=== Example 4: Get Notifications ===")
-	notifications, total, err := notificationService.GetNotifications(10, 0, "", "")
+	notifications, total, err := notificationService.GetNotifications(models.DefaultTenant, 10, 0, "", "")
 	if err != nil {
 		log.Printf("Failed to get notifications: %v", err)
 	} else {